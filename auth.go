@@ -0,0 +1,380 @@
+// auth
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// authProtoDelayedV4 is DHCPv4 delayed authentication protocol, RFC3118
+	authProtoDelayedV4 uint8 = 1
+	// authProtoDelayedV6 is DHCPv6 delayed authentication protocol, RFC3315 section 21.4
+	authProtoDelayedV6      uint8 = 2
+	authProtoReconfigureKey uint8 = 3
+	// authProtoForceRenewNonce is the DHCPv4 Forcerenew Nonce Authentication protocol, RFC6704
+	authProtoForceRenewNonce uint8 = 3
+	authAlgHMACMD5           uint8 = 1
+	authRDMMonotonic         uint8 = 0
+	// authHeaderLen is the length of protocol, algorithm, RDM and replay detection fields
+	authHeaderLen = 11
+	// authDigestLen is the length of HMAC-MD5 digest, which is at the end of authentication information
+	authDigestLen = md5.Size
+	// authKeyIDLen is the length of key id (secret id) of delayed authentication
+	authKeyIDLen = 4
+	// types of Reconfigure Key authentication information, RFC8415 section 20.4.1
+	reconfKeyTypeKey  uint8 = 1
+	reconfKeyTypeHMAC uint8 = 2
+	// types of Forcerenew Nonce authentication information, RFC6704 section 3.3
+	forceRenewNonceTypeNonce uint8 = 1
+	forceRenewNonceTypeHMAC  uint8 = 2
+	// offsets of DHCPv4 header fields that are zero in digest computation, and of options, RFC3118 section 4
+	v4HopsOffset    = 3
+	v4GiAddrOffset  = 24
+	v4OptionsOffset = 240
+)
+
+// authOption is the content of DHCPv6 Authentication option (RFC8415 section 21.11),
+// which has the same format as DHCPv4 Authentication option (RFC3118)
+type authOption struct {
+	Protocol        uint8
+	Algorithm       uint8
+	RDM             uint8
+	ReplayDetection uint64
+	Info            []byte
+}
+
+func parseAuthOption(data []byte) (*authOption, error) {
+	if len(data) < authHeaderLen {
+		return nil, fmt.Errorf("authentication option is too short, %d bytes", len(data))
+	}
+	return &authOption{
+		Protocol:        data[0],
+		Algorithm:       data[1],
+		RDM:             data[2],
+		ReplayDetection: binary.BigEndian.Uint64(data[3:11]),
+		Info:            data[authHeaderLen:],
+	}, nil
+}
+
+func (ao *authOption) ToBytes() []byte {
+	r := []byte{ao.Protocol, ao.Algorithm, ao.RDM}
+	r = binary.BigEndian.AppendUint64(r, ao.ReplayDetection)
+	return append(r, ao.Info...)
+}
+
+func (ao *authOption) v6Option() dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: ao.ToBytes()}
+}
+
+// v6AuthOption returns the Authentication option in opts, nil if there is none
+func v6AuthOption(opts dhcpv6.Options) (*authOption, error) {
+	op := opts.GetOne(dhcpv6.OptionAuth)
+	if op == nil {
+		return nil, nil
+	}
+	return parseAuthOption(op.ToBytes())
+}
+
+func (ao *authOption) v4Option() dhcpv4.Option {
+	return dhcpv4.OptGeneric(dhcpv4.OptionAuthentication, ao.ToBytes())
+}
+
+// v4AuthOption returns the Authentication option in opts, nil if there is none
+func v4AuthOption(opts dhcpv4.Options) (*authOption, error) {
+	data := opts.Get(dhcpv4.OptionAuthentication)
+	if data == nil {
+		return nil, nil
+	}
+	return parseAuthOption(data)
+}
+
+// v6AuthDigestOffset returns the offset of HMAC-MD5 digest of Authentication option in DHCPv6 message raw
+func v6AuthDigestOffset(raw []byte) (int, error) {
+	//msg-type and transaction-id
+	for i := 4; i+4 <= len(raw); {
+		code := dhcpv6.OptionCode(binary.BigEndian.Uint16(raw[i : i+2]))
+		length := int(binary.BigEndian.Uint16(raw[i+2 : i+4]))
+		end := i + 4 + length
+		if end > len(raw) {
+			return 0, fmt.Errorf("option %v exceeds message", code)
+		}
+		if code == dhcpv6.OptionAuth {
+			if length < authHeaderLen+authDigestLen {
+				return 0, fmt.Errorf("authentication option has no digest")
+			}
+			return end - authDigestLen, nil
+		}
+		i = end
+	}
+	return 0, fmt.Errorf("no authentication option")
+}
+
+// v4AuthDigestOffset returns the offset of HMAC-MD5 digest of Authentication option in DHCPv4 message raw
+func v4AuthDigestOffset(raw []byte) (int, error) {
+	for i := v4OptionsOffset; i < len(raw); {
+		code := raw[i]
+		switch code {
+		case dhcpv4.OptionPad.Code():
+			i++
+			continue
+		case dhcpv4.OptionEnd.Code():
+			return 0, fmt.Errorf("no authentication option")
+		}
+		if i+2 > len(raw) {
+			break
+		}
+		length := int(raw[i+1])
+		end := i + 2 + length
+		if end > len(raw) {
+			return 0, fmt.Errorf("option %d exceeds message", code)
+		}
+		if code == dhcpv4.OptionAuthentication.Code() {
+			if length < authHeaderLen+authDigestLen {
+				return 0, fmt.Errorf("authentication option has no digest")
+			}
+			return end - authDigestLen, nil
+		}
+		i = end
+	}
+	return 0, fmt.Errorf("no authentication option")
+}
+
+// v4DigestInput returns a copy of DHCPv4 message raw with hops and giaddr set to zero
+func v4DigestInput(raw []byte) ([]byte, error) {
+	if len(raw) < v4OptionsOffset {
+		return nil, fmt.Errorf("DHCPv4 message is too short, %d bytes", len(raw))
+	}
+	buf := make([]byte, len(raw))
+	copy(buf, raw)
+	buf[v4HopsOffset] = 0
+	copy(buf[v4GiAddrOffset:v4GiAddrOffset+net.IPv4len], make([]byte, net.IPv4len))
+	return buf, nil
+}
+
+// authDigest returns HMAC-MD5 of raw with key, digest at offset is treated as zero
+func authDigest(raw []byte, offset int, key []byte) []byte {
+	buf := make([]byte, len(raw))
+	copy(buf, raw)
+	copy(buf[offset:offset+authDigestLen], make([]byte, authDigestLen))
+	mac := hmac.New(md5.New, key)
+	mac.Write(buf)
+	return mac.Sum(nil)
+}
+
+// signV6 fills the HMAC-MD5 digest of Authentication option in DHCPv6 message raw with key
+func signV6(raw []byte, key []byte) error {
+	offset, err := v6AuthDigestOffset(raw)
+	if err != nil {
+		return err
+	}
+	copy(raw[offset:], authDigest(raw, offset, key))
+	return nil
+}
+
+// signV4 fills the HMAC-MD5 digest of Authentication option in DHCPv4 message raw with key
+func signV4(raw []byte, key []byte) error {
+	offset, err := v4AuthDigestOffset(raw)
+	if err != nil {
+		return err
+	}
+	buf, err := v4DigestInput(raw)
+	if err != nil {
+		return err
+	}
+	copy(raw[offset:], authDigest(buf, offset, key))
+	return nil
+}
+
+// verifyV4 checks the HMAC-MD5 digest of Authentication option in DHCPv4 message raw with key
+func verifyV4(raw []byte, key []byte) error {
+	offset, err := v4AuthDigestOffset(raw)
+	if err != nil {
+		return err
+	}
+	buf, err := v4DigestInput(raw)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(raw[offset:offset+authDigestLen], authDigest(buf, offset, key)) {
+		return fmt.Errorf("HMAC-MD5 digest mismatch")
+	}
+	return nil
+}
+
+// verifyV6 checks the HMAC-MD5 digest of Authentication option in DHCPv6 message raw with key
+func verifyV6(raw []byte, key []byte) error {
+	offset, err := v6AuthDigestOffset(raw)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(raw[offset:offset+authDigestLen], authDigest(raw, offset, key)) {
+		return fmt.Errorf("HMAC-MD5 digest mismatch")
+	}
+	return nil
+}
+
+// authMode is the authentication of DHCP clients
+type authMode int
+
+const (
+	authNone authMode = iota
+	// authDelayed is delayed authentication, RFC3118 for DHCPv4, RFC3315 for DHCPv6
+	authDelayed
+	// authReconfKey requires reconfigure key (RFC8415 section 20.4) in DHCPv6 Reply
+	authReconfKey
+)
+
+var authModeNames = map[authMode]string{
+	authNone:      "none",
+	authDelayed:   "delayed",
+	authReconfKey: "reconfkey",
+}
+
+func (am authMode) String() string {
+	buf, err := am.MarshalText()
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+func (am authMode) MarshalText() (text []byte, err error) {
+	if s, ok := authModeNames[am]; ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unknown authentication mode %d", am)
+}
+
+func (am *authMode) UnmarshalText(text []byte) error {
+	for m, name := range authModeNames {
+		if name == strings.ToLower(string(text)) {
+			*am = m
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown authentication mode %v", string(text))
+}
+
+// authKey is the delayed authentication key of a client
+type authKey struct {
+	ID    uint32
+	Key   []byte
+	Realm []byte //DHCP realm, DHCPv6 only
+}
+
+// authKeyDefault is the MAC of the key used by clients without their own key in authentication key file
+const authKeyDefault = "*"
+
+// authKeyEntry is one key in authentication key file, key is hex string
+type authKeyEntry struct {
+	MAC   string `yaml:"mac"`
+	KeyID uint32 `yaml:"keyid"`
+	Key   string `yaml:"key"`
+	Realm string `yaml:"realm"`
+}
+
+// loadAuthKeyFile loads authentication keys from inf, returns a map of keys, key of the map is MAC or authKeyDefault;
+// file with extension .csv is parsed as CSV, otherwise as YAML;
+// first row of a CSV file is the header with column names same as yaml tag of authKeyEntry
+func loadAuthKeyFile(inf string) (map[string]*authKey, error) {
+	data, err := os.ReadFile(inf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authentication key file %v, %w", inf, err)
+	}
+	var entries []authKeyEntry
+	if strings.ToLower(filepath.Ext(inf)) == ".csv" {
+		entries, err = parseAuthKeyCSV(string(data))
+	} else {
+		err = yaml.Unmarshal(data, &entries)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse authentication key file %v, %w", inf, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("there is no key in %v", inf)
+	}
+	r := make(map[string]*authKey)
+	for i, entry := range entries {
+		mac := authKeyDefault
+		if entry.MAC != authKeyDefault {
+			hwaddr, err := net.ParseMAC(entry.MAC)
+			if err != nil {
+				return nil, fmt.Errorf("key %d has invalid MAC %v, %w", i, entry.MAC, err)
+			}
+			mac = hwaddr.String()
+		}
+		if _, ok := r[mac]; ok {
+			return nil, fmt.Errorf("duplicated key of %v", entry.MAC)
+		}
+		key, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(entry.Key), "0x"))
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("key %d has invalid key %v, it should be a hex string", i, entry.Key)
+		}
+		r[mac] = &authKey{ID: entry.KeyID, Key: key, Realm: []byte(entry.Realm)}
+	}
+	return r, nil
+}
+
+func parseAuthKeyCSV(data string) ([]authKeyEntry, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	r := []authKeyEntry{}
+	for rowi, row := range records[1:] {
+		entry := authKeyEntry{}
+		for i, val := range row {
+			val = strings.TrimSpace(val)
+			switch strings.ToLower(strings.TrimSpace(header[i])) {
+			case "mac":
+				entry.MAC = val
+			case "keyid":
+				id, err := strconv.ParseUint(val, 0, 32)
+				if err != nil {
+					return nil, fmt.Errorf("row %d, invalid keyid %v, %w", rowi+2, val, err)
+				}
+				entry.KeyID = uint32(id)
+			case "key":
+				entry.Key = val
+			case "realm":
+				entry.Realm = val
+			default:
+				return nil, fmt.Errorf("unknown column %v", header[i])
+			}
+		}
+		r = append(r, entry)
+	}
+	return r, nil
+}
+
+// authKeyOf returns the delayed authentication key of client with mac
+func (setup *testSetup) authKeyOf(mac net.HardwareAddr) (*authKey, error) {
+	if key, ok := setup.authKeys[mac.String()]; ok {
+		return key, nil
+	}
+	if key, ok := setup.authKeys[authKeyDefault]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no authentication key for %v", mac)
+}
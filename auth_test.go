@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestLoadAuthKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	csvf := filepath.Join(dir, "keys.csv")
+	err := os.WriteFile(csvf, []byte(`mac,keyid,key,realm
+aa:bb:cc:00:00:01,1,0x0102030405,example.com
+*,0x10,a0a1a2,
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yamlf := filepath.Join(dir, "keys.yaml")
+	err = os.WriteFile(yamlf, []byte(`
+- mac: aa:bb:cc:00:00:01
+  keyid: 1
+  key: "0102030405"
+  realm: example.com
+- mac: "*"
+  keyid: 16
+  key: a0a1a2
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{csvf, yamlf} {
+		keys, err := loadAuthKeyFile(f)
+		if err != nil {
+			t.Fatalf("failed to load %v, %v", f, err)
+		}
+		setup := &testSetup{authKeys: keys}
+		key, err := setup.authKeyOf(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0, 0, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.ID != 1 || !bytes.Equal(key.Key, []byte{1, 2, 3, 4, 5}) || string(key.Realm) != "example.com" {
+			t.Fatalf("wrong key loaded from %v, %+v", f, key)
+		}
+		key, err = setup.authKeyOf(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0, 0, 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.ID != 16 || !bytes.Equal(key.Key, []byte{0xa0, 0xa1, 0xa2}) {
+			t.Fatalf("wrong default key loaded from %v, %+v", f, key)
+		}
+	}
+	badf := filepath.Join(dir, "bad.csv")
+	if err = os.WriteFile(badf, []byte("mac,keyid,key\naa:bb:cc:00:00:01,1,xyz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadAuthKeyFile(badf); err == nil {
+		t.Fatal("loading invalid key should fail")
+	}
+}
+
+// genDelayedAuth returns a delayed authentication option with key id kid, realm is included if it is not nil
+func genDelayedAuth(proto uint8, rd uint64, kid uint32, realm []byte) *authOption {
+	info := binary.BigEndian.AppendUint32(append([]byte{}, realm...), kid)
+	return &authOption{
+		Protocol:        proto,
+		Algorithm:       authAlgHMACMD5,
+		RDM:             authRDMMonotonic,
+		ReplayDetection: rd,
+		Info:            append(info, make([]byte, authDigestLen)...),
+	}
+}
+
+// readAuthConn reads from conn and returns the replay detection value of the message
+func readAuthConn(t *testing.T, conn net.PacketConn, isV6 bool) uint64 {
+	buf := make([]byte, demuxBufSize)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var auth *authOption
+	if isV6 {
+		msg, err := dhcpv6.MessageFromBytes(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, err = v6AuthOption(msg.Options.Options)
+	} else {
+		msg, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, err = v4AuthOption(msg.Options)
+	}
+	if err != nil || auth == nil {
+		t.Fatalf("no valid authentication option, %v", err)
+	}
+	return auth.ReplayDetection
+}
+
+func TestV4AuthConn(t *testing.T) {
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	key := &authKey{ID: 7, Key: []byte("secret")}
+	clnt, svr := conpair.NewPacketConnPair()
+	stats := new(authStats)
+	conn := newV4AuthConn(clnt, key, stats)
+	buf := make([]byte, demuxBufSize)
+	//discover has no authentication information, request is signed
+	disc, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest), dhcpv4.WithHwAddr(mac))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []*dhcpv4.DHCPv4{disc, req} {
+		if _, err = conn.WriteTo(msg.ToBytes(), nil); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := svr.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, err := v4AuthOption(sent.Options)
+		if err != nil || auth == nil || auth.Protocol != authProtoDelayedV4 {
+			t.Fatalf("%v has no delayed authentication option, %v", msg.MessageType(), err)
+		}
+		if msg == disc {
+			if len(auth.Info) != 0 {
+				t.Fatalf("discover has authentication information %x", auth.Info)
+			}
+			continue
+		}
+		if err = checkDelayedAuthInfo(auth.Info, key, false); err != nil {
+			t.Fatal(err)
+		}
+		if err = verifyV4(buf[:n], key.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	genAck := func(auth *authOption, k []byte) []byte {
+		ack, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeAck))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth == nil {
+			return ack.ToBytes()
+		}
+		ack.UpdateOption(auth.v4Option())
+		raw := ack.ToBytes()
+		if err = signV4(raw, k); err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	for _, raw := range [][]byte{
+		genAck(genDelayedAuth(authProtoDelayedV4, 10, 7, nil), []byte("wrong")),
+		genAck(nil, nil),
+		genAck(genDelayedAuth(authProtoDelayedV4, 10, 8, nil), key.Key),
+		genAck(genDelayedAuth(authProtoDelayedV4, 10, 7, nil), key.Key),
+		//replayed
+		genAck(genDelayedAuth(authProtoDelayedV4, 10, 7, nil), key.Key),
+		genAck(genDelayedAuth(authProtoDelayedV4, 11, 7, nil), key.Key),
+	} {
+		svr.WriteTo(raw, nil)
+	}
+	for _, expected := range []uint64{10, 11} {
+		if rd := readAuthConn(t, conn, false); rd != expected {
+			t.Fatalf("expect reply with replay detection %d, got %d", expected, rd)
+		}
+	}
+	if stats.V4Failed.Load() != 4 {
+		t.Fatalf("expect 4 failures, got\n%v", stats)
+	}
+	conn.Close()
+}
+
+func TestV6AuthConn(t *testing.T) {
+	key := &authKey{ID: 7, Key: []byte("secret"), Realm: []byte("example.com")}
+	genMsg := func(mt dhcpv6.MessageType, auth *authOption, k []byte) []byte {
+		msg, err := dhcpv6.NewMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.MessageType = mt
+		if auth == nil {
+			return msg.ToBytes()
+		}
+		msg.AddOption(auth.v6Option())
+		raw := msg.ToBytes()
+		if k != nil {
+			if err = signV6(raw, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return raw
+	}
+	//delayed authentication
+	clnt, svr := conpair.NewPacketConnPair()
+	stats := new(authStats)
+	conn := newV6AuthConn(clnt, authDelayed, key, stats)
+	buf := make([]byte, maxDHCPv6Size)
+	for _, mt := range []dhcpv6.MessageType{dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest} {
+		if _, err := conn.WriteTo(genMsg(mt, nil, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := svr.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent, err := dhcpv6.MessageFromBytes(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, err := v6AuthOption(sent.Options.Options)
+		if err != nil || auth == nil || auth.Protocol != authProtoDelayedV6 {
+			t.Fatalf("%v has no delayed authentication option, %v", mt, err)
+		}
+		if mt == dhcpv6.MessageTypeSolicit {
+			if len(auth.Info) != 0 {
+				t.Fatalf("solicit has authentication information %x", auth.Info)
+			}
+			continue
+		}
+		if err = checkDelayedAuthInfo(auth.Info, key, true); err != nil {
+			t.Fatal(err)
+		}
+		if err = verifyV6(buf[:n], key.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, raw := range [][]byte{
+		genMsg(dhcpv6.MessageTypeReply, genDelayedAuth(authProtoDelayedV6, 10, 7, key.Realm), []byte("wrong")),
+		genMsg(dhcpv6.MessageTypeReply, nil, nil),
+		genMsg(dhcpv6.MessageTypeReply, genDelayedAuth(authProtoDelayedV6, 10, 7, []byte("other.com")), key.Key),
+		genMsg(dhcpv6.MessageTypeAdvertise, genDelayedAuth(authProtoDelayedV6, 10, 7, key.Realm), key.Key),
+		//replayed
+		genMsg(dhcpv6.MessageTypeReply, genDelayedAuth(authProtoDelayedV6, 10, 7, key.Realm), key.Key),
+		genMsg(dhcpv6.MessageTypeReply, genDelayedAuth(authProtoDelayedV6, 11, 7, key.Realm), key.Key),
+	} {
+		svr.WriteTo(raw, nil)
+	}
+	for _, expected := range []uint64{10, 11} {
+		if rd := readAuthConn(t, conn, true); rd != expected {
+			t.Fatalf("expect reply with replay detection %d, got %d", expected, rd)
+		}
+	}
+	if stats.V6Failed.Load() != 4 {
+		t.Fatalf("expect 4 failures, got\n%v", stats)
+	}
+	conn.Close()
+	//reconfigure key
+	clnt, svr = conpair.NewPacketConnPair()
+	stats = new(authStats)
+	conn = newV6AuthConn(clnt, authReconfKey, nil, stats)
+	reconfKey := &authOption{
+		Protocol:        authProtoReconfigureKey,
+		Algorithm:       authAlgHMACMD5,
+		ReplayDetection: 20,
+		Info:            append([]byte{reconfKeyTypeKey}, bytes.Repeat([]byte{1}, authDigestLen)...),
+	}
+	//reconfigure key is required in Reply to Request
+	if _, err := conn.WriteTo(genMsg(dhcpv6.MessageTypeRequest, nil, nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	svr.ReadFrom(buf)
+	for _, raw := range [][]byte{
+		genMsg(dhcpv6.MessageTypeReply, nil, nil),
+		genMsg(dhcpv6.MessageTypeAdvertise, genDelayedAuth(authProtoDelayedV6, 20, 7, nil), nil),
+		genMsg(dhcpv6.MessageTypeReply, reconfKey, nil),
+	} {
+		svr.WriteTo(raw, nil)
+	}
+	for _, expected := range []uint64{20, 20} {
+		if rd := readAuthConn(t, conn, true); rd != expected {
+			t.Fatalf("expect msg with replay detection %d, got %d", expected, rd)
+		}
+	}
+	//but not in Reply to Renew
+	if _, err := conn.WriteTo(genMsg(dhcpv6.MessageTypeRenew, nil, nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	svr.ReadFrom(buf)
+	svr.WriteTo(genMsg(dhcpv6.MessageTypeReply, genDelayedAuth(authProtoDelayedV6, 30, 7, nil), nil), nil)
+	if rd := readAuthConn(t, conn, true); rd != 30 {
+		t.Fatalf("expect Reply to Renew with replay detection 30, got %d", rd)
+	}
+	if stats.V6Failed.Load() != 1 {
+		t.Fatalf("expect 1 failure, got\n%v", stats)
+	}
+	conn.Close()
+}
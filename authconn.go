@@ -0,0 +1,287 @@
+// authconn
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// authStats counts server messages of all clients dropped due to failed authentication
+type authStats struct {
+	V4Failed atomic.Uint64
+	V6Failed atomic.Uint64
+}
+
+func (as *authStats) String() string {
+	r := fmt.Sprintf("DHCPv4 authentication failed:%d\n", as.V4Failed.Load())
+	r += fmt.Sprintf("DHCPv6 authentication failed:%d\n", as.V6Failed.Load())
+	return r
+}
+
+// authRD generates monotonic replay detection values of sent messages, and checks the ones of received messages
+type authRD struct {
+	sent     *atomic.Uint64
+	received uint64 //last replay detection value received, only accessed by reader
+}
+
+func newAuthRD() *authRD {
+	r := &authRD{sent: new(atomic.Uint64)}
+	r.sent.Store(uint64(time.Now().UnixNano()))
+	return r
+}
+
+func (rd *authRD) next() uint64 {
+	return rd.sent.Add(1)
+}
+
+// check returns error if v is not bigger than last received value
+func (rd *authRD) check(v uint64) error {
+	if v <= rd.received {
+		return fmt.Errorf("replay detection %d is not bigger than %d", v, rd.received)
+	}
+	return nil
+}
+
+// delayedAuthInfo returns authentication information of delayed authentication with key, digest is zero
+func delayedAuthInfo(key *authKey, realm bool) []byte {
+	r := []byte{}
+	if realm {
+		r = append(r, key.Realm...)
+	}
+	r = binary.BigEndian.AppendUint32(r, key.ID)
+	return append(r, make([]byte, authDigestLen)...)
+}
+
+// checkDelayedAuthInfo checks key id and realm in authentication information of delayed authentication
+func checkDelayedAuthInfo(info []byte, key *authKey, realm bool) error {
+	if len(info) < authKeyIDLen+authDigestLen {
+		return fmt.Errorf("authentication information is too short, %d bytes", len(info))
+	}
+	kidOffset := len(info) - authDigestLen - authKeyIDLen
+	if realm && !bytes.Equal(info[:kidOffset], key.Realm) {
+		return fmt.Errorf("realm %q doesn't match", info[:kidOffset])
+	}
+	if !realm && kidOffset != 0 {
+		return fmt.Errorf("authentication information has %d extra bytes", kidOffset)
+	}
+	if kid := binary.BigEndian.Uint32(info[kidOffset:]); kid != key.ID {
+		return fmt.Errorf("key id %d doesn't match", kid)
+	}
+	return nil
+}
+
+// v4AuthConn is a net.PacketConn does RFC3118 delayed authentication of DHCPv4 messages:
+// Authentication option is added to sent messages, DISCOVER has no authentication information, others are signed with key;
+// received messages that failed authentication are dropped
+type v4AuthConn struct {
+	net.PacketConn
+	key   *authKey
+	rd    *authRD
+	stats *authStats
+}
+
+func newV4AuthConn(conn net.PacketConn, key *authKey, stats *authStats) *v4AuthConn {
+	return &v4AuthConn{PacketConn: conn, key: key, rd: newAuthRD(), stats: stats}
+}
+
+func (c *v4AuthConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	msg, err := dhcpv4.FromBytes(p)
+	if err != nil {
+		return 0, fmt.Errorf("invalid DHCPv4 msg, %w", err)
+	}
+	auth := &authOption{
+		Protocol:        authProtoDelayedV4,
+		Algorithm:       authAlgHMACMD5,
+		RDM:             authRDMMonotonic,
+		ReplayDetection: c.rd.next(),
+	}
+	if msg.MessageType() != dhcpv4.MessageTypeDiscover {
+		auth.Info = delayedAuthInfo(c.key, false)
+	}
+	msg.UpdateOption(auth.v4Option())
+	buf := msg.ToBytes()
+	if auth.Info != nil {
+		if err = signV4(buf, c.key.Key); err != nil {
+			return 0, err
+		}
+	}
+	if _, err = c.PacketConn.WriteTo(buf, addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *v4AuthConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, demuxBufSize)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err = c.validate(buf[:n]); err != nil {
+			common.MyLog("dropped a DHCPv4 msg failed authentication, %v", err)
+			if c.stats != nil {
+				c.stats.V4Failed.Add(1)
+			}
+			continue
+		}
+		return copy(p, buf[:n]), addr, nil
+	}
+}
+
+func (c *v4AuthConn) validate(raw []byte) error {
+	msg, err := dhcpv4.FromBytes(raw)
+	if err != nil {
+		return err
+	}
+	auth, err := v4AuthOption(msg.Options)
+	if err != nil {
+		return err
+	}
+	if auth == nil {
+		return fmt.Errorf("no authentication option in %v", msg.MessageType())
+	}
+	if auth.Protocol != authProtoDelayedV4 || auth.Algorithm != authAlgHMACMD5 || auth.RDM != authRDMMonotonic {
+		return fmt.Errorf("unsupported authentication protocol %d algorithm %d RDM %d", auth.Protocol, auth.Algorithm, auth.RDM)
+	}
+	if err = checkDelayedAuthInfo(auth.Info, c.key, false); err != nil {
+		return err
+	}
+	if err = c.rd.check(auth.ReplayDetection); err != nil {
+		return err
+	}
+	if err = verifyV4(raw, c.key.Key); err != nil {
+		return err
+	}
+	c.rd.received = auth.ReplayDetection
+	return nil
+}
+
+// v6AuthConn is a net.PacketConn does authentication of DHCPv6 messages:
+//   - delayed: RFC3315 delayed authentication, Authentication option is added to sent messages,
+//     Solicit has no authentication information, others are signed with key; received messages that failed authentication are dropped
+//   - reconfkey: received Reply to Solicit or Request without reconfigure key is dropped,
+//     Reply to other messages like Renew or Release doesn't need to have it
+type v6AuthConn struct {
+	net.PacketConn
+	mode    authMode
+	key     *authKey
+	rd      *authRD
+	stats   *authStats
+	needKey atomic.Bool //true if last sent msg is Solicit or Request, only used in reconfkey mode
+}
+
+func newV6AuthConn(conn net.PacketConn, mode authMode, key *authKey, stats *authStats) *v6AuthConn {
+	return &v6AuthConn{PacketConn: conn, mode: mode, key: key, rd: newAuthRD(), stats: stats}
+}
+
+func (c *v6AuthConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	msg, err := dhcpv6.MessageFromBytes(p)
+	if err != nil {
+		return 0, fmt.Errorf("invalid DHCPv6 msg, %w", err)
+	}
+	if c.mode != authDelayed {
+		c.needKey.Store(msg.MessageType == dhcpv6.MessageTypeSolicit || msg.MessageType == dhcpv6.MessageTypeRequest)
+		return c.PacketConn.WriteTo(p, addr)
+	}
+	auth := &authOption{
+		Protocol:        authProtoDelayedV6,
+		Algorithm:       authAlgHMACMD5,
+		RDM:             authRDMMonotonic,
+		ReplayDetection: c.rd.next(),
+	}
+	if msg.MessageType != dhcpv6.MessageTypeSolicit {
+		auth.Info = delayedAuthInfo(c.key, true)
+	}
+	msg.UpdateOption(auth.v6Option())
+	buf := msg.ToBytes()
+	if auth.Info != nil {
+		if err = signV6(buf, c.key.Key); err != nil {
+			return 0, err
+		}
+	}
+	if _, err = c.PacketConn.WriteTo(buf, addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *v6AuthConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, maxDHCPv6Size)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err = c.validate(buf[:n]); err != nil {
+			common.MyLog("dropped a DHCPv6 msg failed authentication, %v", err)
+			if c.stats != nil {
+				c.stats.V6Failed.Add(1)
+			}
+			continue
+		}
+		return copy(p, buf[:n]), addr, nil
+	}
+}
+
+func (c *v6AuthConn) validate(raw []byte) error {
+	msg, err := dhcpv6.MessageFromBytes(raw)
+	if err != nil {
+		return err
+	}
+	auth, err := v6AuthOption(msg.Options.Options)
+	if err != nil {
+		return err
+	}
+	if c.mode == authReconfKey {
+		if msg.MessageType != dhcpv6.MessageTypeReply || !c.needKey.Load() {
+			return nil
+		}
+		if auth == nil || auth.Protocol != authProtoReconfigureKey ||
+			len(auth.Info) != 1+authDigestLen || auth.Info[0] != reconfKeyTypeKey {
+			return fmt.Errorf("no reconfigure key in %v", msg.MessageType)
+		}
+		return nil
+	}
+	if auth == nil {
+		return fmt.Errorf("no authentication option in %v", msg.MessageType)
+	}
+	if auth.Protocol != authProtoDelayedV6 || auth.Algorithm != authAlgHMACMD5 || auth.RDM != authRDMMonotonic {
+		return fmt.Errorf("unsupported authentication protocol %d algorithm %d RDM %d", auth.Protocol, auth.Algorithm, auth.RDM)
+	}
+	if err = checkDelayedAuthInfo(auth.Info, c.key, true); err != nil {
+		return err
+	}
+	if err = c.rd.check(auth.ReplayDetection); err != nil {
+		return err
+	}
+	if err = verifyV6(raw, c.key.Key); err != nil {
+		return err
+	}
+	c.rd.received = auth.ReplayDetection
+	return nil
+}
+
+// withV4Auth returns conn with DHCPv4 authentication of ccfg, or conn itself if it is not enabled
+func (ccfg *clientConfig) withV4Auth(conn net.PacketConn) net.PacketConn {
+	if ccfg.setup.Auth != authDelayed {
+		return conn
+	}
+	return newV4AuthConn(conn, ccfg.authKey, ccfg.setup.authStats)
+}
+
+// withV6Auth returns conn with DHCPv6 authentication of ccfg, or conn itself if it is not enabled
+func (ccfg *clientConfig) withV6Auth(conn net.PacketConn) net.PacketConn {
+	if ccfg.setup.Auth == authNone {
+		return conn
+	}
+	return newV6AuthConn(conn, ccfg.setup.Auth, ccfg.authKey, ccfg.setup.authStats)
+}
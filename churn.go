@@ -0,0 +1,101 @@
+// churn
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/hujun-open/myaddr"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// ChurnConf is the configuration of churn, in which clients leave and are replaced by new clients,
+// new clients have new MAC/VLAN/DUID/circuit-id etc generated after the last client
+type ChurnConf struct {
+	Interval  time.Duration `alias:"churninterval" usage:"interval between replacing two clients with new clients, 0 means no churn"`
+	Num       uint          `alias:"churnnum" usage:"number of clients replaced, 0 means until interrupted"`
+	NoRelease bool          `alias:"churnnorelease" usage:"replaced clients leave without releasing their leases if true"`
+}
+
+func (cc *ChurnConf) enabled() bool {
+	return cc != nil && cc.Interval > 0
+}
+
+// leave releases leases of dc if release is true, then closes all its connections
+func (dc *DClient) leave(release bool) {
+	if release {
+		if dc.d4Lease != nil {
+			if err := dc.releasev4(nil); err != nil {
+				common.MyLog("%v", err)
+			}
+		}
+		if dc.d6Lease != nil {
+			if err := dc.releaseOrRenewV6(nil, dhcpv6.MessageTypeRelease); err != nil {
+				common.MyLog("%v", err)
+			}
+		}
+	}
+	//the lease is gone with the client even if it is not released
+	if dc.arp != nil && dc.d4Lease != nil {
+		dc.arp.remove(dc.d4Lease.Lease.ACK.YourIPAddr.String())
+	}
+	dc.proxyV6Lease(false)
+	dc.unregisterV4Lease()
+	dc.unregisterV6Lease()
+	if dc.d4 != nil {
+		dc.d4.Close()
+	}
+	if dc.d6 != nil {
+		dc.d6.Close()
+	}
+	if dc.reconfConn != nil {
+		dc.reconfConn.Close()
+	}
+	if dc.forceRenewConn != nil {
+		dc.forceRenewConn.Close()
+	}
+	if dc.cfg.v4econn != nil {
+		dc.cfg.v4econn.Close()
+	}
+	if dc.cfg.v6econn != nil {
+		dc.cfg.v6econn.Close()
+	}
+}
+
+// churn replaces clients of g in round-robin order with new clients, one every churn interval,
+// until the churn number is reached or ctx is done
+func (sch *Sched) churn(ctx context.Context, wg *sync.WaitGroup, g *clientGroup) {
+	defer wg.Done()
+	conf := g.setup.Churn
+	subwg := new(sync.WaitGroup)
+	defer subwg.Wait()
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+	for n := 0; conf.Num == 0 || n < int(conf.Num); n++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		slot := n % len(g.clients)
+		old := g.clients[slot]
+		dc, err := sch.newGroupClient(g)
+		if err != nil {
+			common.MyLog("churn of group %v stopped, %v", g.setup.groupName, err)
+			return
+		}
+		g.clients[slot] = dc
+		g.churned++
+		subwg.Add(1)
+		go func() {
+			defer subwg.Done()
+			old.leave(!conf.NoRelease)
+			if sch.ndp != nil && g.setup.v6Transport() {
+				sch.ndp.remove(myaddr.GetLLAFromMac(old.cfg.Mac).String())
+			}
+			dc.dialAll(nil)
+		}()
+	}
+}
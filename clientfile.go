@@ -0,0 +1,195 @@
+// clientfile
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hujun-open/etherconn"
+	"gopkg.in/yaml.v3"
+)
+
+// clientEntry is one client in the client file,
+// all fields except MAC are optional, an empty identifier falls back to the corresponding template in testSetup;
+// VLANs is like "100.200", outer most tag first, empty means no VLAN tag
+type clientEntry struct {
+	MAC         string         `yaml:"mac"`
+	VLANs       string         `yaml:"vlans"`
+	CID         string         `yaml:"cid"`
+	RID         string         `yaml:"rid"`
+	ClntID      string         `yaml:"clntid"`
+	VendorClass string         `yaml:"vendorclass"`
+	ReqIP       string         `yaml:"reqip"`
+	V4Options   []customOption `yaml:"v4options"`
+	V6Options   []customOption `yaml:"v6options"`
+}
+
+// loadClientFile loads a list of clientEntry from inf,
+// file with extension .csv is parsed as CSV, otherwise as YAML;
+// first row of a CSV file is the header with column names same as yaml tag of clientEntry,
+// multiple options in v4options/v6options column are separated by ";"
+func loadClientFile(inf string) ([]clientEntry, error) {
+	data, err := os.ReadFile(inf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client file %v, %w", inf, err)
+	}
+	var r []clientEntry
+	if strings.ToLower(filepath.Ext(inf)) == ".csv" {
+		r, err = parseClientCSV(string(data))
+	} else {
+		err = yaml.Unmarshal(data, &r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client file %v, %w", inf, err)
+	}
+	if len(r) == 0 {
+		return nil, fmt.Errorf("there is no client in %v", inf)
+	}
+	return r, nil
+}
+
+func parseClientCSV(data string) ([]clientEntry, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	r := []clientEntry{}
+	for rowi, row := range records[1:] {
+		entry := clientEntry{}
+		for i, val := range row {
+			val = strings.TrimSpace(val)
+			switch strings.ToLower(strings.TrimSpace(header[i])) {
+			case "mac":
+				entry.MAC = val
+			case "vlans":
+				entry.VLANs = val
+			case "cid":
+				entry.CID = val
+			case "rid":
+				entry.RID = val
+			case "clntid":
+				entry.ClntID = val
+			case "vendorclass":
+				entry.VendorClass = val
+			case "reqip":
+				entry.ReqIP = val
+			case "v4options", "v6options":
+				opts := []customOption{}
+				for _, s := range splitOptionList(val) {
+					co := customOption{}
+					if err = co.UnmarshalText([]byte(s)); err != nil {
+						return nil, fmt.Errorf("row %d, %w", rowi+2, err)
+					}
+					opts = append(opts, co)
+				}
+				if strings.ToLower(strings.TrimSpace(header[i])) == "v4options" {
+					entry.V4Options = opts
+				} else {
+					entry.V6Options = opts
+				}
+			default:
+				return nil, fmt.Errorf("unknown column %v", header[i])
+			}
+		}
+		r = append(r, entry)
+	}
+	return r, nil
+}
+
+// identity returns entry's identifiers, fallback to templates in setup
+func (entry clientEntry) identity(setup *testSetup) clientIdentity {
+	r := setup.clientIdentity()
+	for _, f := range []struct {
+		dst *string
+		val string
+	}{
+		{&r.RID, entry.RID},
+		{&r.CID, entry.CID},
+		{&r.ClntID, entry.ClntID},
+		{&r.VendorClass, entry.VendorClass},
+		{&r.ReqIP, entry.ReqIP},
+	} {
+		if f.val != "" {
+			*f.dst = f.val
+		}
+	}
+	r.V4Options = append(append([]customOption{}, r.V4Options...), entry.V4Options...)
+	r.V6Options = append(append([]customOption{}, r.V6Options...), entry.V6Options...)
+	return r
+}
+
+// validateClientEntries checks all entries in setup.clientEntries
+func (setup *testSetup) validateClientEntries() error {
+	keys := make(map[string]int)
+	for i, entry := range setup.clientEntries {
+		mac, err := net.ParseMAC(entry.MAC)
+		if err != nil {
+			return fmt.Errorf("client %d has invalid MAC %v, %w", i, entry.MAC, err)
+		}
+		vlans := etherconn.VLANs{}
+		if err = vlans.UnmarshalText([]byte(entry.VLANs)); err != nil {
+			return fmt.Errorf("client %d has invalid vlans %v, %w", i, entry.VLANs, err)
+		}
+		key := mac.String() + vlans.String()
+		if j, ok := keys[key]; ok {
+			return fmt.Errorf("client %d and %d have same MAC and VLANs", j, i)
+		}
+		keys[key] = i
+		for _, tmpl := range []string{entry.CID, entry.RID, entry.ClntID, entry.VendorClass, entry.ReqIP} {
+			if err = validateTemplate(tmpl); err != nil {
+				return fmt.Errorf("client %d has invalid template %v, %w", i, tmpl, err)
+			}
+		}
+		for _, co := range entry.V4Options {
+			if err = co.validate(false); err != nil {
+				return fmt.Errorf("client %d has invalid DHCPv4 option %d, %w", i, co.Code, err)
+			}
+		}
+		for _, co := range entry.V6Options {
+			if err = co.validate(true); err != nil {
+				return fmt.Errorf("client %d has invalid DHCPv6 option %d, %w", i, co.Code, err)
+			}
+		}
+	}
+	return nil
+}
+
+func genClientConfigurationsFromEntries(setup *testSetup) ([]clientConfig, error) {
+	r := []clientConfig{}
+	for i, entry := range setup.clientEntries {
+		ccfg := clientConfig{}
+		ccfg.setup = setup
+		var err error
+		if ccfg.Mac, err = net.ParseMAC(entry.MAC); err != nil {
+			return []clientConfig{}, fmt.Errorf("invalid mac address %v,%w", entry.MAC, err)
+		}
+		if err = ccfg.VLANs.UnmarshalText([]byte(entry.VLANs)); err != nil {
+			return []clientConfig{}, fmt.Errorf("invalid vlans %v,%w", entry.VLANs, err)
+		}
+		for _, v := range ccfg.VLANs {
+			v.EtherType = uint16(setup.VLANEType)
+		}
+		vars := templateVars{
+			ID:    i,
+			MAC:   ccfg.Mac,
+			VLANs: ccfg.VLANs.IDs(),
+		}
+		if err = ccfg.genOptions(entry.identity(setup), vars); err != nil {
+			return []clientConfig{}, err
+		}
+		ccfg.createEtherConns()
+		r = append(r, ccfg)
+	}
+	return r, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadClientFile(t *testing.T) {
+	dir := t.TempDir()
+	csvf := filepath.Join(dir, "clients.csv")
+	err := os.WriteFile(csvf, []byte(`mac,vlans,cid,reqip,v4options
+aa:bb:cc:00:00:01,100.200,eth 1/1/@SVLAN:@CVLAN,,60:string:cpe-a;43:nested:{1:string:a;2:uint8:1}
+aa:bb:cc:00:00:02,,,192.0.2.10,
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yamlf := filepath.Join(dir, "clients.yaml")
+	err = os.WriteFile(yamlf, []byte(`
+- mac: aa:bb:cc:00:00:01
+  vlans: "100.200"
+  cid: eth 1/1/@SVLAN:@CVLAN
+  v4options:
+    - "60:string:cpe-a"
+    - "43:nested:{1:string:a;2:uint8:1}"
+- mac: aa:bb:cc:00:00:02
+  reqip: 192.0.2.10
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{csvf, yamlf} {
+		entries, err := loadClientFile(f)
+		if err != nil {
+			t.Fatalf("failed to load %v, %v", f, err)
+		}
+		setup := &testSetup{clientEntries: entries}
+		if err = setup.validateClientEntries(); err != nil {
+			t.Fatalf("invalid entries in %v, %v", f, err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expect 2 clients from %v, got %d", f, len(entries))
+		}
+		if len(entries[0].V4Options) != 2 || entries[0].V4Options[1].Type != optValNested {
+			t.Fatalf("wrong options loaded from %v, %+v", f, entries[0].V4Options)
+		}
+		if entries[0].VLANs != "100.200" || entries[1].ReqIP != "192.0.2.10" {
+			t.Fatalf("wrong clients loaded from %v, %+v", f, entries)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
-	"strconv"
 	"strings"
 	"time"
 
@@ -15,8 +14,6 @@ import (
 )
 
 func init() {
-	shouchan.Register[dhcpv4.Option](d4OptionToStr, d4OptionFromStr)
-	shouchan.Register[dhcpv6.OptionGeneric](d6OptionToStr, d6OptionFromStr)
 	shouchan.Register[dhcpv6.MessageType](d6MsgTypeToStr, d6MsgTypeFromStr)
 }
 
@@ -28,77 +25,137 @@ type testSetup struct {
 	StartVLANs   etherconn.VLANs  `alias:"vlan" usage:"starting VLAN ID, Dot1Q or QinQ"`
 	VLANEType    uint             `usage:"EthernetType for the vlan tag" base:"16"`
 	VLANStep     uint             `usage:"amount of increase between two consecutive VLAN ID"`
+	ClientFile   string           `usage:"load clients from the specified CSV or YAML file instead of generating them"`
 
-	ExcludedVLANs  []uint16             `usage:"a list of excluded VLAN IDs"`
-	Interval       time.Duration        `usage:"interval between setup of sessions"`
-	CustomV4Option dhcpv4.Option        `usage:"custom DHCPv4 option, code:value format"`
-	CustomV6Option dhcpv6.OptionGeneric `usage:"custom DHCPv6 option, code:value format"`
-	v4Options      []dhcpv4.Option
-	v6Options      dhcpv6.Options //non-relay specific options
-	Debug          bool           `alias:"d" usage:"enable debug output"`
-	SaveLease      bool           `usage:"save the lease if true"`
-	ApplyLease     bool           `usage:"apply assigned address on the interface if true"`
-	Retry          uint           `usage:"number of setup retry"`
-	Timeout        time.Duration  `usage:"setup timout"`
-	GiAddr         netip.Addr     `usage:"Gi address for DHCPv4, simulating relay agent"`
-	SourceV4Addr   netip.Addr     `usage:"source address for DHCPv4" alias:"srcv4"`
-	SourceV6Port   uint16         `usage:"source port for egress DHCPv6 message" alias:"srcv6port"`
-	SourceV4Port   uint16         `usage:"source port for egress DHCPv4 message" alias:"srcv4port"`
-	//following are template str, $ID will be replaced by client id
-	RID         string `usage:"BBF remote-id"`
-	CID         string `usage:"BBF circuit-id"`
-	ClntID      string `usage:"client-id"`
-	VendorClass string `usage:"vendor class"`
-	EnableV4    bool   `alias:"v4" usage:"do DHCPv4 if true"`
+	ExcludedVLANs   []uint16       `usage:"a list of excluded VLAN IDs"`
+	Interval        time.Duration  `usage:"interval between setup of sessions"`
+	CustomV4Options []customOption `alias:"customv4option" usage:"custom DHCPv4 options, code[/msg,...]:type:value format"`
+	CustomV6Options []customOption `alias:"customv6option" usage:"custom DHCPv6 options, code[/msg,...]:type:value format"`
+	Debug           bool           `alias:"d" usage:"enable debug output"`
+	SaveLease       bool           `usage:"save the lease if true"`
+	ApplyLease      bool           `usage:"apply assigned address on the interface if true"`
+	Retry           uint           `usage:"number of setup retry"`
+	Timeout         time.Duration  `usage:"setup timout"`
+	GiAddr          netip.Addr     `usage:"Gi address for DHCPv4, simulating relay agent"`
+	SourceV4Addr    netip.Addr     `usage:"source address for DHCPv4" alias:"srcv4"`
+	SourceV6Port    uint16         `usage:"source port for egress DHCPv6 message" alias:"srcv6port"`
+	SourceV4Port    uint16         `usage:"source port for egress DHCPv4 message" alias:"srcv4port"`
+	ReqIPStart      netip.Addr     `alias:"reqip" usage:"starting requested IPv4 address in discover, increased by 1 for each client"`
+	ForceRenew      bool           `alias:"forcerenew" usage:"bound DHCPv4 clients accept FORCERENEW (RFC3203) to leased address and keep running until ctrl+c if true"`
+	ForceRenewNonce bool           `alias:"forcerenewnonce" usage:"clients are forcerenew nonce capable (RFC6704), and only accept FORCERENEW authenticated with the nonce if true"`
+	//following are template str, see templateVarNames for supported variables
+	RID          string `usage:"BBF remote-id"`
+	CID          string `usage:"BBF circuit-id"`
+	ClntID       string `usage:"client-id"`
+	VendorClass  string `usage:"vendor class"`
+	SubscriberID string `alias:"subscriberid" usage:"Subscriber-ID (RFC4580) in DHCPv6 Relay-Forward"`
+	EnableV4     bool   `alias:"v4" usage:"do DHCPv4 if true"`
+	V4oV6        bool   `alias:"v4ov6" usage:"do DHCPv4 over DHCPv6 (RFC7341) instead of native DHCPv4 if true"`
 	//v6 specific
-	EnableV6     bool               `alias:"v6" usage:"do DHCPv6 if true"`
-	SourceV6Addr netip.Addr         `usage:"source address for DHCPv6" alias:"srcv6"`
-	StackDelay   time.Duration      `usage:"delay between setup v4 and v6, postive value means setup v4 first, negative means v6 first"`
-	V6MsgType    dhcpv6.MessageType `usage:"DHCPv6 exchange type, solict|relay|auto"`
-	NeedNA       bool               `usage:"request DHCPv6 IANA if true"`
-	NeedPD       bool               `usage:"request DHCPv6 IAPD if true"`
-	pktRelay     etherconn.PacketRelay
-	Driver       etherconn.RelayType `usage:"etherconn forward engine"`
-	Flapping     *FlappingConf       `usage:"enable flapping"`
-	SendRSFirst  bool                `usage:"send Router Solict first if true"`
-	Profiling    bool                `usage:"enable profiling, dev use only"`
-	LeaseFile    string
-	Action       actionType `usage:"dora | release | renew | rebind"`
-	saveV4Chan   chan *v4LeaseWithID
-	saveV6Chan   chan *v6LeaseWithID
+	EnableV6       bool               `alias:"v6" usage:"do DHCPv6 if true"`
+	SourceV6Addr   netip.Addr         `usage:"source address for DHCPv6" alias:"srcv6"`
+	StackDelay     time.Duration      `usage:"delay between setup v4 and v6, postive value means setup v4 first, negative means v6 first"`
+	V6MsgType      dhcpv6.MessageType `usage:"DHCPv6 exchange type, solict|relay|auto"`
+	RelayHops      []relayHop         `alias:"relayhop" usage:"additional DHCPv6 relay hops from relay agent toward server, key=value;... format"`
+	LDRA           bool               `alias:"ldra" usage:"emulate LDRA (RFC6221) with DHCPv6 relay, cid is required as Interface-ID"`
+	LinkAddr       string             `alias:"linkaddr" usage:"link-address of DHCPv6 relay, template"`
+	V6Servers      []netip.Addr       `alias:"v6server" usage:"unicast DHCPv6 server addresses of DHCPv6 relay, ff02::1:2 if not specified"`
+	V6NextHopMAC   net.HardwareAddr   `alias:"v6nexthopmac" usage:"MAC address of next hop toward unicast DHCPv6 servers, broadcast if not specified"`
+	ClientLLAddr   bool               `alias:"clientlladdr" usage:"include Client Link-Layer Address (RFC6939) in DHCPv6 Relay-Forward"`
+	RelaySrcPort   bool               `alias:"relaysrcport" usage:"include Relay-Source-Port (RFC8357) in DHCPv6 Relay-Forward"`
+	RadiusAttrs    []customOption     `alias:"radiusattr" usage:"RADIUS attributes in RADIUS option (RFC7037) of DHCPv6 Relay-Forward, type:valuetype:value format"`
+	Reconfigure    bool               `alias:"reconfigure" usage:"bound DHCPv6 clients accept Reconfigure with reconfigure key authentication and keep running until ctrl+c if true"`
+	NeedNA         bool               `usage:"request DHCPv6 IANA if true"`
+	NeedPD         bool               `usage:"request DHCPv6 IAPD if true"`
+	DUIDType       duidType           `usage:"DHCPv6 DUID type, auto|llt|ll|en|uuid; clntid is used as DUID content if specified"`
+	DUIDTime       uint32             `usage:"time field of DUID-LLT, fixed so that DUID is stable across runs"`
+	DUIDEnterprise uint32             `usage:"enterprise number of DUID-EN"`
+	IAID           iaidScheme         `usage:"DHCPv6 IAID allocation scheme, fixed|index|mac"`
+	NumOfNA        uint               `alias:"numna" usage:"number of IA_NA per client"`
+	NumOfPD        uint               `alias:"numpd" usage:"number of IA_PD per client"`
+	PDLenHint      uint               `alias:"pdlen" usage:"prefix length hint in IA_PD, 0 means no hint"`
+	NAHint         string             `usage:"address hint in IA_NA, template"`
+	PDHint         string             `usage:"prefix hint in IA_PD, prefix/len, template"`
+	V4Pools        []ipRange          `alias:"v4pool" usage:"expected DHCPv4 address pools, start-end or prefix/len"`
+	NAPools        []ipRange          `alias:"napool" usage:"expected IA_NA address pools, start-end or prefix/len"`
+	PDPools        []pdPool           `alias:"pdpool" usage:"expected IA_PD pools, aggregate/len[:delegated len]"`
+	OptionRules    []optionRule       `alias:"optrule" usage:"expected options in server messages, msg:[!]code[op value] format"`
+	pktRelay       etherconn.PacketRelay
+	Driver         etherconn.RelayType `usage:"etherconn forward engine"`
+	Flapping       *FlappingConf       `usage:"enable flapping"`
+	Churn          *ChurnConf          `usage:"enable churn"`
+	Fuzz           *FuzzConf           `usage:"fuzzing with action fuzz"`
+	SendRSFirst    bool                `usage:"send Router Solict first if true"`
+	Profiling      bool                `usage:"enable profiling, dev use only"`
+	LeaseFile      string
+	Action         actionType    `usage:"dora | release | renew | rebind | reboot | exhaust | conform | fuzz | relay"`
+	RebootWindow   time.Duration `usage:"clients reboot at random time within the window instead of one per interval, 0 means disabled"`
+	RetransJitter  uint          `alias:"jitter" usage:"random jitter of reboot retransmission timeout in percent"`
+	ReboundPct     []uint        `alias:"reboundpct" usage:"report time until the percentages of reboot succeed"`
+	ExhaustFails   uint          `alias:"exhaustfails" usage:"number of consecutive failed clients to consider the pool exhausted"`
+	AccessIf       string        `alias:"accessif" usage:"access interface toward real clients of action relay"`
+	RelayConn      relayConnType `alias:"relayconn" usage:"network side conn of action relay, kernel|etherconn"`
+	V4Servers      []netip.Addr  `alias:"v4server" usage:"unicast DHCPv4 server addresses of action relay, 255.255.255.255 if not specified"`
+	Auth           authMode      `alias:"auth" usage:"authentication of clients, none|delayed|reconfkey"`
+	AuthKeyFile    string        `alias:"authkeyfile" usage:"load per client keys of delayed authentication from the specified CSV or YAML file"`
+	saveV4Chan     chan *v4LeaseWithID
+	saveV6Chan     chan *v6LeaseWithID
+	clientEntries  []clientEntry
+	authKeys       map[string]*authKey
+	authStats      *authStats
+	GroupFile      string `usage:"load client groups from the specified YAML file"`
+	groupName      string
+	groups         []*testSetup
+	v6MsgTypeConf  dhcpv6.MessageType //configured V6MsgType before auto is resolved, inherited by groups
 }
 
 func newDefaultConf() *testSetup {
 	return &testSetup{
-		Action:       actionDORA,
-		NumOfClients: 1,
-		StartMAC:     []byte{},
-		MacStep:      1,
-		VLANEType:    etherconn.DefaultVLANEtype,
-		VLANStep:     1,
-		Interval:     time.Second,
-		GiAddr:       netip.MustParseAddr("0.0.0.0"),
-		SourceV4Addr: netip.MustParseAddr("0.0.0.0"),
-		SourceV6Addr: netip.MustParseAddr("::"),
-		Retry:        1,
-		Timeout:      5 * time.Second,
-		EnableV4:     true,
-		EnableV6:     false,
-		SourceV6Port: dhcpv6.DefaultClientPort,
-		SourceV4Port: dhcpv4.ClientPort,
-		NeedNA:       true,
-		V6MsgType:    dhcpv6.MessageTypeNone,
-		Driver:       etherconn.RelayTypeAFP,
-		LeaseFile:    "dhcplt.lease",
+		Action:         actionDORA,
+		NumOfClients:   1,
+		StartMAC:       []byte{},
+		MacStep:        1,
+		VLANEType:      etherconn.DefaultVLANEtype,
+		VLANStep:       1,
+		Interval:       time.Second,
+		GiAddr:         netip.MustParseAddr("0.0.0.0"),
+		ReqIPStart:     netip.MustParseAddr("0.0.0.0"),
+		SourceV4Addr:   netip.MustParseAddr("0.0.0.0"),
+		SourceV6Addr:   netip.MustParseAddr("::"),
+		Retry:          1,
+		Timeout:        5 * time.Second,
+		EnableV4:       true,
+		EnableV6:       false,
+		SourceV6Port:   dhcpv6.DefaultClientPort,
+		SourceV4Port:   dhcpv4.ClientPort,
+		NeedNA:         true,
+		DUIDEnterprise: BBFEnterpriseNumber,
+		NumOfNA:        1,
+		NumOfPD:        1,
+		V6MsgType:      dhcpv6.MessageTypeNone,
+		LinkAddr:       "::",
+		V6NextHopMAC:   []byte{},
+		Driver:         etherconn.RelayTypeAFP,
+		LeaseFile:      "dhcplt.lease",
+		RetransJitter:  10,
+		ReboundPct:     []uint{50, 90, 100},
+		ExhaustFails:   3,
 		Flapping: &FlappingConf{
 			FlapNum:     0,
 			MinInterval: defaultMinFlapInt,
 			MaxInterval: defualtMaxFlapInt,
 			StayDownDur: 10 * time.Second,
 		},
+		Churn: &ChurnConf{},
+		Fuzz:  &FuzzConf{Interval: defaultFuzzInterval},
 	}
 }
 
+// v6Transport returns true if clients need DHCPv6 transport, for DHCPv6 or DHCPv4-over-DHCPv6
+func (setup *testSetup) v6Transport() bool {
+	return setup.EnableV6 || setup.V4oV6
+}
+
 func (setup *testSetup) excluded(vids []uint16) bool {
 	for _, vid := range vids {
 		for _, extv := range setup.ExcludedVLANs {
@@ -116,9 +173,6 @@ func (setup *testSetup) init() error {
 	if setup.Ifname == "" {
 		return fmt.Errorf("interface name can't be empty")
 	}
-	if setup.NumOfClients <= 0 {
-		return fmt.Errorf("number of clients can't be zero")
-	}
 	iff, err := net.InterfaceByName(setup.Ifname)
 	if err != nil {
 		return fmt.Errorf("can't find interface %v,%w", setup.Ifname, err)
@@ -126,15 +180,70 @@ func (setup *testSetup) init() error {
 	if len(setup.StartMAC) == 0 {
 		setup.StartMAC = iff.HardwareAddr
 	}
-	if !setup.EnableV4 && !setup.EnableV6 {
-		return fmt.Errorf("both DHCPv4 and DHCPv6 are disabled")
-	}
 	if setup.SourceV4Port == 0 {
 		return fmt.Errorf("source v4 port can't be zero")
 	}
 	if setup.SourceV6Port == 0 {
 		return fmt.Errorf("source v6 port can't be zero")
 	}
+	if setup.RetransJitter > 100 {
+		return fmt.Errorf("retransmission jitter can't be more than 100 percent")
+	}
+	for _, pct := range setup.ReboundPct {
+		if pct == 0 || pct > 100 {
+			return fmt.Errorf("invalid rebound percentage %d", pct)
+		}
+	}
+	if err = setup.initPopulation(); err != nil {
+		return err
+	}
+	setup.pktRelay, err = createPktRelay(setup)
+	if err != nil {
+		return err
+	}
+	if setup.GroupFile != "" {
+		if setup.groups, err = loadGroupFile(setup.GroupFile, setup); err != nil {
+			return err
+		}
+	}
+	if setup.SaveLease || setup.Action == actionRelease {
+		var v4chan chan *v4LeaseWithID
+		var v6chan chan *v6LeaseWithID
+		for _, p := range setup.populations() {
+			if p.EnableV4 && v4chan == nil {
+				v4chan = make(chan *v4LeaseWithID, saveChanDepth)
+			}
+			if p.EnableV6 && v6chan == nil {
+				v6chan = make(chan *v6LeaseWithID, saveChanDepth)
+			}
+		}
+		setup.saveV4Chan, setup.saveV6Chan = v4chan, v6chan
+		for _, g := range setup.groups {
+			g.saveV4Chan, g.saveV6Chan = v4chan, v6chan
+		}
+	}
+
+	return nil
+}
+
+// initPopulation checks and initializes settings of the client population described by setup
+func (setup *testSetup) initPopulation() error {
+	var err error
+	if setup.ClientFile != "" {
+		if setup.clientEntries, err = loadClientFile(setup.ClientFile); err != nil {
+			return err
+		}
+		setup.NumOfClients = uint(len(setup.clientEntries))
+		if err = setup.validateClientEntries(); err != nil {
+			return fmt.Errorf("invalid client file %v, %w", setup.ClientFile, err)
+		}
+	}
+	if setup.NumOfClients <= 0 {
+		return fmt.Errorf("number of clients can't be zero")
+	}
+	if !setup.EnableV4 && !setup.EnableV6 {
+		return fmt.Errorf("both DHCPv4 and DHCPv6 are disabled")
+	}
 	if setup.EnableV4 {
 		if !setup.SourceV4Addr.IsUnspecified() {
 			if !setup.SourceV4Addr.Is4() || !setup.SourceV4Addr.IsGlobalUnicast() {
@@ -154,7 +263,18 @@ func (setup *testSetup) init() error {
 			fmt.Printf("warning: giaddr should be specified along with srcv4 address")
 		}
 	}
-	if setup.EnableV6 {
+	if setup.V4oV6 {
+		if !setup.EnableV4 {
+			return fmt.Errorf("v4ov6 requires DHCPv4")
+		}
+		if !setup.GiAddr.IsUnspecified() || !setup.SourceV4Addr.IsUnspecified() {
+			return fmt.Errorf("giaddr and srcv4 can't be used with v4ov6")
+		}
+		if setup.Action.needLease() || setup.Action == actionFuzz || setup.Action == actionRelay {
+			return fmt.Errorf("v4ov6 can't be used with action %v", setup.Action)
+		}
+	}
+	if setup.v6Transport() {
 		if !setup.SourceV6Addr.IsUnspecified() {
 			if !setup.SourceV6Addr.Is6() || !setup.SourceV6Addr.IsGlobalUnicast() {
 				return fmt.Errorf("source v6 address must be an IPv4 unicast addr")
@@ -176,29 +296,113 @@ func (setup *testSetup) init() error {
 		}
 		setup.ExcludedVLANs = append(setup.ExcludedVLANs, n)
 	}
-	if setup.VendorClass != "" {
-		setup.v4Options = append(setup.v4Options, dhcpv4.OptClassIdentifier(setup.VendorClass))
-		setup.v6Options.Add(&dhcpv6.OptVendorClass{
-			EnterpriseNumber: BBFEnterpriseNumber,
-			Data:             [][]byte{[]byte(setup.VendorClass)},
-		})
+	for name, tmpl := range map[string]string{
+		"remote-id":    setup.RID,
+		"circuit-id":   setup.CID,
+		"client-id":    setup.ClntID,
+		"vendor class": setup.VendorClass,
+		"IA_NA hint":   setup.NAHint,
+		"IA_PD hint":   setup.PDHint,
+	} {
+		if err = validateTemplate(tmpl); err != nil {
+			return fmt.Errorf("invalid %v template %v, %w", name, tmpl, err)
+		}
+	}
+	if setup.EnableV6 {
+		if setup.NeedNA && setup.NumOfNA == 0 {
+			return fmt.Errorf("number of IA_NA can't be zero")
+		}
+		if setup.NeedPD && setup.NumOfPD == 0 {
+			return fmt.Errorf("number of IA_PD can't be zero")
+		}
+		if setup.PDLenHint > 128 {
+			return fmt.Errorf("%d is not a valid prefix length", setup.PDLenHint)
+		}
+		ccfg := &clientConfig{setup: setup, vars: templateVars{MAC: net.HardwareAddr{0, 0, 0, 0, 0, 0}}}
+		if _, err = ccfg.naHint(0); err != nil {
+			return err
+		}
+		if _, err = ccfg.pdHint(0); err != nil {
+			return err
+		}
+	}
+	if !setup.ReqIPStart.Is4() {
+		return fmt.Errorf("requested address %v is not IPv4", setup.ReqIPStart)
+	}
+	for _, pool := range setup.V4Pools {
+		if !pool.Start.Is4() {
+			return fmt.Errorf("v4 pool %v-%v is not IPv4", pool.Start, pool.End)
+		}
+	}
+	for _, pool := range setup.NAPools {
+		if pool.Start.Is4() {
+			return fmt.Errorf("IA_NA pool %v-%v is not IPv6", pool.Start, pool.End)
+		}
+	}
+	for _, co := range setup.CustomV4Options {
+		if err = co.validate(false); err != nil {
+			return fmt.Errorf("invalid custom DHCPv4 option %d, %w", co.Code, err)
+		}
+	}
+	for _, co := range setup.CustomV6Options {
+		if err = co.validate(true); err != nil {
+			return fmt.Errorf("invalid custom DHCPv6 option %d, %w", co.Code, err)
+		}
+	}
+	for _, rh := range setup.RelayHops {
+		if err = rh.validate(); err != nil {
+			return fmt.Errorf("invalid relay hop, %w", err)
+		}
 	}
-	if setup.CustomV4Option.Code != nil {
-		setup.v4Options = append(setup.v4Options, setup.CustomV4Option)
+	if err = validateTemplate(setup.LinkAddr); err != nil {
+		return fmt.Errorf("invalid linkaddr, %w", err)
 	}
-	if setup.CustomV6Option.OptionCode != 0 {
-		setup.v6Options = append(setup.v6Options, &setup.CustomV6Option)
+	if _, err = setup.v6LinkAddr(templateVars{MAC: net.HardwareAddr{0, 0, 0, 0, 0, 0}, VLANs: []uint16{1, 1}}); err != nil {
+		return err
 	}
+	for _, svr := range setup.V6Servers {
+		if !svr.Is6() || svr.Is4In6() {
+			return fmt.Errorf("DHCPv6 server %v is not an IPv6 address", svr)
+		}
+	}
+	for _, attr := range setup.RadiusAttrs {
+		if err = validateRadiusAttr(attr); err != nil {
+			return fmt.Errorf("invalid RADIUS attribute %d, %w", attr.Code, err)
+		}
+	}
+	if err = validateTemplate(setup.SubscriberID); err != nil {
+		return fmt.Errorf("invalid subscriberid, %w", err)
+	}
+	setup.v6MsgTypeConf = setup.V6MsgType
+	if setup.Action == actionRelay {
+		//relay options are always added in Relay-Forward of action relay
+		setup.V6MsgType = dhcpv6.MessageTypeRelayForward
+	}
+	customRelay := setup.LinkAddr != "::" || len(setup.V6Servers) > 0 || setup.SubscriberID != "" ||
+		setup.ClientLLAddr || setup.RelaySrcPort || len(setup.RadiusAttrs) > 0
 	if setup.V6MsgType == dhcpv6.MessageTypeNone {
-		if setup.RID != "" || setup.CID != "" {
+		if setup.RID != "" || setup.CID != "" || len(setup.RelayHops) > 0 || setup.LDRA || customRelay {
 			setup.V6MsgType = dhcpv6.MessageTypeRelayForward
 		} else {
 			setup.V6MsgType = dhcpv6.MessageTypeSolicit
 		}
 	}
-	setup.pktRelay, err = createPktRelay(setup)
-	if err != nil {
-		return err
+	if len(setup.RelayHops) > 0 && setup.V6MsgType != dhcpv6.MessageTypeRelayForward {
+		return fmt.Errorf("relay hops require DHCPv6 relay")
+	}
+	if customRelay && setup.V6MsgType != dhcpv6.MessageTypeRelayForward {
+		return fmt.Errorf("linkaddr, v6server and relay options require DHCPv6 relay")
+	}
+	if setup.LDRA {
+		if setup.V6MsgType != dhcpv6.MessageTypeRelayForward {
+			return fmt.Errorf("LDRA requires DHCPv6 relay")
+		}
+		if setup.LinkAddr != "::" {
+			return fmt.Errorf("link-address of LDRA is always ::")
+		}
+		if setup.CID == "" && setup.ClientFile == "" {
+			return fmt.Errorf("LDRA requires cid as Interface-ID")
+		}
 	}
 	if setup.Flapping.FlapNum > int(setup.NumOfClients) {
 		return fmt.Errorf("flapping number %d can't be bigger than client number %d", setup.Flapping.FlapNum, setup.NumOfClients)
@@ -206,87 +410,75 @@ func (setup *testSetup) init() error {
 	if setup.Flapping.MinInterval > setup.Flapping.MaxInterval {
 		return fmt.Errorf("minimal flapping interval %v is bigger than max value %v", setup.Flapping.MinInterval, setup.Flapping.MaxInterval)
 	}
-
-	if setup.SaveLease || setup.Action == actionRelease {
-		if setup.EnableV4 {
-			setup.saveV4Chan = make(chan *v4LeaseWithID, saveChanDepth)
+	if setup.Action == actionExhaust {
+		if setup.ClientFile != "" {
+			return fmt.Errorf("pool exhaustion can't be used with client file")
 		}
-		if setup.EnableV6 {
-			setup.saveV6Chan = make(chan *v6LeaseWithID, saveChanDepth)
+		if setup.ExhaustFails == 0 {
+			return fmt.Errorf("exhaustfails can't be zero")
 		}
 	}
-
-	return nil
-}
-
-func parseD4CustomOptionStr(coptStr string) (dhcpv4.Option, error) {
-	strList := strings.SplitN(coptStr, ":", 2)
-	if len(strList) < 2 {
-		return dhcpv4.Option{}, fmt.Errorf("invalid custom option %v", coptStr)
+	if setup.Action == actionFuzz {
+		if setup.Fuzz.Num == 0 {
+			return fmt.Errorf("fuzznum can't be zero with action fuzz")
+		}
+		if setup.Fuzz.Interval <= 0 {
+			return fmt.Errorf("fuzzinterval must be positive")
+		}
 	}
-	var oid int
-	var err error
-	if oid, err = strconv.Atoi(strList[0]); err != nil {
-		return dhcpv4.Option{}, fmt.Errorf("%v is not a number", strList[0])
+	if setup.Action == actionRelay {
+		if err = setup.validateRelay(); err != nil {
+			return err
+		}
 	}
-	return dhcpv4.Option{
-		Code: dhcpv4.GenericOptionCode(oid),
-		Value: dhcpv4.OptionGeneric{
-			Data: []byte(strList[1]),
-		},
-	}, nil
-
-}
-
-func parseD6CustomOptionStr(coptStr string) (dhcpv6.Option, error) {
-	strList := strings.SplitN(coptStr, ":", 2)
-	if len(strList) < 2 {
-		return nil, fmt.Errorf("invalid custom option %v", coptStr)
+	if setup.Reconfigure {
+		if !setup.EnableV6 {
+			return fmt.Errorf("reconfigure requires DHCPv6")
+		}
+		if setup.Action != actionDORA {
+			return fmt.Errorf("reconfigure can't be used with action %v", setup.Action)
+		}
 	}
-	var oid int
-	var err error
-	if oid, err = strconv.Atoi(strList[0]); err != nil {
-		return nil, fmt.Errorf("%v is not a number", strList[0])
+	if setup.ForceRenew {
+		if !setup.EnableV4 || setup.V4oV6 {
+			return fmt.Errorf("forcerenew requires native DHCPv4")
+		}
+		if !setup.GiAddr.IsUnspecified() || !setup.SourceV4Addr.IsUnspecified() {
+			return fmt.Errorf("giaddr and srcv4 can't be used with forcerenew")
+		}
+		if setup.Action != actionDORA {
+			return fmt.Errorf("forcerenew can't be used with action %v", setup.Action)
+		}
 	}
-	return &dhcpv6.OptionGeneric{
-		OptionCode: dhcpv6.OptionCode(oid),
-		OptionData: []byte(strList[1]),
-	}, nil
-
-}
-
-func d4OptionFromStr(text string) (any, error) {
-	if text == "" {
-		return dhcpv4.Option{
-			Code: nil,
-		}, nil
+	if setup.ForceRenewNonce && !setup.ForceRenew {
+		return fmt.Errorf("forcerenewnonce requires forcerenew")
 	}
-	return parseD4CustomOptionStr(text)
-}
-
-func d4OptionToStr(in any) (string, error) {
-	v := in.(dhcpv4.Option)
-	if v.Code == nil {
-		return "", nil
+	switch setup.Auth {
+	case authDelayed:
+		if setup.AuthKeyFile == "" {
+			return fmt.Errorf("delayed authentication requires authkeyfile")
+		}
+		if setup.authKeys, err = loadAuthKeyFile(setup.AuthKeyFile); err != nil {
+			return err
+		}
+	case authReconfKey:
+		if !setup.EnableV6 {
+			return fmt.Errorf("reconfkey authentication requires DHCPv6")
+		}
 	}
-	return fmt.Sprintf("%d:%v", v.Code.Code(), v.Value.String()), nil
-}
-
-func d6OptionFromStr(text string) (any, error) {
-	if text == "" {
-		return dhcpv6.OptionGeneric{
-			OptionCode: 0,
-		}, nil
+	if setup.Auth != authNone && (setup.Action == actionFuzz || setup.Action == actionRelay) {
+		return fmt.Errorf("authentication can't be used with action %v", setup.Action)
 	}
-	return parseD6CustomOptionStr(text)
-}
-
-func d6OptionToStr(in any) (string, error) {
-	v := in.(dhcpv6.OptionGeneric)
-	if v.OptionCode == 0 {
-		return "", nil
+	if setup.Churn.enabled() {
+		if setup.ClientFile != "" {
+			return fmt.Errorf("churn can't be used with client file")
+		}
+		if setup.Flapping.FlapNum > 0 {
+			return fmt.Errorf("churn and flapping can't be enabled at the same time")
+		}
 	}
-	return fmt.Sprintf("%d:%v", v.OptionCode, string(v.OptionData)), nil
+
+	return nil
 }
 
 func d6MsgTypeFromStr(text string) (any, error) {
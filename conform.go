@@ -0,0 +1,367 @@
+// conform
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// server behaviors observed by conformance cases, DHCPv6 replies are represented by the status code name
+const (
+	behaviorSilence = "silence"
+	behaviorACK     = "ACK"
+	behaviorNAK     = "NAK"
+	behaviorError   = "error"
+	behaviorOffer   = "OFFER"    //server offers after the case
+	behaviorNoOffer = "no-OFFER" //server doesn't offer after the case
+)
+
+// conformBogusServerMAC is used to create the wrong server id, it is in the documentation range of RFC7042
+var conformBogusServerMAC = net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x00}
+
+// conformCase is a negative test case, the message it sends must be rejected or ignored by the server;
+// cases of a stack run in order on the same client after it got a lease,
+// a case could depend on the state left by previous cases, e.g. the lease is released
+type conformCase struct {
+	name   string
+	expect []string //acceptable server behaviors
+	run    func(ctx context.Context, dc *DClient) (behavior string, err error)
+}
+
+var v4ConformCases = []conformCase{
+	{
+		//RFC2131 section 4.3.2, the request is for another server
+		name:   "DHCPv4 Request with wrong server ID",
+		expect: []string{behaviorSilence},
+		run: func(ctx context.Context, dc *DClient) (string, error) {
+			return dc.conformV4(ctx,
+				dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 0, 2, 1))),
+				dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(dc.d4Lease.Lease.ACK.YourIPAddr)))
+		},
+	},
+	{
+		//RFC2131 section 4.3.2, INIT-REBOOT with an address on the wrong network
+		name:   "DHCPv4 Request for address outside subnet",
+		expect: []string{behaviorNAK},
+		run: func(ctx context.Context, dc *DClient) (string, error) {
+			ack := dc.d4Lease.Lease.ACK
+			return dc.conformV4(ctx,
+				dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(outsideV4Addr(ack.YourIPAddr, ack.SubnetMask()))))
+		},
+	},
+	{
+		//RFC2131 section 4.3.4, server doesn't respond to DHCPRELEASE,
+		//and it must still serve the client after the duplicate release
+		name:   "DHCPv4 Release twice",
+		expect: []string{behaviorOffer},
+		run: func(ctx context.Context, dc *DClient) (string, error) {
+			if err := dc.releasev4(nil); err != nil {
+				return "", err
+			}
+			behavior, err := dc.conformV4(ctx,
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+				dhcpv4.WithBroadcast(false),
+				dhcpv4.WithClientIP(dc.d4Lease.Lease.ACK.YourIPAddr),
+				dhcpv4.WithOption(dhcpv4.OptServerIdentifier(dc.d4Lease.Lease.ACK.ServerIdentifier())))
+			if err != nil || behavior != behaviorSilence {
+				return behavior, err
+			}
+			return dc.conformV4Offer(ctx)
+		},
+	},
+	{
+		//after release, RFC2131 doesn't define the behavior, but the address must not be renewed
+		name:   "DHCPv4 Renew for non-existing binding",
+		expect: []string{behaviorNAK, behaviorSilence},
+		run: func(ctx context.Context, dc *DClient) (string, error) {
+			return dc.conformV4(ctx, dhcpv4.WithClientIP(dc.d4Lease.Lease.ACK.YourIPAddr))
+		},
+	},
+}
+
+var v6ConformCases = []conformCase{
+	{
+		//RFC8415 section 16.4, server discards Request with other server's id
+		name:   "DHCPv6 Request with wrong server ID",
+		expect: []string{behaviorSilence},
+		run: func(ctx context.Context, dc *DClient) (string, error) {
+			return dc.conformV6(ctx, dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) {
+				msg.UpdateOption(dhcpv6.OptServerID(&dhcpv6.DUIDLL{
+					HWType:        iana.HWTypeEthernet,
+					LinkLayerAddr: conformBogusServerMAC,
+				}))
+			})
+		},
+	},
+	{
+		//RFC8415 section 18.3.4
+		name:   "DHCPv6 Renew with unknown IAID",
+		expect: []string{iana.StatusNoBinding.String()},
+		run: func(ctx context.Context, dc *DClient) (string, error) {
+			return dc.conformV6(ctx, dhcpv6.MessageTypeRenew, func(msg *dhcpv6.Message) {
+				ias := []dhcpv6.Option{}
+				for _, na := range msg.Options.IANA() {
+					ias = append(ias, &dhcpv6.OptIANA{IaId: unknownIAID(na.IaId), T1: na.T1, T2: na.T2, Options: na.Options})
+				}
+				for _, pd := range msg.Options.IAPD() {
+					ias = append(ias, &dhcpv6.OptIAPD{IaId: unknownIAID(pd.IaId), T1: pd.T1, T2: pd.T2, Options: pd.Options})
+				}
+				msg.Options.Del(dhcpv6.OptionIANA)
+				msg.Options.Del(dhcpv6.OptionIAPD)
+				for _, ia := range ias {
+					msg.AddOption(ia)
+				}
+			})
+		},
+	},
+	{
+		//RFC8415 section 18.3.7
+		name:   "DHCPv6 Release twice",
+		expect: []string{iana.StatusNoBinding.String()},
+		run: func(ctx context.Context, dc *DClient) (string, error) {
+			if err := dc.releaseOrRenewV6(nil, dhcpv6.MessageTypeRelease); err != nil {
+				return "", err
+			}
+			return dc.conformV6(ctx, dhcpv6.MessageTypeRelease, nil)
+		},
+	},
+	{
+		//after release, RFC8415 section 18.3.4
+		name:   "DHCPv6 Renew for non-existing binding",
+		expect: []string{iana.StatusNoBinding.String()},
+		run: func(ctx context.Context, dc *DClient) (string, error) {
+			return dc.conformV6(ctx, dhcpv6.MessageTypeRenew, nil)
+		},
+	},
+}
+
+// outsideV4Addr returns the first host address of the neighboring subnet of addr,
+// mask is assumed to be /24 if it is nil
+func outsideV4Addr(addr net.IP, mask net.IPMask) net.IP {
+	ones, bits := mask.Size()
+	if bits != 32 {
+		ones = 24
+	}
+	if ones == 0 {
+		//whole address space is on the subnet, nothing is outside
+		ones = 1
+	}
+	v := binary.BigEndian.Uint32(addr.To4())
+	bit := uint32(1) << (32 - ones)
+	v ^= bit
+	if ones < 31 {
+		v = v&^(bit-1) | 1
+	}
+	r := make(net.IP, 4)
+	binary.BigEndian.PutUint32(r, v)
+	return r
+}
+
+// unknownIAID returns an IAID different from iaid
+func unknownIAID(iaid [4]byte) [4]byte {
+	for i := range iaid {
+		iaid[i] ^= 0xff
+	}
+	return iaid
+}
+
+// conformV4 sends a DHCPv4 request of dc's lease, modified by mods, and returns the server behavior
+func (dc *DClient) conformV4(ctx context.Context, mods ...dhcpv4.Modifier) (string, error) {
+	req, err := dc.newV4Request(mods...)
+	if err != nil {
+		return "", err
+	}
+	resp, err := dc.d4.SendAndRead(ctx, dc.d4.RemoteAddr(), req, nil)
+	switch {
+	case errors.Is(err, nclient4.ErrNoResponse):
+		return behaviorSilence, nil
+	case err != nil:
+		return "", err
+	}
+	switch resp.MessageType() {
+	case dhcpv4.MessageTypeAck:
+		return behaviorACK, nil
+	case dhcpv4.MessageTypeNak:
+		return behaviorNAK, nil
+	}
+	return resp.MessageType().String(), nil
+}
+
+// conformV4Offer sends a DISCOVER of dc, returns OFFER if server offers, otherwise no-OFFER
+func (dc *DClient) conformV4Offer(ctx context.Context) (string, error) {
+	_, err := dc.d4.DiscoverOffer(ctx, dc.v4IDModifiers()...)
+	switch {
+	case errors.Is(err, nclient4.ErrNoResponse):
+		return behaviorNoOffer, nil
+	case err != nil:
+		return "", err
+	}
+	return behaviorOffer, nil
+}
+
+// conformV6 sends a DHCPv6 msg of type mt generated from dc's lease, modified by mod if not nil,
+// and returns the server behavior
+func (dc *DClient) conformV6(ctx context.Context, mt dhcpv6.MessageType, mod func(msg *dhcpv6.Message)) (string, error) {
+	msg, err := dc.d6Lease.Genv6Release(mt)
+	if err != nil {
+		return "", err
+	}
+	if mod != nil {
+		mod(msg)
+	}
+	reply, err := dc.d6.SendAndRead(ctx, nclient6.AllDHCPRelayAgentsAndServers, msg, nil)
+	switch {
+	case errors.Is(err, nclient6.ErrNoResponse):
+		return behaviorSilence, nil
+	case err != nil:
+		return "", err
+	}
+	if reply.MessageType != dhcpv6.MessageTypeReply {
+		return reply.MessageType.String(), nil
+	}
+	return v6ReplyStatus(reply).String(), nil
+}
+
+// v6ReplyStatus returns the status of the message if it is not success,
+// otherwise the first non-success status of IAs
+func v6ReplyStatus(reply *dhcpv6.Message) iana.StatusCode {
+	statusList := []*dhcpv6.OptStatusCode{reply.Options.Status()}
+	for _, na := range reply.Options.IANA() {
+		statusList = append(statusList, na.Options.Status())
+	}
+	for _, pd := range reply.Options.IAPD() {
+		statusList = append(statusList, pd.Options.Status())
+	}
+	for _, status := range statusList {
+		if status != nil && status.StatusCode != iana.StatusSuccess {
+			return status.StatusCode
+		}
+	}
+	return iana.StatusSuccess
+}
+
+// conformResult is the result of a conformance case across all clients
+type conformResult struct {
+	pass, fail, skip int
+	behaviors        map[string]int
+}
+
+// conformTable is the pass/fail table of all conformance cases
+type conformTable struct {
+	lock    *sync.Mutex
+	results map[string]*conformResult
+}
+
+func newConformTable() *conformTable {
+	r := &conformTable{
+		lock:    new(sync.Mutex),
+		results: make(map[string]*conformResult),
+	}
+	for _, c := range append(v4ConformCases, v6ConformCases...) {
+		r.results[c.name] = &conformResult{behaviors: make(map[string]int)}
+	}
+	return r
+}
+
+// add records the result of case c, skipped is true if the case didn't run
+func (ct *conformTable) add(c conformCase, behavior string, skipped bool) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	r := ct.results[c.name]
+	if skipped {
+		r.skip++
+		return
+	}
+	r.behaviors[behavior]++
+	for _, e := range c.expect {
+		if e == behavior {
+			r.pass++
+			return
+		}
+	}
+	r.fail++
+}
+
+func (ct *conformTable) String() string {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	buf := new(strings.Builder)
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Case\tExpect\tPass\tFail\tSkip\tResult\tObserved")
+	for _, c := range append(v4ConformCases, v6ConformCases...) {
+		r := ct.results[c.name]
+		if r.pass+r.fail+r.skip == 0 {
+			continue
+		}
+		result := "PASS"
+		switch {
+		case r.fail > 0:
+			result = "FAIL"
+		case r.pass == 0:
+			result = "SKIP"
+		}
+		behaviors := []string{}
+		for b, n := range r.behaviors {
+			behaviors = append(behaviors, fmt.Sprintf("%v(%d)", b, n))
+		}
+		sort.Strings(behaviors)
+		fmt.Fprintf(w, "%v\t%v\t%d\t%d\t%d\t%v\t%v\n", c.name, strings.Join(c.expect, "|"),
+			r.pass, r.fail, r.skip, result, strings.Join(behaviors, " "))
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// conformAll dials dc, then runs all conformance cases of enabled stacks;
+// cases of a stack are skipped if dc fails to get a lease of the stack
+func (dc *DClient) conformAll(ctx context.Context, wg *sync.WaitGroup, table *conformTable) {
+	defer wg.Done()
+	runCases := func(cases []conformCase, dial func(wg *sync.WaitGroup) error) {
+		err := dial(nil)
+		if err != nil {
+			common.MyLog("%v failed to get lease for conformance test, %v", dc.id, err)
+		}
+		for _, c := range cases {
+			if err != nil || ctx.Err() != nil {
+				table.add(c, "", true)
+				continue
+			}
+			behavior, cerr := c.run(ctx, dc)
+			if cerr != nil {
+				common.MyLog("conformance case %v of %v failed, %v", c.name, dc.id, cerr)
+				behavior = behaviorError
+			}
+			table.add(c, behavior, false)
+		}
+	}
+	subwg := new(sync.WaitGroup)
+	if dc.d4 != nil {
+		subwg.Add(1)
+		go func() {
+			defer subwg.Done()
+			runCases(v4ConformCases, dc.dialv4)
+		}()
+	}
+	if dc.d6 != nil {
+		subwg.Add(1)
+		go func() {
+			defer subwg.Done()
+			runCases(v6ConformCases, dc.dialv6)
+		}()
+	}
+	subwg.Wait()
+}
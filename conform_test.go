@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestConform(t *testing.T) {
+	for _, c := range []struct {
+		addr   string
+		mask   net.IPMask
+		expect string
+	}{
+		{"192.168.1.10", net.CIDRMask(24, 32), "192.168.0.1"},
+		{"10.0.0.100", net.CIDRMask(16, 32), "10.1.0.1"},
+		{"192.168.1.10", nil, "192.168.0.1"},
+		{"192.168.1.11", net.CIDRMask(32, 32), "192.168.1.10"},
+	} {
+		if r := outsideV4Addr(net.ParseIP(c.addr), c.mask); r.String() != c.expect {
+			t.Fatalf("outside address of %v/%v should be %v, got %v", c.addr, c.mask, c.expect, r)
+		}
+	}
+	if unknownIAID([4]byte{0, 0, 0, 1}) != [4]byte{0xff, 0xff, 0xff, 0xfe} {
+		t.Fatal("wrong unknown IAID")
+	}
+	reply, _ := dhcpv6.NewMessage()
+	reply.MessageType = dhcpv6.MessageTypeReply
+	if s := v6ReplyStatus(reply); s != iana.StatusSuccess {
+		t.Fatalf("empty reply should be success, got %v", s)
+	}
+	na := &dhcpv6.OptIANA{}
+	na.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoBinding})
+	reply.AddOption(na)
+	if s := v6ReplyStatus(reply); s != iana.StatusNoBinding {
+		t.Fatalf("reply should be NoBinding, got %v", s)
+	}
+	table := newConformTable()
+	table.add(v4ConformCases[0], behaviorSilence, false)
+	table.add(v4ConformCases[1], behaviorACK, false)
+	table.add(v4ConformCases[1], behaviorNAK, false)
+	table.add(v6ConformCases[0], "", true)
+	s := table.String()
+	rows := map[string][]string{}
+	for _, line := range strings.Split(s, "\n") {
+		for _, c := range append(v4ConformCases, v6ConformCases...) {
+			if strings.HasPrefix(line, c.name) {
+				rows[c.name] = strings.Fields(strings.TrimPrefix(line, c.name))
+			}
+		}
+	}
+	for name, expect := range map[string]string{
+		v4ConformCases[0].name: "silence 1 0 0 PASS silence(1)",
+		v4ConformCases[1].name: "NAK 1 1 0 FAIL ACK(1) NAK(1)",
+		v6ConformCases[0].name: "silence 0 0 1 SKIP",
+	} {
+		if got := strings.Join(rows[name], " "); got != expect {
+			t.Fatalf("row of %v should be %q, got %q in table:\n%v", name, expect, got, s)
+		}
+	}
+	if strings.Contains(s, v4ConformCases[2].name) {
+		t.Fatalf("case without result should not be in the table:\n%v", s)
+	}
+}
+
+// fakeOfferServer offers DISCOVER on conn if offer is true, other messages are ignored
+func fakeOfferServer(conn net.PacketConn, svrID net.IP, offer bool) {
+	for {
+		buf := make([]byte, demuxBufSize)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil || n == 0 {
+			//conn is closed
+			return
+		}
+		req, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil || req.MessageType() != dhcpv4.MessageTypeDiscover || !offer {
+			continue
+		}
+		resp, _ := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+			dhcpv4.WithYourIP(net.ParseIP("192.0.2.100")),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(svrID)))
+		conn.WriteTo(resp.ToBytes(), nil)
+	}
+}
+
+func TestConformReleaseTwice(t *testing.T) {
+	svrID := net.ParseIP("192.0.2.1")
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	var releaseTwice conformCase
+	for _, c := range v4ConformCases {
+		if c.name == "DHCPv4 Release twice" {
+			releaseTwice = c
+		}
+	}
+	for _, offer := range []bool{true, false} {
+		clnt, svr := conpair.NewPacketConnPair()
+		go fakeOfferServer(svr, svrID, offer)
+		d4, err := nclient4.NewWithConn(clnt, mac, nclient4.WithTimeout(100*time.Millisecond), nclient4.WithRetry(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dc := &DClient{
+			cfg:          &clientConfig{Mac: mac, setup: newDefaultConf()},
+			d4:           d4,
+			d4Lease:      genV4Lease(t, svrID, mac, nil),
+			dialResultCh: make(chan *dialResult, 1),
+		}
+		expected := behaviorOffer
+		if !offer {
+			expected = behaviorNoOffer
+		}
+		if behavior, err := releaseTwice.run(context.Background(), dc); err != nil || behavior != expected {
+			t.Fatalf("server offers %v, expect %v, got %v, %v", offer, expected, behavior, err)
+		}
+		//stops receive loop of d4 and the server
+		svr.Close()
+		clnt.Close()
+	}
+}
@@ -0,0 +1,166 @@
+// demux
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+const (
+	demuxChanDepth = 32
+	demuxBufSize   = 1500
+)
+
+type demuxPkt struct {
+	buf  []byte
+	addr net.Addr
+}
+
+// msgDemux shares a DHCP conn among a DHCP client and other receivers like DHCPv4-over-DHCPv6 client,
+// a received message goes to the side of its message type, or the default side, it is dropped if there is no such side;
+// the shared conn is closed after all sides are closed
+type msgDemux[T comparable] struct {
+	conn      net.PacketConn
+	msgType   func(buf []byte) (T, bool) //returns message type of buf, false if buf is not a valid message
+	sides     map[T]*demuxConn[T]
+	dftSide   *demuxConn[T]
+	lock      *sync.Mutex
+	open      int
+	recvErr   error
+	recvEnded chan struct{}
+}
+
+func newMsgDemux[T comparable](conn net.PacketConn, msgType func(buf []byte) (T, bool)) *msgDemux[T] {
+	return &msgDemux[T]{
+		conn:      conn,
+		msgType:   msgType,
+		sides:     make(map[T]*demuxConn[T]),
+		lock:      new(sync.Mutex),
+		recvEnded: make(chan struct{}),
+	}
+}
+
+// newDHCPv6Demux returns a demux of DHCPv6 conn
+func newDHCPv6Demux(conn net.PacketConn) *msgDemux[dhcpv6.MessageType] {
+	return newMsgDemux(conn, func(buf []byte) (dhcpv6.MessageType, bool) {
+		if len(buf) == 0 {
+			return 0, false
+		}
+		return dhcpv6.MessageType(buf[0]), true
+	})
+}
+
+// newDHCPv4Demux returns a demux of DHCPv4 conn
+func newDHCPv4Demux(conn net.PacketConn) *msgDemux[dhcpv4.MessageType] {
+	return newMsgDemux(conn, func(buf []byte) (dhcpv4.MessageType, bool) {
+		msg, err := dhcpv4.FromBytes(buf)
+		if err != nil {
+			return 0, false
+		}
+		return msg.MessageType(), true
+	})
+}
+
+// side returns a new side receives messages of types, or all other messages if types is empty;
+// it must be called before start
+func (d *msgDemux[T]) side(types ...T) net.PacketConn {
+	r := newDemuxConn(d)
+	d.open++
+	if len(types) == 0 {
+		d.dftSide = r
+	}
+	for _, t := range types {
+		d.sides[t] = r
+	}
+	return r
+}
+
+// start starts receiving from the shared conn
+func (d *msgDemux[T]) start() {
+	go d.recv()
+}
+
+func (d *msgDemux[T]) recv() {
+	defer close(d.recvEnded)
+	for {
+		buf := make([]byte, demuxBufSize)
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			d.recvErr = err
+			return
+		}
+		t, ok := d.msgType(buf[:n])
+		if !ok {
+			continue
+		}
+		side, ok := d.sides[t]
+		if !ok {
+			side = d.dftSide
+		}
+		if side == nil {
+			continue
+		}
+		select {
+		case side.recvCh <- demuxPkt{buf: buf[:n], addr: addr}:
+		case <-side.closed:
+		}
+	}
+}
+
+// close closes the shared conn if side is the last open side
+func (d *msgDemux[T]) close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.open--
+	if d.open == 0 {
+		return d.conn.Close()
+	}
+	return nil
+}
+
+// demuxConn is a side of msgDemux
+type demuxConn[T comparable] struct {
+	net.PacketConn
+	demux     *msgDemux[T]
+	recvCh    chan demuxPkt
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func newDemuxConn[T comparable](d *msgDemux[T]) *demuxConn[T] {
+	return &demuxConn[T]{
+		PacketConn: d.conn,
+		demux:      d,
+		recvCh:     make(chan demuxPkt, demuxChanDepth),
+		closed:     make(chan struct{}),
+		closeOnce:  new(sync.Once),
+	}
+}
+
+func (dc *demuxConn[T]) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-dc.closed:
+		return 0, nil, net.ErrClosed
+	default:
+	}
+	select {
+	case pkt := <-dc.recvCh:
+		return copy(p, pkt.buf), pkt.addr, nil
+	case <-dc.closed:
+		return 0, nil, net.ErrClosed
+	case <-dc.demux.recvEnded:
+		return 0, nil, dc.demux.recvErr
+	}
+}
+
+func (dc *demuxConn[T]) Close() error {
+	var err error
+	dc.closeOnce.Do(func() {
+		close(dc.closed)
+		err = dc.demux.close()
+	})
+	return err
+}
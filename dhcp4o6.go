@@ -0,0 +1,67 @@
+// dhcp4o6
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+)
+
+const (
+	maxDHCPv6Size = 1500
+	// dhcp4o6UnicastFlag is the first byte of flags of DHCPv4-Query with unicast flag set, RFC7341 section 6.2
+	dhcp4o6UnicastFlag = 0x80
+)
+
+// dhcp4o6Conn is a net.PacketConn carries DHCPv4 messages over DHCPv6 conn (RFC7341),
+// a DHCPv4 message written is sent in a DHCPv4-Query to All_DHCP_Relay_Agents_and_Servers,
+// the DHCPv4 message in a received DHCPv4-Response is read, other DHCPv6 messages are dropped
+type dhcp4o6Conn struct {
+	net.PacketConn
+	duid dhcpv6.DUID
+}
+
+func newDHCP4o6Conn(conn net.PacketConn, duid dhcpv6.DUID) *dhcp4o6Conn {
+	return &dhcp4o6Conn{PacketConn: conn, duid: duid}
+}
+
+// WriteTo sends p in a DHCPv4-Query, unicast flag is set if addr is not broadcast
+func (c *dhcp4o6Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	msg4, err := dhcpv4.FromBytes(p)
+	if err != nil {
+		return 0, fmt.Errorf("invalid DHCPv4 msg, %w", err)
+	}
+	query := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeDHCPv4Query}
+	if uaddr, ok := addr.(*net.UDPAddr); ok && !uaddr.IP.Equal(net.IPv4bcast) {
+		query.TransactionID[0] = dhcp4o6UnicastFlag
+	}
+	query.AddOption(dhcpv6.OptClientID(c.duid))
+	query.AddOption(&dhcpv6.OptDHCPv4Msg{Msg: msg4})
+	if _, err = c.PacketConn.WriteTo(query.ToBytes(), nclient6.AllDHCPRelayAgentsAndServers); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom reads the DHCPv4 message of next DHCPv4-Response
+func (c *dhcp4o6Conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, maxDHCPv6Size)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		msg, err := dhcpv6.MessageFromBytes(buf[:n])
+		if err != nil || msg.MessageType != dhcpv6.MessageTypeDHCPv4Response {
+			continue
+		}
+		op, ok := msg.Options.GetOne(dhcpv6.OptionDHCPv4Msg).(*dhcpv6.OptDHCPv4Msg)
+		if !ok || op.Msg == nil {
+			continue
+		}
+		return copy(p, op.Msg.ToBytes()), addr, nil
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// fake4o6Server answers DHCPv4-Query on conn, and sends an Advertise before each DHCPv4-Response
+func fake4o6Server(t *testing.T, conn net.PacketConn, queries chan<- *dhcpv6.Message) {
+	svrID := net.ParseIP("192.0.2.1")
+	for {
+		buf := make([]byte, maxDHCPv6Size)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil || n == 0 {
+			//conn is closed
+			return
+		}
+		query, err := dhcpv6.MessageFromBytes(buf[:n])
+		if err != nil || query.MessageType != dhcpv6.MessageTypeDHCPv4Query {
+			t.Errorf("server got invalid query %v, %v", query, err)
+			return
+		}
+		queries <- query
+		req := query.Options.GetOne(dhcpv6.OptionDHCPv4Msg).(*dhcpv6.OptDHCPv4Msg).Msg
+		mt := dhcpv4.MessageTypeOffer
+		if req.MessageType() == dhcpv4.MessageTypeRequest {
+			mt = dhcpv4.MessageTypeAck
+		}
+		reply, _ := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(mt),
+			dhcpv4.WithYourIP(net.ParseIP("192.0.2.100")),
+			dhcpv4.WithServerIP(svrID),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(svrID)),
+			dhcpv4.WithLeaseTime(3600))
+		adv, _ := dhcpv6.NewMessage(dhcpv6.WithServerID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{1, 1, 1, 1, 1, 1}}))
+		adv.MessageType = dhcpv6.MessageTypeAdvertise
+		resp := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeDHCPv4Response}
+		resp.AddOption(&dhcpv6.OptDHCPv4Msg{Msg: reply})
+		conn.WriteTo(adv.ToBytes(), nil)
+		conn.WriteTo(resp.ToBytes(), nil)
+	}
+}
+
+func TestDHCP4o6(t *testing.T) {
+	clnt, svr := conpair.NewPacketConnPair()
+	queries := make(chan *dhcpv6.Message, 8)
+	go fake4o6Server(t, svr, queries)
+	demux := newDHCPv6Demux(clnt)
+	v6Conn, v4o6Conn := demux.side(), demux.side(dhcpv6.MessageTypeDHCPv4Response)
+	demux.start()
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	duid := &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}
+	d4, err := nclient4.NewWithConn(newDHCP4o6Conn(v4o6Conn, duid), mac, nclient4.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err := d4.Request(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lease.ACK.YourIPAddr.Equal(net.ParseIP("192.0.2.100")) {
+		t.Fatalf("wrong lease %v", lease.ACK.Summary())
+	}
+	for i := 0; i < 2; i++ {
+		query := <-queries
+		if query.TransactionID != (dhcpv6.TransactionID{}) || query.Options.ClientID() == nil {
+			t.Fatalf("wrong DHCPv4-Query %v", query.Summary())
+		}
+	}
+	//release is unicast to server
+	if err = d4.Release(lease); err != nil {
+		t.Fatal(err)
+	}
+	if query := <-queries; query.TransactionID[0] != dhcp4o6UnicastFlag {
+		t.Fatalf("unicast flag is not set in %v", query.Summary())
+	}
+	//advertise goes to DHCPv6 side
+	buf := make([]byte, maxDHCPv6Size)
+	n, _, err := v6Conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dhcpv6.MessageType(buf[0]) != dhcpv6.MessageTypeAdvertise || n == 0 {
+		t.Fatalf("DHCPv6 side got %v", dhcpv6.MessageType(buf[0]))
+	}
+	d4.Close()
+	v6Conn.Close()
+	if _, _, err = v6Conn.ReadFrom(buf); err == nil {
+		t.Fatal("read from closed conn should fail")
+	}
+}
@@ -15,12 +15,14 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"runtime"
+	"sort"
 
 	// "runtime/debug"
 
 	"time"
 
 	"github.com/hujun-open/dhcplt/common"
+	"github.com/hujun-open/dhcplt/dhcpv6relay"
 	"github.com/hujun-open/shouchan"
 
 	mv "github.com/RobinUS2/golang-moving-average"
@@ -31,6 +33,7 @@ const (
 	BBFEnterpriseNumber        = 3561
 	EthernetTypeIPv4    uint16 = 0x0800
 	EthernetTypeIPv6    uint16 = 0x86DD
+	EthernetTypeARP     uint16 = 0x0806
 )
 
 type execResult int
@@ -58,31 +61,128 @@ type resultSummary struct {
 	Released       int
 	Renewed        int
 	Rebinded       int
+	Rebooted       int
 	LessThanSecond int
+	OutOfPool      int
+	WrongPrefixLen int
+	DuplicateLease int
+	RuleViolations map[string]int //key is the option rule
+	RelayStats     dhcpv6relay.Stats
 	Shortest       time.Duration
 	Longest        time.Duration
 	TotalTime      time.Duration
 	AvgSuccessTime *mv.MovingAverage
+	reboundAt      []time.Time //finish time of each successful reboot
+	rebootTotal    int         //number of leases to reboot of all stacks, 0 if unknown
 	setup          *testSetup
+	beginTime      time.Time
+	endTime        time.Time
 }
 
 func newResultSummary(s *testSetup) *resultSummary {
 	return &resultSummary{
 		AvgSuccessTime: mv.New(5),
+		RuleViolations: make(map[string]int),
 		setup:          s,
-		Shortest:       maxDuration,
 		Longest:        time.Duration(0),
+		beginTime:      time.Now().AddDate(10, 0, 0),
 	}
 }
 
+// add updates the summary with result r
+func (rs *resultSummary) add(r *dialResult) {
+	completeTime := r.FinishTime.Sub(r.StartTime)
+	if r.FinishTime.After(rs.endTime) {
+		rs.endTime = r.FinishTime
+	}
+	if completeTime < 0 {
+		completeTime = 0
+	}
+	if r.StartTime.Before(rs.beginTime) {
+		rs.beginTime = r.StartTime
+	}
+	rs.Total++
+	rs.OutOfPool += r.LeaseCheck.OutOfPool
+	rs.WrongPrefixLen += r.LeaseCheck.WrongPrefixLen
+	rs.DuplicateLease += r.LeaseCheck.Duplicate
+	for _, rule := range r.RuleViolations {
+		rs.RuleViolations[rule]++
+	}
+	switch r.action {
+	case actionRelease:
+		rs.Released++
+	case actionRebind:
+		rs.Rebinded++
+	case actionRenew:
+		rs.Renewed++
+	}
+	switch r.ExecResult {
+	case resultFailure:
+		rs.Failed++
+	case resultSuccess:
+		if r.action == actionReboot {
+			rs.Rebooted++
+			rs.reboundAt = append(rs.reboundAt, r.FinishTime)
+		}
+		if r.action == actionDORA {
+			rs.Success++
+			rs.AvgSuccessTime.Add(float64(completeTime))
+			if completeTime < time.Second {
+				rs.LessThanSecond++
+			}
+			if completeTime > rs.Longest {
+				rs.Longest = completeTime
+			}
+			if rs.Success == 1 || completeTime < rs.Shortest {
+				rs.Shortest = completeTime
+			}
+		}
+	}
+	rs.TotalTime = rs.endTime.Sub(rs.beginTime)
+}
+
 func (rs resultSummary) String() string {
 	r := "Result Summary\n"
+	if rs.setup.groupName != "" {
+		r = fmt.Sprintf("Result Summary of group %v\n", rs.setup.groupName)
+	}
 	r += fmt.Sprintf("total trans: %d\n", rs.Total)
 	r += fmt.Sprintf("Success dial:%d\n", rs.Success)
 	r += fmt.Sprintf("Success release:%d\n", rs.Released)
 	r += fmt.Sprintf("Success renew:%d\n", rs.Renewed)
 	r += fmt.Sprintf("Success rebind:%d\n", rs.Rebinded)
+	r += fmt.Sprintf("Success reboot:%d\n", rs.Rebooted)
 	r += fmt.Sprintf("Failed trans:%d\n", rs.Failed)
+	if rs.setup.Action == actionReboot {
+		total := rs.rebootTotal
+		if total == 0 {
+			total = rs.Rebooted + rs.Failed
+		}
+		for _, pct := range rs.setup.ReboundPct {
+			if d, ok := reboundTime(rs.beginTime, rs.reboundAt, total, pct); ok {
+				r += fmt.Sprintf("Time to %d%% rebound:%v\n", pct, d)
+			} else {
+				r += fmt.Sprintf("Time to %d%% rebound:never\n", pct)
+			}
+		}
+	}
+	r += fmt.Sprintf("Out of pool lease:%d\n", rs.OutOfPool)
+	r += fmt.Sprintf("Wrong prefix length:%d\n", rs.WrongPrefixLen)
+	r += fmt.Sprintf("Duplicate lease:%d\n", rs.DuplicateLease)
+	rules := []string{}
+	for rule := range rs.RuleViolations {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		r += fmt.Sprintf("Violation of option rule %v:%d\n", rule, rs.RuleViolations[rule])
+	}
+	if rs.RelayStats != (dhcpv6relay.Stats{}) {
+		r += fmt.Sprintf("DHCPv6 relay-fwd sent:%d\n", rs.RelayStats.Forwards)
+		r += fmt.Sprintf("DHCPv6 relay-reply forwarded:%d\n", rs.RelayStats.Replies)
+		r += fmt.Sprintf("DHCPv6 relay-reply mismatch:%d\n", rs.RelayStats.Mismatches)
+		r += fmt.Sprintf("DHCPv6 relay dropped:%d\n", rs.RelayStats.Drops)
+	}
 	r += fmt.Sprintf("Duration:%v\n", rs.TotalTime)
 	r += fmt.Sprintf("Interval:%v\n", rs.setup.Interval)
 	avgSuccess := time.Duration(rs.AvgSuccessTime.Avg())
@@ -94,8 +194,6 @@ func (rs resultSummary) String() string {
 	return r
 }
 
-const maxDuration = time.Duration(int64(^uint64(0) >> 1))
-
 func createPktRelay(setup *testSetup) (etherconn.PacketRelay, error) {
 	switch setup.Driver {
 	case ENG_AFPKT:
@@ -115,7 +213,7 @@ func createPktRelay(setup *testSetup) (etherconn.PacketRelay, error) {
 			etherconn.WithXDPDefaultReceival(false),
 			etherconn.WithXDPSendChanDepth(10240),
 			etherconn.WithXDPUMEMNumOfTrunk(65536),
-			etherconn.WithXDPEtherTypes([]uint16{EthernetTypeIPv4, EthernetTypeIPv6}),
+			etherconn.WithXDPEtherTypes([]uint16{EthernetTypeIPv4, EthernetTypeIPv6, EthernetTypeARP}),
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create xdp relay for if %v, %v", setup.Ifname, err)
@@ -171,18 +269,25 @@ func main() {
 	if setup.Debug {
 		common.Logger = log.New(os.Stderr, "", log.Ldate|log.Ltime)
 	}
+	ctx, cancelf := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go handleCtrlC(c, cancelf)
+	if setup.Action == actionRelay {
+		if err = runRelay(ctx, setup); err != nil {
+			log.Fatalf("failed to run relay, %v", err)
+		}
+		fmt.Println("done.")
+		return
+	}
 	sch, err := NewSched(setup)
 	if err != nil {
 		common.MyLog("failed to create sched, %v", err)
 		return
 	}
-	ctx, cancelf := context.WithCancel(context.Background())
 	wg := new(sync.WaitGroup)
 	wg.Add(1)
 	go sch.run(ctx, wg)
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go handleCtrlC(c, cancelf)
 	wg.Wait()
 	if setup.Profiling {
 		ch := make(chan bool)
@@ -0,0 +1,287 @@
+// relay
+package dhcpv4relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+const (
+	maxDHCPv4Size = 1500
+	// maxHops is the max hops of a BOOTREQUEST could be relayed, RFC1542
+	maxHops = 16
+	// xidTimeout is how long a transaction without ACK or NAK is kept
+	xidTimeout = time.Minute
+)
+
+// OptionFunc returns the options relay agent adds into req
+type OptionFunc func(req *dhcpv4.DHCPv4) ([]dhcpv4.Option, error)
+
+// RelayAgent is a DHCPv4 relay agent between access and network,
+// a BOOTREQUEST from access is sent to servers with giaddr and options added,
+// a BOOTREPLY from network is sent to client via broadcast, or unicast to ciaddr if it is specified
+type RelayAgent struct {
+	accessConn, networkConn net.PacketConn
+	giAddr                  net.IP
+	svrAddrs                []*net.UDPAddr
+	optionFunc              OptionFunc
+	stats                   relayCounters
+	xidLock                 *sync.Mutex
+	xids                    map[dhcpv4.TransactionID]xidEntry //outstanding transactions
+	lastPurge               time.Time                         //last time expired transactions are removed
+}
+
+// xidEntry is an outstanding transaction
+type xidEntry struct {
+	chaddr net.HardwareAddr
+	seen   time.Time //time of last relayed BOOTREQUEST
+}
+
+// Stats is the counters of a relay agent
+type Stats struct {
+	// Forwards is the number of BOOTREQUEST sent to network
+	Forwards uint64
+	// Replies is the number of BOOTREPLY sent to access
+	Replies uint64
+	// Mismatches is the number of BOOTREPLY dropped due to not matching a relayed BOOTREQUEST
+	Mismatches uint64
+	// Drops is the number of other messages dropped, including invalid messages and send failures
+	Drops uint64
+}
+
+// Add adds counters of s2 to s
+func (s *Stats) Add(s2 Stats) {
+	s.Forwards += s2.Forwards
+	s.Replies += s2.Replies
+	s.Mismatches += s2.Mismatches
+	s.Drops += s2.Drops
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("request:%d reply:%d mismatch:%d dropped:%d", s.Forwards, s.Replies, s.Mismatches, s.Drops)
+}
+
+type relayCounters struct {
+	forwards, replies, mismatches, drops atomic.Uint64
+}
+
+type Modifier func(*RelayAgent)
+
+// WithSvrAddrs makes relay agent send BOOTREQUEST to each of addrs
+func WithSvrAddrs(addrs []*net.UDPAddr) Modifier {
+	return func(relay *RelayAgent) {
+		relay.svrAddrs = addrs
+	}
+}
+
+// WithOptionFunc makes relay agent add options returned by f into BOOTREQUEST if it doesn't have option 82,
+// the BOOTREQUEST is dropped if f returns an error
+func WithOptionFunc(f OptionFunc) Modifier {
+	return func(relay *RelayAgent) {
+		relay.optionFunc = f
+	}
+}
+
+// NewRelayAgent creates and starts a relay agent, giaddr is the address of relay agent on network side
+func NewRelayAgent(ctx context.Context, access, network net.PacketConn, giaddr net.IP, options ...Modifier) *RelayAgent {
+	r := new(RelayAgent)
+	r.accessConn = access
+	r.networkConn = network
+	r.giAddr = giaddr.To4()
+	r.xidLock = new(sync.Mutex)
+	r.xids = make(map[dhcpv4.TransactionID]xidEntry)
+	r.lastPurge = time.Now()
+	r.svrAddrs = []*net.UDPAddr{{
+		IP:   net.IPv4bcast,
+		Port: dhcpv4.ServerPort,
+	}}
+	for _, o := range options {
+		o(r)
+	}
+	go r.recvAccess(ctx)
+	go r.recvNetwork(ctx)
+	return r
+}
+
+// Stats returns the current counters of the relay agent
+func (relay *RelayAgent) Stats() Stats {
+	return Stats{
+		Forwards:   relay.stats.forwards.Load(),
+		Replies:    relay.stats.replies.Load(),
+		Mismatches: relay.stats.mismatches.Load(),
+		Drops:      relay.stats.drops.Load(),
+	}
+}
+
+// isTimeout returns true if err is a timeout error
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+func (relay *RelayAgent) recvAccess(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		buf := make([]byte, maxDHCPv4Size)
+		n, peerAddr, err := relay.accessConn.ReadFrom(buf)
+		if err != nil {
+			if isTimeout(err) {
+				continue
+			}
+			common.MyLog("failed to receive from access, %v", err)
+			return
+		}
+		req, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil {
+			common.MyLog("recvd an invalid DHCPv4 msg from access with src addr %v", peerAddr)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		if req.OpCode != dhcpv4.OpcodeBootRequest {
+			common.MyLog("drop a %v msg from access with src addr %v", req.OpCode, peerAddr)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		if req.HopCount >= maxHops {
+			common.MyLog("drop a %v from %v, hops %d exceeds limit", req.MessageType(), req.ClientHWAddr, req.HopCount)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		if err = relay.addRelayInfo(req); err != nil {
+			common.MyLog("drop a %v from %v, %v", req.MessageType(), req.ClientHWAddr, err)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		relay.addXID(req)
+		common.MyLog("relaying %v", req.Summary())
+		for _, svr := range relay.svrAddrs {
+			_, err = relay.networkConn.WriteTo(req.ToBytes(), svr)
+			if err != nil {
+				common.MyLog("failed to send %v to %v, %v", req.MessageType(), svr, err)
+				relay.stats.drops.Add(1)
+				continue
+			}
+			relay.stats.forwards.Add(1)
+		}
+	}
+}
+
+// addRelayInfo sets giaddr and hops of req, and adds options if req doesn't have option 82;
+// giaddr set by a previous relay agent is kept
+func (relay *RelayAgent) addRelayInfo(req *dhcpv4.DHCPv4) error {
+	req.HopCount++
+	if req.GatewayIPAddr == nil || req.GatewayIPAddr.IsUnspecified() {
+		req.GatewayIPAddr = relay.giAddr
+	}
+	if relay.optionFunc == nil || req.Options.Has(dhcpv4.OptionRelayAgentInformation) {
+		return nil
+	}
+	opts, err := relay.optionFunc(req)
+	if err != nil {
+		return err
+	}
+	for _, o := range opts {
+		req.UpdateOption(o)
+	}
+	return nil
+}
+
+// addXID adds the transaction of req, transactions not finished in xidTimeout are removed
+func (relay *RelayAgent) addXID(req *dhcpv4.DHCPv4) {
+	relay.xidLock.Lock()
+	defer relay.xidLock.Unlock()
+	now := time.Now()
+	if now.Sub(relay.lastPurge) >= xidTimeout {
+		for xid, e := range relay.xids {
+			if now.Sub(e.seen) >= xidTimeout {
+				delete(relay.xids, xid)
+			}
+		}
+		relay.lastPurge = now
+	}
+	relay.xids[req.TransactionID] = xidEntry{chaddr: req.ClientHWAddr, seen: now}
+}
+
+// checkXID checks reply is for an outstanding transaction, the transaction is removed if it is finished by reply
+func (relay *RelayAgent) checkXID(reply *dhcpv4.DHCPv4) error {
+	relay.xidLock.Lock()
+	defer relay.xidLock.Unlock()
+	e, ok := relay.xids[reply.TransactionID]
+	if !ok || time.Since(e.seen) >= xidTimeout {
+		return fmt.Errorf("unknown xid %v", reply.TransactionID)
+	}
+	if !bytes.Equal(e.chaddr, reply.ClientHWAddr) {
+		return fmt.Errorf("chaddr %v doesn't match %v of xid %v", reply.ClientHWAddr, e.chaddr, reply.TransactionID)
+	}
+	switch reply.MessageType() {
+	case dhcpv4.MessageTypeAck, dhcpv4.MessageTypeNak:
+		delete(relay.xids, reply.TransactionID)
+	}
+	return nil
+}
+
+func (relay *RelayAgent) recvNetwork(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		buf := make([]byte, maxDHCPv4Size)
+		n, peerAddr, err := relay.networkConn.ReadFrom(buf)
+		if err != nil {
+			if isTimeout(err) {
+				continue
+			}
+			common.MyLog("failed to receive from network, %v", err)
+			return
+		}
+		reply, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil {
+			common.MyLog("recvd an invalid DHCPv4 msg from svr %v", peerAddr)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		if reply.OpCode != dhcpv4.OpcodeBootReply {
+			common.MyLog("drop a %v msg from svr %v", reply.OpCode, peerAddr)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		if !reply.GatewayIPAddr.Equal(relay.giAddr) {
+			common.MyLog("drop a %v from svr %v, giaddr %v is not %v", reply.MessageType(), peerAddr, reply.GatewayIPAddr, relay.giAddr)
+			relay.stats.mismatches.Add(1)
+			continue
+		}
+		if err = relay.checkXID(reply); err != nil {
+			common.MyLog("drop a %v from svr %v, %v", reply.MessageType(), peerAddr, err)
+			relay.stats.mismatches.Add(1)
+			continue
+		}
+		//option 82 must not be sent to client, RFC3046
+		reply.Options.Del(dhcpv4.OptionRelayAgentInformation)
+		dst := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+		if reply.ClientIPAddr != nil && !reply.ClientIPAddr.IsUnspecified() {
+			dst.IP = reply.ClientIPAddr
+		}
+		common.MyLog("got a reply %v", reply.Summary())
+		if _, err = relay.accessConn.WriteTo(reply.ToBytes(), dst); err != nil {
+			common.MyLog("failed to send %v to %v, %v", reply.MessageType(), dst, err)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		relay.stats.replies.Add(1)
+	}
+}
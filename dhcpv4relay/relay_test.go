@@ -0,0 +1,111 @@
+package dhcpv4relay
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestRelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	accessClnt, accessRelay := conpair.NewPacketConnPair()
+	netRelay, netSvr := conpair.NewPacketConnPair()
+	giaddr := net.ParseIP("192.0.2.1")
+	relay := NewRelayAgent(ctx, accessRelay, netRelay, giaddr,
+		WithOptionFunc(func(req *dhcpv4.DHCPv4) ([]dhcpv4.Option, error) {
+			return []dhcpv4.Option{dhcpv4.OptRelayAgentInfo(
+				dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("port-"+req.ClientHWAddr.String())))}, nil
+		}))
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	discover, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = accessClnt.WriteTo(discover.ToBytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxDHCPv4Size)
+	n, _, err := netSvr.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayed, err := dhcpv4.FromBytes(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !relayed.GatewayIPAddr.Equal(giaddr) || relayed.HopCount != 1 {
+		t.Fatalf("wrong relayed discover %v", relayed.Summary())
+	}
+	rai := relayed.RelayAgentInfo()
+	if rai == nil || string(rai.Get(dhcpv4.AgentCircuitIDSubOption)) != "port-"+mac.String() {
+		t.Fatalf("wrong option 82 %v", rai)
+	}
+	offer, err := dhcpv4.NewReplyFromRequest(relayed, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionRelayAgentInformation, Value: rai})
+	wrongGi, _ := dhcpv4.NewReplyFromRequest(relayed, dhcpv4.WithGatewayIP(net.ParseIP("192.0.2.2")))
+	wrongXID, _ := dhcpv4.NewReplyFromRequest(relayed, dhcpv4.WithTransactionID(dhcpv4.TransactionID{1, 2, 3, 4}))
+	//first one is valid, all the rest are dropped
+	for _, reply := range [][]byte{offer.ToBytes(), wrongGi.ToBytes(), wrongXID.ToBytes(), {1, 2, 3}} {
+		if _, err = netSvr.WriteTo(reply, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	expected := Stats{Forwards: 1, Replies: 1, Mismatches: 2, Drops: 1}
+	if relay.Stats() != expected {
+		t.Fatalf("expect stats %v got %v", expected, relay.Stats())
+	}
+	//the forwarded offer is already queued in accessClnt
+	n, _, err = accessClnt.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dhcpv4.FromBytes(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MessageType() != dhcpv4.MessageTypeOffer || got.Options.Has(dhcpv4.OptionRelayAgentInformation) {
+		t.Fatalf("wrong offer sent to client %v", got.Summary())
+	}
+}
+
+func TestXIDExpiry(t *testing.T) {
+	relay := &RelayAgent{
+		xidLock:   new(sync.Mutex),
+		xids:      make(map[dhcpv4.TransactionID]xidEntry),
+		lastPurge: time.Now(),
+	}
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	old, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.addXID(old)
+	//transaction without ACK or NAK is expired
+	relay.xids[old.TransactionID] = xidEntry{chaddr: mac, seen: time.Now().Add(-xidTimeout)}
+	offer, err := dhcpv4.NewReplyFromRequest(old, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = relay.checkXID(offer); err == nil {
+		t.Fatal("reply of expired transaction should fail")
+	}
+	relay.lastPurge = time.Now().Add(-xidTimeout)
+	req, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.addXID(req)
+	if _, ok := relay.xids[old.TransactionID]; ok || len(relay.xids) != 1 {
+		t.Fatalf("expired transaction is not removed, %v", relay.xids)
+	}
+}
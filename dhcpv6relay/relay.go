@@ -2,8 +2,13 @@
 package dhcpv6relay
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hujun-open/dhcplt/common"
@@ -27,10 +32,12 @@ type DHCPConn interface {
 	ReadFromIP(p []byte) (n int, addr net.IP, err error)
 }
 
-type UDPDHCPConn net.UDPConn
+type UDPDHCPConn struct {
+	*net.UDPConn
+}
 
 func (udpc *UDPDHCPConn) ReadFromIP(p []byte) (int, net.IP, error) {
-	n, udpaddr, err := (*net.UDPConn)(udpc).ReadFromUDP(p)
+	n, udpaddr, err := udpc.UDPConn.ReadFromUDP(p)
 	if udpaddr != nil {
 		return n, udpaddr.IP, err
 	}
@@ -58,23 +65,69 @@ func (pc *PairDHCPConn) ReadFromIP(p []byte) (int, net.IP, error) {
 	return n, net.ParseIP("::"), err
 }
 
+// Hop is a relay agent in a relay chain, between the first relay agent and server
+type Hop struct {
+	LinkAddr net.IP
+	// PeerAddr nil means the link-address of the previous hop
+	PeerAddr net.IP
+	// HopCount negative means hop-count of the previous hop plus 1
+	HopCount int
+	Options  dhcpv6.Options
+}
+
 type RelayAgent struct {
 	accessConn, networkConn DHCPConn
 	accessZone              string
 	options                 dhcpv6.Options
 	linkAddr                net.IP
 	peerAddr                net.IP
-	svrAdddr                *net.UDPAddr
+	svrAddrs                []*net.UDPAddr
+	hops                    []Hop
+	ldra                    bool
+	clientFunc              ClientFunc
+	stats                   relayCounters
+	peerLock                *sync.RWMutex
+	peers                   map[string][]byte //key is peer-address of sent Relay-Forward, value is Interface-ID
+}
+
+// Stats is the counters of a relay agent
+type Stats struct {
+	// Forwards is the number of Relay-Forward sent to network
+	Forwards uint64
+	// Replies is the number of messages in Relay-Reply sent to access
+	Replies uint64
+	// Mismatches is the number of Relay-Reply dropped due to not matching the Relay-Forward
+	Mismatches uint64
+	// Drops is the number of other messages dropped, including invalid messages and send failures
+	Drops uint64
+}
+
+// Add adds counters of s2 to s
+func (s *Stats) Add(s2 Stats) {
+	s.Forwards += s2.Forwards
+	s.Replies += s2.Replies
+	s.Mismatches += s2.Mismatches
+	s.Drops += s2.Drops
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("relay-fwd:%d relay-reply:%d mismatch:%d dropped:%d", s.Forwards, s.Replies, s.Mismatches, s.Drops)
+}
+
+type relayCounters struct {
+	forwards, replies, mismatches, drops atomic.Uint64
 }
 
 func NewRelayAgent(ctx context.Context, access, network DHCPConn, options ...Modifier) *RelayAgent {
 	r := new(RelayAgent)
 	r.accessConn = access
 	r.networkConn = network
-	r.svrAdddr = &net.UDPAddr{
+	r.peerLock = new(sync.RWMutex)
+	r.peers = make(map[string][]byte)
+	r.svrAddrs = []*net.UDPAddr{{
 		IP:   net.ParseIP("ff02::1:2"), //site-scope ALL_DHCP_Servers
 		Port: dhcpv6.DefaultServerPort,
-	}
+	}}
 	for _, o := range options {
 		o(r)
 	}
@@ -87,9 +140,17 @@ type Modifier func(*RelayAgent)
 
 func WithSvrAddr(addr *net.UDPAddr) Modifier {
 	return func(relay *RelayAgent) {
-		relay.svrAdddr = addr
+		relay.svrAddrs = []*net.UDPAddr{addr}
 	}
 }
+
+// WithSvrAddrs makes relay agent send Relay-Forward to each of addrs
+func WithSvrAddrs(addrs []*net.UDPAddr) Modifier {
+	return func(relay *RelayAgent) {
+		relay.svrAddrs = addrs
+	}
+}
+
 func WithLinkAddr(addr net.IP) Modifier {
 	return func(relay *RelayAgent) {
 		relay.linkAddr = addr
@@ -107,10 +168,62 @@ func WithOptions(opts dhcpv6.Options) Modifier {
 	}
 }
 
+// WithLDRA makes the relay agent a Lightweight DHCPv6 Relay Agent (RFC6221),
+// Relay-Forward has link-address "::" and must include Interface-ID option;
+// network conn should use client's link-local address and port 547
+func WithLDRA() Modifier {
+	return func(relay *RelayAgent) {
+		relay.ldra = true
+	}
+}
+
+// ClientFunc returns the link-address and options of Relay-Forward for msg received from peer,
+// nil link-address means the link-address of the relay agent
+type ClientFunc func(msg *dhcpv6.Message, peer net.IP) (linkAddr net.IP, opts dhcpv6.Options, err error)
+
+// WithClientFunc makes the relay agent include per client link-address and options returned by f
+// in addition to the options of WithOptions, msg is dropped if f returns an error
+func WithClientFunc(f ClientFunc) Modifier {
+	return func(relay *RelayAgent) {
+		relay.clientFunc = f
+	}
+}
+
+// WithAccessZone sets the zone of client's link-local address when sending to access
+func WithAccessZone(zone string) Modifier {
+	return func(relay *RelayAgent) {
+		relay.accessZone = zone
+	}
+}
+
+// WithHops adds hops after the relay agent, first hop is closest to the relay agent,
+// so that Relay-Forward is nested len(hops)+1 deep
+func WithHops(hops []Hop) Modifier {
+	return func(relay *RelayAgent) {
+		relay.hops = hops
+	}
+}
+
+// Stats returns the current counters of the relay agent
+func (relay *RelayAgent) Stats() Stats {
+	return Stats{
+		Forwards:   relay.stats.forwards.Load(),
+		Replies:    relay.stats.replies.Load(),
+		Mismatches: relay.stats.mismatches.Load(),
+		Drops:      relay.stats.drops.Load(),
+	}
+}
+
 const (
 	maxDHCPv6Size = 1500
 )
 
+// isTimeout returns true if err is a timeout error
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
 func (relay *RelayAgent) recvAccess(ctx context.Context) {
 	for {
 		select {
@@ -121,43 +234,98 @@ func (relay *RelayAgent) recvAccess(ctx context.Context) {
 		buf := make([]byte, maxDHCPv6Size)
 		n, peerAddr, err := relay.accessConn.ReadFromIP(buf)
 		if err != nil {
-			if !err.(net.Error).Timeout() {
-				common.MyLog("failed to receive from access, %v", err)
-				return
+			if isTimeout(err) {
+				continue
 			}
+			common.MyLog("failed to receive from access, %v", err)
+			return
 		}
 		msg, err := dhcpv6.MessageFromBytes(buf[:n])
 		if err != nil {
 			common.MyLog("recvd an invalid DHCPv6 msg from access with src addr %v", peerAddr)
+			relay.stats.drops.Add(1)
 			continue
 		}
 		duid := msg.Options.ClientID()
 		if duid == nil {
 			common.MyLog("recvd a DHCPv6 msg without client-id from access with src addr %v", peerAddr)
+			relay.stats.drops.Add(1)
 			continue
 		}
 		usePeerAddr := peerAddr
 		if usePeerAddr == nil || usePeerAddr.IsUnspecified() || usePeerAddr.Equal(net.ParseIP("::")) {
 			usePeerAddr = relay.peerAddr
 		}
+		linkAddr := relay.linkAddr
+		var clientOpts dhcpv6.Options
+		if relay.clientFunc != nil {
+			clientLink, opts, err := relay.clientFunc(msg, usePeerAddr)
+			if err != nil {
+				common.MyLog("drop a DHCPv6 msg from access with src addr %v, %v", peerAddr, err)
+				relay.stats.drops.Add(1)
+				continue
+			}
+			if clientLink != nil {
+				linkAddr = clientLink
+			}
+			clientOpts = opts
+		}
+		if relay.ldra {
+			linkAddr = net.IPv6unspecified
+		}
 		relayfwd, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward,
-			relay.linkAddr, usePeerAddr)
+			linkAddr, usePeerAddr)
 		if err != nil {
 			common.MyLog("failed to create relay-fwd msg, %v", err)
+			relay.stats.drops.Add(1)
 			continue
 		}
 		for _, o := range relay.options {
 			relayfwd.AddOption(o)
 		}
+		for _, o := range clientOpts {
+			relayfwd.AddOption(o)
+		}
+		if relay.ldra && relayfwd.Options.InterfaceID() == nil {
+			common.MyLog("drop a DHCPv6 msg from access with src addr %v, LDRA requires Interface-ID", peerAddr)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		iid := relayfwd.Options.InterfaceID()
+		if relayfwd, err = relay.encapsulateHops(relayfwd); err != nil {
+			common.MyLog("failed to create relay-fwd msg, %v", err)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		relay.addPeer(usePeerAddr, iid)
 		common.MyLog("sending relay-fwd,%v", relayfwd.Summary())
-		_, err = relay.networkConn.WriteTo(relayfwd.ToBytes(), relay.svrAdddr)
-		if err != nil {
-			common.MyLog("failed to send relay-fwd msg, abort, %v", err)
-			return
+		for _, svr := range relay.svrAddrs {
+			_, err = relay.networkConn.WriteTo(relayfwd.ToBytes(), svr)
+			if err != nil {
+				common.MyLog("failed to send relay-fwd msg to %v, %v", svr, err)
+				relay.stats.drops.Add(1)
+				continue
+			}
+			relay.stats.forwards.Add(1)
 		}
 	}
 }
 
+// addPeer records a Relay-Forward with peer-address peer and Interface-ID iid has been sent
+func (relay *RelayAgent) addPeer(peer net.IP, iid []byte) {
+	relay.peerLock.Lock()
+	defer relay.peerLock.Unlock()
+	relay.peers[peer.String()] = iid
+}
+
+// forwardedFor returns true and the Interface-ID if a Relay-Forward with peer-address peer has been sent
+func (relay *RelayAgent) forwardedFor(peer net.IP) ([]byte, bool) {
+	relay.peerLock.RLock()
+	defer relay.peerLock.RUnlock()
+	iid, ok := relay.peers[peer.String()]
+	return iid, ok
+}
+
 func (relay *RelayAgent) recvNetwork(ctx context.Context) {
 	for {
 		select {
@@ -168,18 +336,26 @@ func (relay *RelayAgent) recvNetwork(ctx context.Context) {
 		buf := make([]byte, maxDHCPv6Size)
 		n, peerAddr, err := relay.networkConn.ReadFromIP(buf)
 		if err != nil {
-			if !err.(net.Error).Timeout() {
-				common.MyLog("failed to receive from access, %v", err)
-				return
+			if isTimeout(err) {
+				continue
 			}
+			common.MyLog("failed to receive from network, %v", err)
+			return
 		}
 		msg, err := dhcpv6.RelayMessageFromBytes(buf[:n])
 		if err != nil {
 			common.MyLog("recvd an invalid DHCPv6 relay msg from svr %v", peerAddr)
+			relay.stats.drops.Add(1)
 			continue
 		}
 		if msg.MessageType != dhcpv6.MessageTypeRelayReply {
-			common.MyLog("drop an %v msg from svr %v", msg.MessageType)
+			common.MyLog("drop an %v msg from svr %v", msg.MessageType, peerAddr)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		if msg, err = relay.validateReply(msg); err != nil {
+			common.MyLog("drop a relay-reply from svr %v, %v", peerAddr, err)
+			relay.stats.mismatches.Add(1)
 			continue
 		}
 		common.MyLog("got a relay-reply %v", msg.Summary())
@@ -190,8 +366,126 @@ func (relay *RelayAgent) recvNetwork(ctx context.Context) {
 				Zone: relay.accessZone,
 			})
 		if err != nil {
-			common.MyLog("failed to send relay-fwd msg, abort, %v", err)
-			return
+			common.MyLog("failed to send relay-reply msg to %v, %v", msg.PeerAddr, err)
+			relay.stats.drops.Add(1)
+			continue
+		}
+		relay.stats.replies.Add(1)
+	}
+}
+
+// encapsulateHops encapsulates relayfwd of the relay agent level by level with each hop
+func (relay *RelayAgent) encapsulateHops(relayfwd *dhcpv6.RelayMessage) (*dhcpv6.RelayMessage, error) {
+	return EncapsulateHops(relayfwd, relay.hops)
+}
+
+// EncapsulateHops encapsulates relayfwd level by level with each of hops, first hop is closest to relayfwd,
+// the same as the Relay-Forward of a RelayAgent with WithHops(hops)
+func EncapsulateHops(relayfwd *dhcpv6.RelayMessage, hops []Hop) (*dhcpv6.RelayMessage, error) {
+	for _, h := range hops {
+		peer := h.PeerAddr
+		if peer == nil {
+			peer = relayfwd.LinkAddr
+		}
+		outer, err := dhcpv6.EncapsulateRelay(relayfwd, dhcpv6.MessageTypeRelayForward, h.LinkAddr, peer)
+		if err != nil {
+			return nil, err
+		}
+		if h.HopCount >= 0 {
+			outer.HopCount = uint8(h.HopCount)
+		}
+		for _, o := range h.Options {
+			outer.AddOption(o)
+		}
+		relayfwd = outer
+	}
+	return relayfwd, nil
+}
+
+// relayLevel is the expected fields of one level of Relay-Reply,
+// which are copied by server from the corresponding Relay-Forward
+type relayLevel struct {
+	hopCount uint8
+	peerAddr net.IP //nil means any peer-address the relay agent has forwarded for, with the Interface-ID sent for it
+	iid      []byte //nil means no Interface-ID is sent
+}
+
+// interfaceID returns value of Interface-ID option in opts, nil if there is no such option
+func interfaceID(opts dhcpv6.Options) []byte {
+	if op := opts.GetOne(dhcpv6.OptionInterfaceID); op != nil {
+		return op.ToBytes()
+	}
+	return nil
+}
+
+// levels returns expected fields of each level, first one is the relay agent, followed by each hop
+func (relay *RelayAgent) levels() []relayLevel {
+	link := relay.linkAddr
+	if link == nil || relay.ldra {
+		link = net.IPv6unspecified
+	}
+	r := []relayLevel{{}}
+	hopCount := 0
+	for _, h := range relay.hops {
+		hopCount++
+		if h.HopCount >= 0 {
+			hopCount = h.HopCount
+		}
+		peer := h.PeerAddr
+		if peer == nil {
+			peer = link
+		}
+		r = append(r, relayLevel{hopCount: uint8(hopCount), peerAddr: peer, iid: interfaceID(h.Options)})
+		link = h.LinkAddr
+	}
+	return r
+}
+
+// validateReply unwraps relay-reply msg level by level from the outermost hop,
+// checks each level matches what the relay agent sends, and the innermost message is a server message;
+// returns the relay-reply of the relay agent
+func (relay *RelayAgent) validateReply(msg *dhcpv6.RelayMessage) (*dhcpv6.RelayMessage, error) {
+	levels := relay.levels()
+	for i := len(levels) - 1; i >= 0; i-- {
+		if msg.MessageType != dhcpv6.MessageTypeRelayReply {
+			return nil, fmt.Errorf("relay message of level %d is %v", i, msg.MessageType)
+		}
+		expected := levels[i]
+		if msg.HopCount != expected.hopCount {
+			return nil, fmt.Errorf("hop-count of level %d is %d, expect %d", i, msg.HopCount, expected.hopCount)
+		}
+		if expected.peerAddr != nil && !msg.PeerAddr.Equal(expected.peerAddr) {
+			return nil, fmt.Errorf("peer-address of level %d is %v, expect %v", i, msg.PeerAddr, expected.peerAddr)
+		}
+		if expected.peerAddr == nil {
+			iid, ok := relay.forwardedFor(msg.PeerAddr)
+			if !ok {
+				return nil, fmt.Errorf("peer-address %v of level %d is unknown", msg.PeerAddr, i)
+			}
+			expected.iid = iid
+		}
+		if expected.iid != nil && !bytes.Equal(msg.Options.InterfaceID(), expected.iid) {
+			return nil, fmt.Errorf("interface-id of level %d is %q, expect %q", i, msg.Options.InterfaceID(), expected.iid)
+		}
+		if i == 0 {
+			break
+		}
+		inner, ok := msg.Options.RelayMessage().(*dhcpv6.RelayMessage)
+		if !ok {
+			return nil, fmt.Errorf("relay message of level %d is not a relay message", i-1)
+		}
+		msg = inner
+	}
+	switch inner := msg.Options.RelayMessage().(type) {
+	case nil:
+		return nil, fmt.Errorf("no relay message")
+	case *dhcpv6.Message:
+		switch inner.MessageType {
+		case dhcpv6.MessageTypeAdvertise, dhcpv6.MessageTypeReply, dhcpv6.MessageTypeReconfigure,
+			dhcpv6.MessageTypeDHCPv4Response:
+			return msg, nil
 		}
+		return nil, fmt.Errorf("relayed message is %v", inner.MessageType)
 	}
+	return nil, fmt.Errorf("relayed message is a relay message")
 }
@@ -0,0 +1,228 @@
+package dhcpv6relay
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestHops(t *testing.T) {
+	relay := &RelayAgent{
+		peerLock: new(sync.RWMutex),
+		peers:    make(map[string][]byte),
+		linkAddr: net.IPv6unspecified,
+		peerAddr: net.ParseIP("fe80::1"),
+		hops: []Hop{
+			{LinkAddr: net.ParseIP("2001:db8::1"), HopCount: -1, Options: dhcpv6.Options{dhcpv6.OptInterfaceID([]byte("agg1"))}},
+			{LinkAddr: net.ParseIP("2001:db8:1::1"), PeerAddr: net.ParseIP("2001:db8::2"), HopCount: 5},
+		},
+	}
+	solicit, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayfwd, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, relay.linkAddr, relay.peerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer, err := relay.encapsulateHops(relayfwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outer.HopCount != 5 || !outer.PeerAddr.Equal(net.ParseIP("2001:db8::2")) {
+		t.Fatalf("wrong outer hop %v", outer.Summary())
+	}
+	mid := outer.Options.RelayMessage().(*dhcpv6.RelayMessage)
+	if mid.HopCount != 1 || !mid.PeerAddr.Equal(net.IPv6unspecified) || mid.Options.InterfaceID() == nil {
+		t.Fatalf("wrong middle hop %v", mid.Summary())
+	}
+	//relay-reply from server
+	reply, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.MessageType = dhcpv6.MessageTypeReply
+	relay.addPeer(relay.peerAddr, nil)
+	first, err := relay.validateReply(serverReply(outer, reply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.PeerAddr.Equal(relay.peerAddr) {
+		t.Fatalf("wrong first hop relay-reply %v", first.Summary())
+	}
+	if _, err = relay.validateReply(serverReply(mid, reply)); err == nil {
+		t.Fatal("relay-reply with less hops should fail")
+	}
+}
+
+// serverReply returns the Relay-Reply a server sends for relayfwd with msg
+func serverReply(relayfwd *dhcpv6.RelayMessage, msg dhcpv6.DHCPv6) *dhcpv6.RelayMessage {
+	if inner, ok := relayfwd.Options.RelayMessage().(*dhcpv6.RelayMessage); ok {
+		msg = serverReply(inner, msg)
+	}
+	r := &dhcpv6.RelayMessage{
+		MessageType: dhcpv6.MessageTypeRelayReply,
+		HopCount:    relayfwd.HopCount,
+		LinkAddr:    relayfwd.LinkAddr,
+		PeerAddr:    relayfwd.PeerAddr,
+	}
+	r.Options.Add(dhcpv6.OptRelayMessage(msg))
+	if iid := relayfwd.Options.InterfaceID(); iid != nil {
+		r.Options.Add(dhcpv6.OptInterfaceID(iid))
+	}
+	return r
+}
+
+// waitHandled waits until relay handles one more message from network than before, and returns the stats
+func waitHandled(t *testing.T, relay *RelayAgent, before Stats) Stats {
+	t.Helper()
+	handled := func(s Stats) uint64 { return s.Replies + s.Mismatches + s.Drops }
+	deadline := time.Now().Add(time.Second)
+	for {
+		s := relay.Stats()
+		if handled(s) > handled(before) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("message from network is not handled, stats %v", s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestValidateReply(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	accessClnt, accessRelay := conpair.NewPacketConnPair()
+	netRelay, netSvr := conpair.NewPacketConnPair()
+	relay := NewRelayAgent(ctx, &PairDHCPConn{PacketConnPair: accessRelay}, &PairDHCPConn{PacketConnPair: netRelay},
+		WithLinkAddr(net.ParseIP("2001:db8::1")),
+		WithPeerAddr(net.ParseIP("fe80::1")),
+		WithOptions(dhcpv6.Options{dhcpv6.OptInterfaceID([]byte("port1"))}),
+		WithHops([]Hop{{LinkAddr: net.ParseIP("2001:db8:1::1"), HopCount: -1}}))
+	solicit, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	solicit.AddOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}}))
+	if _, err = accessClnt.WriteTo(solicit.ToBytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+	netSvr.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxDHCPv6Size)
+	n, _, err := netSvr.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayfwd, err := dhcpv6.RelayMessageFromBytes(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	advertise, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	advertise.MessageType = dhcpv6.MessageTypeAdvertise
+	dhcp4o6 := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeDHCPv4Response}
+	inner := func(r *dhcpv6.RelayMessage) *dhcpv6.RelayMessage {
+		return r.Options.RelayMessage().(*dhcpv6.RelayMessage)
+	}
+	//first two are valid, all the rest are dropped as mismatch
+	for i, c := range []struct {
+		change  func(r *dhcpv6.RelayMessage)
+		msg     dhcpv6.DHCPv6
+		forward bool
+	}{
+		{change: func(r *dhcpv6.RelayMessage) {}, msg: advertise, forward: true},
+		{change: func(r *dhcpv6.RelayMessage) {}, msg: dhcp4o6, forward: true},
+		{change: func(r *dhcpv6.RelayMessage) { inner(r).PeerAddr = net.ParseIP("fe80::2") }, msg: advertise},
+		{change: func(r *dhcpv6.RelayMessage) { r.HopCount = 3 }, msg: advertise},
+		{change: func(r *dhcpv6.RelayMessage) { r.PeerAddr = net.ParseIP("2001:db8::2") }, msg: advertise},
+		{change: func(r *dhcpv6.RelayMessage) { inner(r).Options.Del(dhcpv6.OptionInterfaceID) }, msg: advertise},
+		{change: func(r *dhcpv6.RelayMessage) {
+			inner(r).Options.Update(dhcpv6.OptInterfaceID([]byte("port2")))
+		}, msg: advertise},
+		{change: func(r *dhcpv6.RelayMessage) {}, msg: solicit},
+	} {
+		relayrep := serverReply(relayfwd, c.msg)
+		c.change(relayrep)
+		before := relay.Stats()
+		if _, err = netSvr.WriteTo(relayrep.ToBytes(), nil); err != nil {
+			t.Fatal(err)
+		}
+		if forwarded := waitHandled(t, relay, before).Replies > before.Replies; c.forward != forwarded {
+			t.Fatalf("case %d: expect forward %v", i, c.forward)
+		}
+	}
+	//an invalid message
+	before := relay.Stats()
+	if _, err = netSvr.WriteTo([]byte{1, 2, 3}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := Stats{Forwards: 1, Replies: 2, Mismatches: 6, Drops: 1}
+	if stats := waitHandled(t, relay, before); stats != expected {
+		t.Fatalf("expect stats %v got %v", expected, stats)
+	}
+	//the forwarded advertise is already queued in accessClnt
+	n, _, err = accessClnt.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := dhcpv6.MessageFromBytes(buf[:n]); err != nil || msg.MessageType != dhcpv6.MessageTypeAdvertise {
+		t.Fatalf("expect an advertise, got %v, %v", msg, err)
+	}
+}
+
+func TestLDRA(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, c := range []struct {
+		opts    dhcpv6.Options
+		forward bool
+	}{
+		{dhcpv6.Options{}, false},
+		{dhcpv6.Options{dhcpv6.OptInterfaceID([]byte("port1"))}, true},
+	} {
+		accessClnt, accessRelay := conpair.NewPacketConnPair()
+		netRelay, netSvr := conpair.NewPacketConnPair()
+		NewRelayAgent(ctx, &PairDHCPConn{PacketConnPair: accessRelay}, &PairDHCPConn{PacketConnPair: netRelay},
+			WithLDRA(),
+			WithLinkAddr(net.ParseIP("2001:db8::1")),
+			WithPeerAddr(net.ParseIP("fe80::1")),
+			WithOptions(c.opts))
+		solicit, err := dhcpv6.NewMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		solicit.AddOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}}))
+		if _, err = accessClnt.WriteTo(solicit.ToBytes(), nil); err != nil {
+			t.Fatal(err)
+		}
+		netSvr.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		buf := make([]byte, maxDHCPv6Size)
+		n, _, err := netSvr.ReadFrom(buf)
+		if !c.forward {
+			if err == nil {
+				t.Fatal("relay-fwd without Interface-ID should be dropped")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		relayfwd, err := dhcpv6.RelayMessageFromBytes(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !relayfwd.LinkAddr.Equal(net.IPv6unspecified) || !relayfwd.PeerAddr.Equal(net.ParseIP("fe80::1")) ||
+			relayfwd.HopCount != 0 || relayfwd.Options.InterfaceID() == nil {
+			t.Fatalf("wrong LDRA relay-fwd %v", relayfwd.Summary())
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// duid
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+type duidType int
+
+const (
+	duidAuto duidType = iota
+	duidLLT
+	duidLL
+	duidEN
+	duidUUID
+)
+
+var duidTypeNames = map[duidType]string{
+	duidAuto: "auto",
+	duidLLT:  "llt",
+	duidLL:   "ll",
+	duidEN:   "en",
+	duidUUID: "uuid",
+}
+
+func (dt duidType) String() string {
+	buf, err := dt.MarshalText()
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+func (dt duidType) MarshalText() (text []byte, err error) {
+	if s, ok := duidTypeNames[dt]; ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unknown DUID type %d", dt)
+}
+
+func (dt *duidType) UnmarshalText(text []byte) error {
+	for t, name := range duidTypeNames {
+		if name == strings.ToLower(string(text)) {
+			*dt = t
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown DUID type %s", text)
+}
+
+// genDUID returns the DUID of a client with mac, content is the expanded clntid template:
+//   - auto: DUID-EN with content if it is not empty, otherwise DUID-LLT
+//   - llt, ll: link-layer address is content parsed as a MAC address, or mac if content is empty
+//   - en: identifier is content, or mac if content is empty
+//   - uuid: content is a UUID, or an UUID derived from mac if content is empty
+func (setup *testSetup) genDUID(content string, mac net.HardwareAddr) (dhcpv6.DUID, error) {
+	dt := setup.DUIDType
+	if dt == duidAuto {
+		dt = duidLLT
+		if content != "" {
+			dt = duidEN
+		}
+	}
+	switch dt {
+	case duidLLT, duidLL:
+		lladdr := mac
+		if content != "" {
+			var err error
+			if lladdr, err = net.ParseMAC(content); err != nil {
+				return nil, fmt.Errorf("%v is not a valid link-layer address for DUID-%v", content, strings.ToUpper(dt.String()))
+			}
+		}
+		if dt == duidLL {
+			return &dhcpv6.DUIDLL{
+				HWType:        iana.HWTypeEthernet,
+				LinkLayerAddr: lladdr,
+			}, nil
+		}
+		return &dhcpv6.DUIDLLT{
+			HWType:        iana.HWTypeEthernet,
+			Time:          setup.DUIDTime,
+			LinkLayerAddr: lladdr,
+		}, nil
+	case duidEN:
+		id := []byte(content)
+		if content == "" {
+			id = mac
+		}
+		return &dhcpv6.DUIDEN{
+			EnterpriseNumber:     setup.DUIDEnterprise,
+			EnterpriseIdentifier: id,
+		}, nil
+	case duidUUID:
+		r := &dhcpv6.DUIDUUID{}
+		if content == "" {
+			//name based UUID, RFC 4122 version 3
+			r.UUID = md5.Sum(mac)
+			r.UUID[6] = r.UUID[6]&0x0f | 0x30
+			r.UUID[8] = r.UUID[8]&0x3f | 0x80
+			return r, nil
+		}
+		buf, err := hex.DecodeString(strings.ReplaceAll(content, "-", ""))
+		if err != nil || len(buf) != len(r.UUID) {
+			return nil, fmt.Errorf("%v is not a valid UUID", content)
+		}
+		copy(r.UUID[:], buf)
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported DUID type %v", dt)
+}
+
+type iaidScheme int
+
+const (
+	iaidFixed iaidScheme = iota
+	iaidIndex
+	iaidMAC
+)
+
+var iaidSchemeNames = map[iaidScheme]string{
+	iaidFixed: "fixed",
+	iaidIndex: "index",
+	iaidMAC:   "mac",
+}
+
+func (is iaidScheme) String() string {
+	buf, err := is.MarshalText()
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+func (is iaidScheme) MarshalText() (text []byte, err error) {
+	if s, ok := iaidSchemeNames[is]; ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unknown IAID scheme %d", is)
+}
+
+func (is *iaidScheme) UnmarshalText(text []byte) error {
+	for s, name := range iaidSchemeNames {
+		if name == strings.ToLower(string(text)) {
+			*is = s
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown IAID scheme %s", text)
+}
+
+// iaid returns IAID of the n-th IA_NA or IA_PD of the client with vars:
+//   - fixed: IA_NA uses n, IA_PD uses n+1
+//   - index: client index plus n
+//   - mac: last 4 bytes of client MAC plus n
+func (is iaidScheme) iaid(vars templateVars, isPD bool, n int) [4]byte {
+	var base uint32
+	switch is {
+	case iaidFixed:
+		if isPD {
+			base = 1
+		}
+	case iaidIndex:
+		base = uint32(vars.ID)
+	case iaidMAC:
+		if len(vars.MAC) >= 4 {
+			base = binary.BigEndian.Uint32(vars.MAC[len(vars.MAC)-4:])
+		}
+	}
+	return getIAIDviaInt(base + uint32(n))
+}
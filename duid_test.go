@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestGenDUID(t *testing.T) {
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0, 0, 1}
+	testList := []struct {
+		duidType   string
+		content    string
+		expected   []byte
+		shouldFail bool
+	}{
+		//case 0, auto without clntid is LLT
+		{
+			duidType: "auto",
+			expected: []byte{0, 1, 0, 1, 0, 0, 0, 100, 0xaa, 0xbb, 0xcc, 0, 0, 1},
+		},
+		//case 1, auto with clntid is EN
+		{
+			duidType: "auto",
+			content:  "ab",
+			expected: []byte{0, 2, 0, 0, 0x0d, 0xe9, 'a', 'b'},
+		},
+		//case 2
+		{
+			duidType: "ll",
+			content:  "11:22:33:44:55:66",
+			expected: []byte{0, 3, 0, 1, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+		},
+		//case 3
+		{
+			duidType: "uuid",
+			content:  "00112233-4455-6677-8899-aabbccddeeff",
+			expected: []byte{0, 4, 0, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		},
+		//case 4
+		{
+			duidType:   "llt",
+			content:    "not-a-mac",
+			shouldFail: true,
+		},
+		//case 5
+		{
+			duidType:   "uuid",
+			content:    "0011",
+			shouldFail: true,
+		},
+	}
+	for i, c := range testList {
+		setup := newDefaultConf()
+		setup.DUIDTime = 100
+		if err := setup.DUIDType.UnmarshalText([]byte(c.duidType)); err != nil {
+			t.Fatalf("case %d failed, %v", i, err)
+		}
+		duid, err := setup.genDUID(c.content, mac)
+		if err != nil {
+			if c.shouldFail {
+				t.Logf("case %d failed as expected, %v", i, err)
+				continue
+			}
+			t.Fatalf("case %d failed, %v", i, err)
+		}
+		if c.shouldFail {
+			t.Fatalf("case %d succeed but should fail", i)
+		}
+		if !bytes.Equal(duid.ToBytes(), c.expected) {
+			t.Fatalf("case %d expect %v but got %v", i, c.expected, duid.ToBytes())
+		}
+	}
+	//stable derived UUID
+	setup := newDefaultConf()
+	setup.DUIDType = duidUUID
+	d1, _ := setup.genDUID("", mac)
+	d2, _ := setup.genDUID("", mac)
+	if !d1.Equal(d2) {
+		t.Fatalf("derived UUID DUID is not stable, %v, %v", d1, d2)
+	}
+}
+
+func TestIAID(t *testing.T) {
+	vars := templateVars{ID: 5, MAC: net.HardwareAddr{0xaa, 0xbb, 0, 0, 1, 2}}
+	if r := iaidFixed.iaid(vars, true, 0); r != [4]byte{0, 0, 0, 1} {
+		t.Fatalf("wrong fixed PD IAID %v", r)
+	}
+	if r := iaidIndex.iaid(vars, false, 1); r != [4]byte{0, 0, 0, 6} {
+		t.Fatalf("wrong index IAID %v", r)
+	}
+	if r := iaidMAC.iaid(vars, false, 0); r != [4]byte{0, 0, 1, 2} {
+		t.Fatalf("wrong mac IAID %v", r)
+	}
+}
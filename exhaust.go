@@ -0,0 +1,168 @@
+// exhaust
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+)
+
+// exhaustStack is the pool exhaustion state of one stack
+type exhaustStack struct {
+	enabled   bool
+	tried     int            //number of clients dialed
+	capacity  int            //number of clients got a lease
+	fails     int            //number of consecutive failed clients
+	behaviors map[string]int //server behaviors of the consecutive failures
+}
+
+func newExhaustStack(enabled bool) *exhaustStack {
+	return &exhaustStack{
+		enabled:   enabled,
+		behaviors: make(map[string]int),
+	}
+}
+
+// active returns true if the stack is not exhausted yet
+func (es *exhaustStack) active(limit int) bool {
+	return es.enabled && es.fails < limit
+}
+
+// add records the dial result of a client, behavior is the server behavior if err is not nil
+func (es *exhaustStack) add(err error, behavior string) {
+	es.tried++
+	if err == nil {
+		es.capacity++
+		es.fails = 0
+		es.behaviors = make(map[string]int)
+		return
+	}
+	es.fails++
+	es.behaviors[behavior]++
+}
+
+// v4ExhaustBehavior classifies the server behavior from the error of a failed DORA
+func v4ExhaustBehavior(err error) string {
+	var nak *nclient4.ErrNak
+	switch {
+	case errors.As(err, &nak):
+		return "NAK"
+	case errors.Is(err, nclient4.ErrNoResponse):
+		return "silence"
+	}
+	return "other"
+}
+
+// v6ExhaustBehavior classifies the server behavior from the error of a failed DHCPv6 dial,
+// the status code name is returned if the server rejects with a status code
+func v6ExhaustBehavior(err error) string {
+	var se *v6StatusError
+	switch {
+	case errors.As(err, &se):
+		return se.status.StatusCode.String()
+	case errors.Is(err, nclient6.ErrNoResponse):
+		return "silence"
+	}
+	return "other"
+}
+
+// exhaustReport is the pool exhaustion result of a group
+type exhaustReport struct {
+	group string
+	v4    *exhaustStack
+	v6    *exhaustStack
+	limit int
+}
+
+func (er *exhaustReport) String() string {
+	r := "Pool Exhaustion"
+	if er.group != "" {
+		r += " of group " + er.group
+	}
+	r += ":\n"
+	for _, s := range []struct {
+		name  string
+		stack *exhaustStack
+	}{
+		{"DHCPv4", er.v4},
+		{"DHCPv6", er.v6},
+	} {
+		if !s.stack.enabled {
+			continue
+		}
+		r += fmt.Sprintf("%v capacity:%d\n", s.name, s.stack.capacity)
+		r += fmt.Sprintf("%v clients tried:%d\n", s.name, s.stack.tried)
+		if s.stack.fails < er.limit {
+			r += fmt.Sprintf("%v server behavior at exhaustion:not exhausted\n", s.name)
+			continue
+		}
+		behaviors := []string{}
+		for b, n := range s.stack.behaviors {
+			behaviors = append(behaviors, fmt.Sprintf("%v(%d)", b, n))
+		}
+		sort.Strings(behaviors)
+		r += fmt.Sprintf("%v server behavior at exhaustion:%v\n", s.name, behaviors)
+	}
+	return r
+}
+
+// exhaust keeps dialing clients of g, existing clients first then new generated clients,
+// until each enabled stack fails exhaustfails consecutive clients or ctx is done
+func (sch *Sched) exhaust(ctx context.Context, g *clientGroup) *exhaustReport {
+	r := &exhaustReport{
+		group: g.setup.groupName,
+		v4:    newExhaustStack(g.setup.EnableV4),
+		v6:    newExhaustStack(g.setup.EnableV6),
+		limit: int(g.setup.ExhaustFails),
+	}
+	for i := 0; r.v4.active(r.limit) || r.v6.active(r.limit); i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		var dc *DClient
+		if i < len(g.clients) {
+			dc = g.clients[i]
+		} else {
+			var err error
+			if dc, err = sch.newGroupClient(g); err != nil {
+				common.MyLog("pool exhaustion of group %v stopped, %v", g.setup.groupName, err)
+				break
+			}
+			g.clients = append(g.clients, dc)
+		}
+		wg := new(sync.WaitGroup)
+		if dc.d4 != nil && r.v4.active(r.limit) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := dc.dialv4(nil)
+				if err != nil {
+					common.MyLog("failed to dial DHCPv4, %v", err)
+				}
+				r.v4.add(err, v4ExhaustBehavior(err))
+			}()
+		}
+		if dc.d6 != nil && r.v6.active(r.limit) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := dc.dialv6(nil)
+				if err != nil {
+					common.MyLog("failed to dial DHCPv6, %v", err)
+				}
+				r.v6.add(err, v6ExhaustBehavior(err))
+			}()
+		}
+		wg.Wait()
+		if !sleepCtx(ctx, g.setup.Interval) {
+			break
+		}
+	}
+	return r
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestExhaust(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		v6       bool
+		behavior string
+	}{
+		{fmt.Errorf("failed complete DORA, %w", &nclient4.ErrNak{}), false, "NAK"},
+		{fmt.Errorf("unable to receive an offer: %w", nclient4.ErrNoResponse), false, "silence"},
+		{fmt.Errorf("failed recv DHCPv6 advertisement, %w", nclient6.ErrNoResponse), true, "silence"},
+		{fmt.Errorf("got invalid advertise msg, %w",
+			&v6StatusError{status: &dhcpv6.OptStatusCode{StatusCode: iana.StatusNoAddrsAvail}}), true, "NoAddrsAvail"},
+		{fmt.Errorf("unknown"), true, "other"},
+	} {
+		b := v4ExhaustBehavior(c.err)
+		if c.v6 {
+			b = v6ExhaustBehavior(c.err)
+		}
+		if b != c.behavior {
+			t.Fatalf("expect behavior %v for %v, got %v", c.behavior, c.err, b)
+		}
+	}
+	r := &exhaustReport{v4: newExhaustStack(true), v6: newExhaustStack(false), limit: 2}
+	r.v4.add(nil, "")
+	r.v4.add(fmt.Errorf("nak"), "NAK")
+	r.v4.add(nil, "")
+	if !r.v4.active(r.limit) || r.v6.active(r.limit) {
+		t.Fatal("v4 should be active and v6 should not")
+	}
+	r.v4.add(fmt.Errorf("nak"), "NAK")
+	r.v4.add(fmt.Errorf("timeout"), "silence")
+	if r.v4.active(r.limit) {
+		t.Fatal("v4 should be exhausted")
+	}
+	if r.v4.capacity != 2 || r.v4.tried != 5 {
+		t.Fatalf("wrong capacity %d or tried %d", r.v4.capacity, r.v4.tried)
+	}
+	if s := r.String(); !strings.Contains(s, "[NAK(1) silence(1)]") || strings.Contains(s, "DHCPv6") {
+		t.Fatalf("wrong report:\n%v", s)
+	}
+}
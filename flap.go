@@ -0,0 +1,200 @@
+// flap
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+type FlappingConf struct {
+	FlapNum     int           `alias:"flapnum" usage:"number of client flapping"`
+	MinInterval time.Duration `alias:"flapmininterval" usage:"minimal flapping interval"`
+	MaxInterval time.Duration `alias:"flapmaxinterval" usage:"max flapping interval"`
+	StayDownDur time.Duration `alias:"flapstaydowndur" usage:"duriation of stay down"`
+	Renew       bool          `alias:"flaprenew" usage:"renew the lease instead of release and re-dial when flapping if true"`
+}
+
+const (
+	defaultMinFlapInt   = 5 * time.Second
+	defualtMaxFlapInt   = 30 * time.Second
+	defaultFlapStayDown = 10 * time.Second
+)
+
+// randDuration returns a random duration between min and max, inclusive
+func randDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(rand.Int63n(int64(max-min)+1))
+}
+
+// sleepCtx sleeps for d, return false if ctx is done before that
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// flapStats is the statistics of flap cycles of one stack
+type flapStats struct {
+	lock      *sync.Mutex
+	Cycles    int
+	Failed    int
+	TotalTime time.Duration //total duration of successful cycles
+}
+
+func (fs *flapStats) add(err error, d time.Duration) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.Cycles++
+	if err != nil {
+		fs.Failed++
+		return
+	}
+	fs.TotalTime += d
+}
+
+// report returns the stats of stack name, empty if there is no cycle yet
+func (fs *flapStats) report(name string) string {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if fs.Cycles == 0 {
+		return ""
+	}
+	avg := time.Duration(0)
+	if fs.Cycles > fs.Failed {
+		avg = fs.TotalTime / time.Duration(fs.Cycles-fs.Failed)
+	}
+	r := fmt.Sprintf("%v flap cycles:%d\n", name, fs.Cycles)
+	r += fmt.Sprintf("%v failed flap cycles:%d\n", name, fs.Failed)
+	r += fmt.Sprintf("%v avg flap cycle time:%v\n", name, avg)
+	return r
+}
+
+// flapper makes the selected clients flap, v4 and v6 of a client flap independently,
+// each flap cycle of a stack is: stay up for a random duration between min and max interval,
+// then release, stay down and re-dial; or just renew the lease if renew flap is enabled
+type flapper struct {
+	clients []*DClient
+	v4Stats *flapStats
+	v6Stats *flapStats
+}
+
+// newFlapper selects exactly flapnum random clients from each group
+func newFlapper(groups []*clientGroup) *flapper {
+	r := &flapper{
+		v4Stats: &flapStats{lock: new(sync.Mutex)},
+		v6Stats: &flapStats{lock: new(sync.Mutex)},
+	}
+	for _, g := range groups {
+		if g.setup.Flapping == nil || g.setup.Flapping.FlapNum <= 0 {
+			continue
+		}
+		for i, idx := range rand.Perm(len(g.clients)) {
+			if i >= g.setup.Flapping.FlapNum {
+				break
+			}
+			r.clients = append(r.clients, g.clients[idx])
+		}
+	}
+	return r
+}
+
+// run makes all selected clients flap until ctx is done
+func (f *flapper) run(ctx context.Context) {
+	wg := new(sync.WaitGroup)
+	for _, dc := range f.clients {
+		if dc.d4 != nil {
+			wg.Add(1)
+			go f.flapStack(ctx, wg, dc, false)
+		}
+		if dc.d6 != nil {
+			wg.Add(1)
+			go f.flapStack(ctx, wg, dc, true)
+		}
+	}
+	wg.Wait()
+}
+
+func (f *flapper) flapStack(ctx context.Context, wg *sync.WaitGroup, dc *DClient, isV6 bool) {
+	defer wg.Done()
+	conf := dc.cfg.setup.Flapping
+	stats := f.v4Stats
+	if isV6 {
+		stats = f.v6Stats
+	}
+	for {
+		if !sleepCtx(ctx, randDuration(conf.MinInterval, conf.MaxInterval)) {
+			return
+		}
+		start := time.Now()
+		err := f.cycle(ctx, dc, isV6)
+		if ctx.Err() != nil {
+			//interrupted cycle is not counted
+			return
+		}
+		if err != nil {
+			common.MyLog("flap cycle of %v failed, %v", dc.id, err)
+		}
+		stats.add(err, time.Since(start))
+	}
+}
+
+// cycle does one flap of a stack of dc
+func (f *flapper) cycle(ctx context.Context, dc *DClient, isV6 bool) error {
+	conf := dc.cfg.setup.Flapping
+	var release, renew, dial func() error
+	hasLease := false
+	if isV6 {
+		hasLease = dc.d6Lease != nil
+		release = func() error {
+			if err := dc.releaseOrRenewV6(nil, dhcpv6.MessageTypeRelease); err != nil {
+				return err
+			}
+			dc.d6Lease = nil
+			return nil
+		}
+		renew = func() error { return dc.releaseOrRenewV6(nil, dhcpv6.MessageTypeRenew) }
+		dial = func() error { return dc.dialv6(nil) }
+	} else {
+		hasLease = dc.d4Lease != nil
+		release = func() error {
+			if err := dc.releasev4(nil); err != nil {
+				return err
+			}
+			dc.d4Lease = nil
+			return nil
+		}
+		renew = func() error { return dc.renewOrRebindv4(ctx, nil, actionRenew) }
+		dial = func() error { return dc.dialv4(nil) }
+	}
+	if !hasLease {
+		//previous dial failed, nothing to release or renew
+		return dial()
+	}
+	if conf.Renew {
+		return renew()
+	}
+	if err := release(); err != nil {
+		return err
+	}
+	if !sleepCtx(ctx, conf.StayDownDur) {
+		return ctx.Err()
+	}
+	return dial()
+}
+
+func (f *flapper) String() string {
+	return f.v4Stats.report("DHCPv4") + f.v6Stats.report("DHCPv6")
+}
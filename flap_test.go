@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlap(t *testing.T) {
+	if d := randDuration(time.Second, time.Second); d != time.Second {
+		t.Fatalf("min==max should return min, got %v", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := randDuration(time.Second, 2*time.Second); d < time.Second || d > 2*time.Second {
+			t.Fatalf("%v is out of range", d)
+		}
+	}
+	g := &clientGroup{setup: newDefaultConf()}
+	g.setup.Flapping.FlapNum = 3
+	for i := 0; i < 10; i++ {
+		g.clients = append(g.clients, new(DClient))
+	}
+	f := newFlapper([]*clientGroup{g, {setup: newDefaultConf(), clients: []*DClient{new(DClient)}}})
+	if len(f.clients) != 3 {
+		t.Fatalf("expect 3 flapping clients, got %d", len(f.clients))
+	}
+	seen := make(map[*DClient]bool)
+	for _, c := range f.clients {
+		if seen[c] {
+			t.Fatal("client is selected twice")
+		}
+		seen[c] = true
+	}
+}
@@ -0,0 +1,173 @@
+// forcerenew
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+const (
+	// messageTypeForceRenew is DHCPFORCERENEW, RFC3203
+	messageTypeForceRenew dhcpv4.MessageType = 9
+	// optionForceRenewNonceCapable is FORCERENEW_NONCE_CAPABLE option, RFC6704
+	optionForceRenewNonceCapable = dhcpv4.GenericOptionCode(145)
+)
+
+// forceRenewStats counts DHCPv4 FORCERENEW events of all clients
+type forceRenewStats struct {
+	Received atomic.Uint64
+	Invalid  atomic.Uint64 //dropped due to no lease, mismatched ids or failed authentication
+	Renewed  atomic.Uint64
+	Failed   atomic.Uint64 //renew failed
+}
+
+func (fs *forceRenewStats) String() string {
+	r := fmt.Sprintf("DHCPv4 FORCERENEW received:%d\n", fs.Received.Load())
+	r += fmt.Sprintf("DHCPv4 FORCERENEW invalid:%d\n", fs.Invalid.Load())
+	r += fmt.Sprintf("DHCPv4 FORCERENEW renewed:%d\n", fs.Renewed.Load())
+	r += fmt.Sprintf("DHCPv4 FORCERENEW failed renew:%d\n", fs.Failed.Load())
+	return r
+}
+
+// forceRenewState is the Forcerenew Nonce authentication state of a DHCPv4 client, RFC6704
+type forceRenewState struct {
+	lock     *sync.Mutex
+	needAuth bool   //FORCERENEW must be authenticated with nonce if true
+	nonce    []byte //nonce from server, nil if not received
+	rd       uint64 //last replay detection value
+}
+
+func newForceRenewState(needAuth bool) *forceRenewState {
+	return &forceRenewState{lock: new(sync.Mutex), needAuth: needAuth}
+}
+
+// update saves the forcerenew nonce in ack if there is one
+func (fs *forceRenewState) update(ack *dhcpv4.DHCPv4) {
+	auth, err := v4AuthOption(ack.Options)
+	if err != nil || auth == nil || auth.Protocol != authProtoForceRenewNonce {
+		return
+	}
+	if len(auth.Info) != 1+authDigestLen || auth.Info[0] != forceRenewNonceTypeNonce {
+		return
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.nonce = auth.Info[1:]
+	fs.rd = auth.ReplayDetection
+}
+
+// validate checks raw is a valid FORCERENEW for lease
+func (fs *forceRenewState) validate(raw []byte, lease *v4Lease) error {
+	msg, err := dhcpv4.FromBytes(raw)
+	if err != nil {
+		return err
+	}
+	if msg.MessageType() != messageTypeForceRenew {
+		return fmt.Errorf("%v is not a FORCERENEW", msg.MessageType())
+	}
+	ack := lease.Lease.ACK
+	if !bytes.Equal(msg.ClientHWAddr, ack.ClientHWAddr) {
+		return fmt.Errorf("chaddr %v doesn't match the lease", msg.ClientHWAddr)
+	}
+	if svrID := msg.ServerIdentifier(); svrID != nil && !svrID.Equal(ack.ServerIdentifier()) {
+		return fmt.Errorf("server identifier %v doesn't match the lease", svrID)
+	}
+	if !fs.needAuth {
+		return nil
+	}
+	auth, err := v4AuthOption(msg.Options)
+	if err != nil {
+		return err
+	}
+	if auth == nil {
+		return fmt.Errorf("no authentication option")
+	}
+	if auth.Protocol != authProtoForceRenewNonce || auth.Algorithm != authAlgHMACMD5 || auth.RDM != authRDMMonotonic {
+		return fmt.Errorf("unsupported authentication protocol %d algorithm %d RDM %d", auth.Protocol, auth.Algorithm, auth.RDM)
+	}
+	if len(auth.Info) != 1+authDigestLen || auth.Info[0] != forceRenewNonceTypeHMAC {
+		return fmt.Errorf("authentication information is not HMAC-MD5 digest")
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if fs.nonce == nil {
+		return fmt.Errorf("no forcerenew nonce from server")
+	}
+	if auth.ReplayDetection <= fs.rd {
+		return fmt.Errorf("replay detection %d is not bigger than %d", auth.ReplayDetection, fs.rd)
+	}
+	if err = verifyV4(raw, fs.nonce); err != nil {
+		return err
+	}
+	fs.rd = auth.ReplayDetection
+	return nil
+}
+
+// listenForceRenew handles FORCERENEW received by dc until its forcerenew conn is closed
+func (dc *DClient) listenForceRenew() {
+	buf := make([]byte, demuxBufSize)
+	for {
+		n, _, err := dc.forceRenewConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		dc.forceRenewStats.Received.Add(1)
+		lease := dc.d4Lease
+		if lease == nil {
+			common.MyLog("%v drops a FORCERENEW without lease", dc.id)
+			dc.forceRenewStats.Invalid.Add(1)
+			continue
+		}
+		if err = dc.forceRenew.validate(buf[:n], lease); err != nil {
+			common.MyLog("%v drops an invalid FORCERENEW, %v", dc.id, err)
+			dc.forceRenewStats.Invalid.Add(1)
+			continue
+		}
+		if err = dc.respondForceRenew(lease); err != nil {
+			common.MyLog("%v failed to renew for FORCERENEW, %v", dc.id, err)
+			dc.forceRenewStats.Failed.Add(1)
+			continue
+		}
+		dc.forceRenewStats.Renewed.Add(1)
+	}
+}
+
+// respondForceRenew renews lease with a Request unicast to the server from the leased address, lease is updated with the ACK;
+// the renew is reported as a dial result
+func (dc *DClient) respondForceRenew(lease *v4Lease) error {
+	result := new(dialResult)
+	result.action = actionRenew
+	result.ExecResult = resultFailure
+	result.StartTime = time.Now()
+	result.IsDHCPv6 = false
+	result.L2EP = dc.id
+	defer func() {
+		result.FinishTime = time.Now()
+		dc.sendResult(result)
+	}()
+	newLease, err := dc.renewV4(context.Background(), lease, dc.v4IDModifiers()...)
+	if err != nil {
+		return err
+	}
+	myl := myDHCPv4Lease(*newLease)
+	lease.Lease = &myl
+	dc.forceRenew.update(newLease.ACK)
+	result.RuleViolations = dc.checkV4Rules(ruleMsgAck, newLease.ACK)
+	if len(result.RuleViolations) > 0 {
+		return fmt.Errorf("ACK violates option rules %v", result.RuleViolations)
+	}
+	result.ExecResult = resultSuccess
+	return nil
+}
+
+// optForceRenewNonceCapable returns a FORCERENEW_NONCE_CAPABLE option with HMAC-MD5 algorithm
+func optForceRenewNonceCapable() dhcpv4.Option {
+	return dhcpv4.OptGeneric(optionForceRenewNonceCapable, []byte{authAlgHMACMD5})
+}
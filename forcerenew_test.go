@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+// genForceRenew returns a FORCERENEW signed with nonce, it is not authenticated if nonce is nil
+func genForceRenew(t *testing.T, svrID net.IP, mac net.HardwareAddr, rd uint64, nonce []byte) []byte {
+	msg, err := dhcpv4.New(dhcpv4.WithMessageType(messageTypeForceRenew),
+		dhcpv4.WithHwAddr(mac),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(svrID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.OpCode = dhcpv4.OpcodeBootReply
+	if nonce == nil {
+		return msg.ToBytes()
+	}
+	msg.UpdateOption((&authOption{
+		Protocol:        authProtoForceRenewNonce,
+		Algorithm:       authAlgHMACMD5,
+		RDM:             authRDMMonotonic,
+		ReplayDetection: rd,
+		Info:            append([]byte{forceRenewNonceTypeHMAC}, make([]byte, authDigestLen)...),
+	}).v4Option())
+	raw := msg.ToBytes()
+	if err = signV4(raw, nonce); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// genV4Lease returns a lease of mac from server svrID, ACK has forcerenew nonce if it is not nil
+func genV4Lease(t *testing.T, svrID net.IP, mac net.HardwareAddr, nonce []byte) *v4Lease {
+	req, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(net.ParseIP("192.0.2.100")),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(svrID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.ParseIP("192.0.2.100")),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(svrID)),
+		dhcpv4.WithLeaseTime(3600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce != nil {
+		ack.UpdateOption((&authOption{
+			Protocol:        authProtoForceRenewNonce,
+			Algorithm:       authAlgHMACMD5,
+			ReplayDetection: 1,
+			Info:            append([]byte{forceRenewNonceTypeNonce}, nonce...),
+		}).v4Option())
+	}
+	return &v4Lease{Lease: &myDHCPv4Lease{Offer: offer, ACK: ack}}
+}
+
+func TestForceRenewValidate(t *testing.T) {
+	nonce := bytes.Repeat([]byte{0xcd}, authDigestLen)
+	svrID := net.ParseIP("192.0.2.1")
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	lease := genV4Lease(t, svrID, mac, nonce)
+	//without nonce authentication
+	fs := newForceRenewState(false)
+	if err := fs.validate(genForceRenew(t, svrID, mac, 0, nil), lease); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.validate(genForceRenew(t, svrID, net.HardwareAddr{9, 9, 9, 9, 9, 9}, 0, nil), lease); err == nil {
+		t.Fatal("FORCERENEW with wrong chaddr should fail")
+	}
+	if err := fs.validate(genForceRenew(t, net.ParseIP("192.0.2.2"), mac, 0, nil), lease); err == nil {
+		t.Fatal("FORCERENEW from other server should fail")
+	}
+	//with nonce authentication
+	fs = newForceRenewState(true)
+	if err := fs.validate(genForceRenew(t, svrID, mac, 2, nonce), lease); err == nil {
+		t.Fatal("FORCERENEW before receiving nonce should fail")
+	}
+	fs.update(lease.Lease.ACK)
+	if !bytes.Equal(fs.nonce, nonce) || fs.rd != 1 {
+		t.Fatalf("wrong nonce %x or replay detection %d", fs.nonce, fs.rd)
+	}
+	for i, c := range []struct {
+		raw   []byte
+		valid bool
+	}{
+		{genForceRenew(t, svrID, mac, 2, nonce), true},
+		//replayed
+		{genForceRenew(t, svrID, mac, 2, nonce), false},
+		{genForceRenew(t, svrID, mac, 3, []byte("wrong nonce")), false},
+		{genForceRenew(t, svrID, mac, 3, nil), false},
+		{genForceRenew(t, svrID, mac, 3, nonce), true},
+	} {
+		if err := fs.validate(c.raw, lease); (err == nil) != c.valid {
+			t.Fatalf("case %d expect valid %v, got %v", i, c.valid, err)
+		}
+	}
+}
+
+// fakeRenewServer ACKs renew requests on conn
+func fakeRenewServer(conn net.PacketConn, svrID net.IP) {
+	for {
+		buf := make([]byte, demuxBufSize)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil || n == 0 {
+			//conn is closed
+			return
+		}
+		req, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil || req.MessageType() != dhcpv4.MessageTypeRequest {
+			continue
+		}
+		ack, _ := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+			dhcpv4.WithYourIP(req.ClientIPAddr),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(svrID)),
+			dhcpv4.WithLeaseTime(3600))
+		conn.WriteTo(ack.ToBytes(), nil)
+	}
+}
+
+func TestForceRenew(t *testing.T) {
+	nonce := bytes.Repeat([]byte{0xcd}, authDigestLen)
+	svrID := net.ParseIP("192.0.2.1")
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	clnt, svr := conpair.NewPacketConnPair()
+	go fakeRenewServer(svr, svrID)
+	demux := newDHCPv4Demux(clnt)
+	v4Conn := demux.side()
+	dc := &DClient{
+		cfg:             &clientConfig{Mac: mac, setup: newDefaultConf()},
+		d4Lease:         genV4Lease(t, svrID, mac, nonce),
+		forceRenewConn:  demux.side(messageTypeForceRenew),
+		forceRenew:      newForceRenewState(true),
+		forceRenewStats: new(forceRenewStats),
+		dialResultCh:    make(chan *dialResult, 1),
+	}
+	demux.start()
+	var err error
+	dc.d4, err = nclient4.NewWithConn(v4Conn, mac, nclient4.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.d4.Close()
+	defer dc.forceRenewConn.Close()
+	dc.forceRenew.update(dc.d4Lease.Lease.ACK)
+	go dc.listenForceRenew()
+	svr.WriteTo(genForceRenew(t, svrID, mac, 2, []byte("wrong nonce")), nil)
+	svr.WriteTo(genForceRenew(t, svrID, mac, 2, nonce), nil)
+	deadline := time.Now().Add(3 * time.Second)
+	for dc.forceRenewStats.Renewed.Load()+dc.forceRenewStats.Failed.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("FORCERENEW is not handled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dc.forceRenewStats.Received.Load() != 2 || dc.forceRenewStats.Invalid.Load() != 1 || dc.forceRenewStats.Renewed.Load() != 1 {
+		t.Fatalf("wrong stats\n%v", dc.forceRenewStats)
+	}
+	if r := <-dc.dialResultCh; r.action != actionRenew || r.ExecResult != resultSuccess {
+		t.Fatalf("wrong result %+v", r)
+	}
+}
+
+func TestForceRenewStatsWithLeaseFile(t *testing.T) {
+	for _, act := range []actionType{actionRelease, actionRenew, actionRebind, actionReboot} {
+		sch := newLeaseFileSched(t, act)
+		if sch.forceRenewStats == nil {
+			t.Fatalf("FORCERENEW stats of action %v is nil", act)
+		}
+		sch.printSummary("Final")
+	}
+}
+
+func TestForceRenewReleaseRemovesARP(t *testing.T) {
+	svrID := net.ParseIP("192.0.2.1")
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	clnt, svr := conpair.NewPacketConnPair()
+	//closing svr stops receive loop of d4
+	defer svr.Close()
+	d4, err := nclient4.NewWithConn(clnt, mac, nclient4.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arp := &NDPProxy{lock: new(sync.RWMutex), targets: make(map[string]L2Encap)}
+	dc := &DClient{
+		cfg:          &clientConfig{Mac: mac, setup: newDefaultConf()},
+		d4:           d4,
+		d4Lease:      genV4Lease(t, svrID, mac, nil),
+		arp:          arp,
+		dialResultCh: make(chan *dialResult, 1),
+	}
+	arp.add("192.0.2.100", L2Encap{HwAddr: mac})
+	if err = dc.releasev4(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := arp.targets["192.0.2.100"]; ok {
+		t.Fatal("ARP entry of released lease is not removed")
+	}
+}
@@ -0,0 +1,425 @@
+// fuzz
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/hujun-open/dhcplt/dhcpv6relay"
+	"github.com/hujun-open/myaddr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+)
+
+// FuzzConf is the configuration of action fuzz, in which some clients send malformed messages,
+// while the rest clients dial normally
+type FuzzConf struct {
+	Num       uint           `alias:"fuzznum" usage:"number of clients sending malformed messages with action fuzz, taken from the first clients"`
+	Interval  time.Duration  `alias:"fuzzinterval" usage:"interval between two malformed messages of a fuzzing client"`
+	Mutations []mutationType `alias:"fuzzmutation" usage:"mutations of malformed messages, truncated|badlen|duplicate|oversized|unknowntype|relaynest, all if not specified"`
+}
+
+const defaultFuzzInterval = 100 * time.Millisecond
+
+const (
+	// maxUDPPayload is the max UDP payload in a 1500 bytes ethernet frame, with IPv6 header
+	maxUDPPayload = 1500 - 40 - 8
+	// v4OptionOffset is the offset of the first option in DHCPv4 message, after the magic cookie
+	v4OptionOffset = 240
+	// v6OptionOffset is the offset of the first option in DHCPv6 client/server message
+	v6OptionOffset = 4
+	// fuzzRelayNestDepth is more than HOP_COUNT_LIMIT of RFC8415 section 7.6
+	fuzzRelayNestDepth = 10
+	// unknownMsgType is not assigned to any DHCPv4 or DHCPv6 message
+	unknownMsgType = 200
+)
+
+type mutationType int
+
+const (
+	mutationTruncated mutationType = iota
+	mutationBadLen
+	mutationDuplicate
+	mutationOversized
+	mutationUnknownType
+	mutationRelayNest
+)
+
+var mutationTypeNames = map[mutationType]string{
+	mutationTruncated:   "truncated",
+	mutationBadLen:      "badlen",
+	mutationDuplicate:   "duplicate",
+	mutationOversized:   "oversized",
+	mutationUnknownType: "unknowntype",
+	mutationRelayNest:   "relaynest",
+}
+
+func (mt mutationType) String() string {
+	buf, err := mt.MarshalText()
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+func (mt mutationType) MarshalText() (text []byte, err error) {
+	if s, ok := mutationTypeNames[mt]; ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unknown mutation %d", mt)
+}
+
+func (mt *mutationType) UnmarshalText(text []byte) error {
+	for m, name := range mutationTypeNames {
+		if name == strings.ToLower(string(text)) {
+			*mt = m
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown mutation %v", string(text))
+}
+
+// mutateV4 returns a malformed message mutated from the valid DHCPv4 message pkt,
+// return nil if the mutation doesn't apply to DHCPv4
+func (mt mutationType) mutateV4(pkt []byte) []byte {
+	//options without End
+	opts := pkt[v4OptionOffset : v4OptionOffset+v4OptionsLen(pkt)]
+	head := append([]byte{}, pkt[:v4OptionOffset]...)
+	switch mt {
+	case mutationTruncated:
+		//client identifier claims 16 bytes, but packet ends after 3 bytes
+		return append(append(head, opts...), byte(dhcpv4.OptionClientIdentifier), 16, 1, 2, 3)
+	case mutationBadLen:
+		r := append(append(head, opts...), byte(dhcpv4.OptionEnd))
+		r[v4OptionOffset+1] = 0xff
+		return r
+	case mutationDuplicate:
+		//second message type option says Request
+		r := append(append(head, opts...), byte(dhcpv4.OptionDHCPMessageType), 1, byte(dhcpv4.MessageTypeRequest))
+		return append(append(r, opts...), byte(dhcpv4.OptionEnd))
+	case mutationOversized:
+		r := append(head, opts...)
+		for len(r)+2 < maxUDPPayload {
+			l := maxUDPPayload - len(r) - 3
+			if l > 255 {
+				l = 255
+			}
+			//site specific option
+			r = append(r, 224, byte(l))
+			r = append(r, make([]byte, l)...)
+		}
+		return append(r, byte(dhcpv4.OptionEnd))
+	case mutationUnknownType:
+		r := append(append(head, opts...), byte(dhcpv4.OptionEnd))
+		if i := findV4Option(r, dhcpv4.OptionDHCPMessageType); i >= 0 {
+			r[i+2] = unknownMsgType
+		}
+		return r
+	}
+	return nil
+}
+
+// mutateV6 returns a malformed message mutated from the valid DHCPv6 client message pkt,
+// return nil if the mutation doesn't apply to DHCPv6
+func (mt mutationType) mutateV6(pkt []byte) []byte {
+	r := append([]byte{}, pkt...)
+	switch mt {
+	case mutationTruncated:
+		//client id claims 100 bytes, but packet ends after 3 bytes
+		return append(r, 0, byte(dhcpv6.OptionClientID), 0, 100, 1, 2, 3)
+	case mutationBadLen:
+		if len(r) >= v6OptionOffset+4 {
+			binary.BigEndian.PutUint16(r[v6OptionOffset+2:], 0xffff)
+		}
+		return r
+	case mutationDuplicate:
+		//second client id with a different DUID
+		if start, end := findV6Option(r, dhcpv6.OptionClientID); start >= 0 {
+			dup := append([]byte{}, r[start:end]...)
+			dup[len(dup)-1] ^= 0xff
+			r = append(r, dup...)
+		}
+		return r
+	case mutationOversized:
+		for len(r)+4 < maxUDPPayload {
+			l := maxUDPPayload - len(r) - 4
+			//unassigned option code
+			r = binary.BigEndian.AppendUint16(r, 65000)
+			r = binary.BigEndian.AppendUint16(r, uint16(l))
+			r = append(r, make([]byte, l)...)
+		}
+		return r
+	case mutationUnknownType:
+		r[0] = unknownMsgType
+		return r
+	case mutationRelayNest:
+		for i := 0; i < fuzzRelayNestDepth; i++ {
+			r = wrapRelayForward(r, uint8(i), net.IPv6unspecified, net.IPv6unspecified, nil)
+		}
+		return r
+	}
+	return nil
+}
+
+// v4OptionsLen returns the length of options in DHCPv4 message pkt, until End option
+func v4OptionsLen(pkt []byte) int {
+	i := v4OptionOffset
+	for i < len(pkt) {
+		switch pkt[i] {
+		case dhcpv4.OptionEnd.Code():
+			return i - v4OptionOffset
+		case dhcpv4.OptionPad.Code():
+			i++
+			continue
+		}
+		if i+1 >= len(pkt) {
+			break
+		}
+		i += 2 + int(pkt[i+1])
+	}
+	if i > len(pkt) {
+		i = len(pkt)
+	}
+	return i - v4OptionOffset
+}
+
+// findV4Option returns the index of the first option code in DHCPv4 message pkt, -1 if not found
+func findV4Option(pkt []byte, code dhcpv4.OptionCode) int {
+	for i := v4OptionOffset; i+1 < len(pkt); {
+		switch pkt[i] {
+		case dhcpv4.OptionEnd.Code():
+			return -1
+		case dhcpv4.OptionPad.Code():
+			i++
+			continue
+		}
+		if pkt[i] == code.Code() {
+			return i
+		}
+		i += 2 + int(pkt[i+1])
+	}
+	return -1
+}
+
+// findV6Option returns the start and end index of the first option code in DHCPv6 client message pkt,
+// start is -1 if not found
+func findV6Option(pkt []byte, code dhcpv6.OptionCode) (start, end int) {
+	for i := v6OptionOffset; i+4 <= len(pkt); {
+		l := int(binary.BigEndian.Uint16(pkt[i+2:]))
+		if dhcpv6.OptionCode(binary.BigEndian.Uint16(pkt[i:])) == code && i+4+l <= len(pkt) {
+			return i, i + 4 + l
+		}
+		i += 4 + l
+	}
+	return -1, -1
+}
+
+// wrapRelayForward encapsulates pkt in a Relay-Forward, the relay message option is added as is,
+// so that pkt could be malformed
+func wrapRelayForward(pkt []byte, hopCount uint8, link, peer net.IP, opts dhcpv6.Options) []byte {
+	return newRawRelayForward(pkt, hopCount, link, peer, opts).ToBytes()
+}
+
+// newRawRelayForward returns a Relay-Forward with pkt as the relay message option as is,
+// followed by opts like Relay-Forward of dhcpv6relay.RelayAgent
+func newRawRelayForward(pkt []byte, hopCount uint8, link, peer net.IP, opts dhcpv6.Options) *dhcpv6.RelayMessage {
+	relay := &dhcpv6.RelayMessage{
+		MessageType: dhcpv6.MessageTypeRelayForward,
+		HopCount:    hopCount,
+		LinkAddr:    link,
+		PeerAddr:    peer,
+	}
+	relay.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionRelayMsg, OptionData: pkt})
+	for _, o := range opts {
+		relay.AddOption(o)
+	}
+	return relay
+}
+
+// fuzzStats counts malformed messages of each stack and mutation
+type fuzzStats struct {
+	lock   *sync.Mutex
+	sent   map[string]int
+	failed map[string]int
+}
+
+func (fs *fuzzStats) add(key string, err error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if err != nil {
+		fs.failed[key]++
+		return
+	}
+	fs.sent[key]++
+}
+
+// fuzzer makes the selected clients send malformed messages, while the rest clients dial normally
+type fuzzer struct {
+	clients []*DClient
+	stats   *fuzzStats
+}
+
+// newFuzzer takes the first fuzznum clients of each group as fuzzing clients, and removes them from the group,
+// so that the group only dials with the rest clients
+func newFuzzer(groups []*clientGroup) *fuzzer {
+	r := &fuzzer{
+		stats: &fuzzStats{
+			lock:   new(sync.Mutex),
+			sent:   make(map[string]int),
+			failed: make(map[string]int),
+		},
+	}
+	for _, g := range groups {
+		n := int(g.setup.Fuzz.Num)
+		if n > len(g.clients) {
+			n = len(g.clients)
+		}
+		r.clients = append(r.clients, g.clients[:n]...)
+		g.clients = g.clients[n:]
+	}
+	return r
+}
+
+// run sends malformed messages until ctx is done
+func (f *fuzzer) run(ctx context.Context) {
+	wg := new(sync.WaitGroup)
+	for _, dc := range f.clients {
+		if dc.d4 != nil {
+			wg.Add(1)
+			go f.fuzzStack(ctx, wg, dc, false)
+		}
+		if dc.d6 != nil {
+			wg.Add(1)
+			go f.fuzzStack(ctx, wg, dc, true)
+		}
+	}
+	wg.Wait()
+}
+
+func (f *fuzzer) fuzzStack(ctx context.Context, wg *sync.WaitGroup, dc *DClient, isV6 bool) {
+	defer wg.Done()
+	conf := dc.cfg.setup.Fuzz
+	mutations := conf.Mutations
+	if len(mutations) == 0 {
+		for m := range mutationTypeNames {
+			mutations = append(mutations, m)
+		}
+		sort.Slice(mutations, func(i, j int) bool { return mutations[i] < mutations[j] })
+	}
+	stack := "DHCPv4"
+	if isV6 {
+		stack = "DHCPv6"
+	}
+	for i := 0; ; i++ {
+		if !sleepCtx(ctx, conf.Interval) {
+			return
+		}
+		m := mutations[i%len(mutations)]
+		var pkt []byte
+		var err error
+		if isV6 {
+			pkt, err = dc.fuzzV6(m)
+		} else {
+			pkt, err = dc.fuzzV4(m)
+		}
+		if pkt == nil && err == nil {
+			//mutation doesn't apply to the stack
+			continue
+		}
+		if err != nil {
+			common.MyLog("%v failed to send malformed %v msg with mutation %v, %v", dc.id, stack, m, err)
+		}
+		f.stats.add(fmt.Sprintf("%v %v", stack, m), err)
+	}
+}
+
+// fuzzV4 sends a Discover mutated by m, return nil pkt if m doesn't apply
+func (dc *DClient) fuzzV4(m mutationType) (pkt []byte, err error) {
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithBroadcast(true),
+		dhcpv4.WithGatewayIP(dc.cfg.setup.GiAddr.AsSlice()),
+	}
+	for _, op := range dc.cfg.V4Options {
+		mods = append(mods, dhcpv4.WithOption(op))
+	}
+	discover, err := dhcpv4.NewDiscovery(dc.cfg.Mac, mods...)
+	if err != nil {
+		return nil, err
+	}
+	if pkt = m.mutateV4(discover.ToBytes()); pkt == nil {
+		return nil, nil
+	}
+	_, err = dc.v4conn.WriteTo(pkt, dc.d4.RemoteAddr())
+	return pkt, err
+}
+
+// fuzzV6 sends a Solicit mutated by m, the malformed Solicit is encapsulated in a valid Relay-Forward in relay mode;
+// return nil pkt if m doesn't apply
+func (dc *DClient) fuzzV6(m mutationType) (pkt []byte, err error) {
+	solicit, err := buildSolicit(*dc.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if pkt = m.mutateV6(solicit.ToBytes()); pkt == nil {
+		return nil, nil
+	}
+	if dc.d6relay != nil {
+		if pkt, err = dc.relayForwardV6(pkt); err != nil {
+			return nil, err
+		}
+		for _, svr := range dc.cfg.setup.v6SvrAddrs() {
+			if _, err = dc.v6conn.WriteTo(pkt, svr); err != nil {
+				return pkt, err
+			}
+		}
+		return pkt, nil
+	}
+	_, err = dc.v6conn.WriteTo(pkt, nclient6.AllDHCPRelayAgentsAndServers)
+	return pkt, err
+}
+
+// relayForwardV6 encapsulates pkt in Relay-Forward the same way as the DHCPv6 relay agent of dc, pkt could be malformed
+func (dc *DClient) relayForwardV6(pkt []byte) ([]byte, error) {
+	link := dc.cfg.V6LinkAddr
+	if dc.cfg.setup.LDRA {
+		link = net.IPv6unspecified
+	}
+	relayfwd, err := dhcpv6relay.EncapsulateHops(
+		newRawRelayForward(pkt, 0, link, myaddr.GetLLAFromMac(dc.cfg.Mac), dc.cfg.V6RelayOptions),
+		dc.cfg.V6RelayHops)
+	if err != nil {
+		return nil, err
+	}
+	return relayfwd.ToBytes(), nil
+}
+
+func (f *fuzzer) String() string {
+	f.stats.lock.Lock()
+	defer f.stats.lock.Unlock()
+	keys := []string{}
+	for k := range f.stats.sent {
+		keys = append(keys, k)
+	}
+	for k := range f.stats.failed {
+		if _, ok := f.stats.sent[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	r := fmt.Sprintf("Fuzzing clients:%d\n", len(f.clients))
+	for _, k := range keys {
+		r += fmt.Sprintf("Malformed %v sent:%d\n", k, f.stats.sent[k])
+		if f.stats.failed[k] > 0 {
+			r += fmt.Sprintf("Malformed %v failed to send:%d\n", k, f.stats.failed[k])
+		}
+	}
+	return r
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/hujun-open/dhcplt/dhcpv6relay"
+	"github.com/hujun-open/myaddr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestFuzz(t *testing.T) {
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	discover, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte("client-1"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v4pkt := discover.ToBytes()
+	solicit, err := dhcpv6.NewSolicit(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v6pkt := solicit.ToBytes()
+	for m := range mutationTypeNames {
+		var name mutationType
+		if err := name.UnmarshalText([]byte(m.String())); err != nil || name != m {
+			t.Fatalf("failed to parse mutation %v, %v", m, err)
+		}
+		v4 := m.mutateV4(v4pkt)
+		if m == mutationRelayNest {
+			if v4 != nil {
+				t.Fatal("relaynest should not apply to DHCPv4")
+			}
+		} else if bytes.Equal(v4, v4pkt) {
+			t.Fatalf("DHCPv4 msg is not mutated by %v", m)
+		}
+		if bytes.Equal(m.mutateV6(v6pkt), v6pkt) {
+			t.Fatalf("DHCPv6 msg is not mutated by %v", m)
+		}
+	}
+	if !bytes.Equal(v4pkt, discover.ToBytes()) || !bytes.Equal(v6pkt, solicit.ToBytes()) {
+		t.Fatal("original msg is changed by mutation")
+	}
+	if l := len(mutationOversized.mutateV4(v4pkt)); l != maxUDPPayload {
+		t.Fatalf("oversized DHCPv4 msg should be %d bytes, got %d", maxUDPPayload, l)
+	}
+	if l := len(mutationOversized.mutateV6(v6pkt)); l != maxUDPPayload {
+		t.Fatalf("oversized DHCPv6 msg should be %d bytes, got %d", maxUDPPayload, l)
+	}
+	if _, err := dhcpv4.FromBytes(mutationTruncated.mutateV4(v4pkt)); err == nil {
+		t.Fatal("truncated DHCPv4 msg should fail to parse")
+	}
+	if _, err := dhcpv6.FromBytes(mutationBadLen.mutateV6(v6pkt)); err == nil {
+		t.Fatal("DHCPv6 msg with bad length should fail to parse")
+	}
+	unknown := mutationUnknownType.mutateV4(v4pkt)
+	if i := findV4Option(unknown, dhcpv4.OptionDHCPMessageType); i < 0 || unknown[i+2] != unknownMsgType {
+		t.Fatal("DHCPv4 message type is not changed to unknown type")
+	}
+	dup := mutationDuplicate.mutateV6(v6pkt)
+	start, end := findV6Option(dup, dhcpv6.OptionClientID)
+	if start < 0 || !bytes.Contains(dup[end:], dup[start:end-1]) {
+		t.Fatal("DHCPv6 client id is not duplicated")
+	}
+	nest, err := dhcpv6.FromBytes(mutationRelayNest.mutateV6(v6pkt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relay, ok := nest.(*dhcpv6.RelayMessage); !ok || relay.HopCount != fuzzRelayNestDepth-1 {
+		t.Fatalf("outer relay hop count should be %d, got %v", fuzzRelayNestDepth-1, nest)
+	}
+}
+
+func TestFuzzRelayForward(t *testing.T) {
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	setup := newDefaultConf()
+	setup.V6MsgType = dhcpv6.MessageTypeRelayForward
+	setup.LDRA = true
+	hops := []dhcpv6relay.Hop{
+		{LinkAddr: net.ParseIP("2001:db8:1::1"), HopCount: -1},
+		{LinkAddr: net.ParseIP("2001:db8:2::1"), HopCount: 5, Options: dhcpv6.Options{dhcpv6.OptInterfaceID([]byte("agg1"))}},
+	}
+	dc := &DClient{cfg: &clientConfig{
+		Mac:            mac,
+		setup:          setup,
+		V6LinkAddr:     net.ParseIP("2001:db8::10"),
+		V6RelayOptions: dhcpv6.Options{dhcpv6.OptInterfaceID([]byte("port-1"))},
+		V6RelayHops:    hops,
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	accessClnt, accessRelay := conpair.NewPacketConnPair()
+	netRelay, netSvr := conpair.NewPacketConnPair()
+	dhcpv6relay.NewRelayAgent(ctx, &dhcpv6relay.PairDHCPConn{PacketConnPair: accessRelay},
+		&dhcpv6relay.PairDHCPConn{PacketConnPair: netRelay},
+		setup.relayModifiers(
+			dhcpv6relay.WithLinkAddr(dc.cfg.V6LinkAddr),
+			dhcpv6relay.WithPeerAddr(myaddr.GetLLAFromMac(mac)),
+			dhcpv6relay.WithOptions(dc.cfg.V6RelayOptions),
+			dhcpv6relay.WithHops(hops))...)
+	solicit, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = accessClnt.WriteTo(solicit.ToBytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+	netSvr.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxDHCPv6Size)
+	n, _, err := netSvr.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := dc.relayForwardV6(solicit.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pkt, buf[:n]) {
+		t.Fatalf("Relay-Forward of fuzzing is different from relay agent\n%x\n%x", pkt, buf[:n])
+	}
+}
@@ -0,0 +1,300 @@
+// group
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"gopkg.in/yaml.v3"
+)
+
+// groupConf is a client group in the group file,
+// a group inherits all settings from the main setup, and only overrides the specified fields,
+// field names are same as the command line parameters
+type groupConf struct {
+	Name            string           `yaml:"name"`
+	NumOfClients    *uint            `yaml:"n"`
+	StartMAC        *string          `yaml:"mac"`
+	MacStep         *uint            `yaml:"macstep"`
+	StartVLANs      *etherconn.VLANs `yaml:"vlan"`
+	VLANStep        *uint            `yaml:"vlanstep"`
+	ExcludedVLANs   []uint16         `yaml:"excludedvlans"`
+	ClientFile      *string          `yaml:"clientfile"`
+	Interval        *time.Duration   `yaml:"interval"`
+	EnableV4        *bool            `yaml:"v4"`
+	EnableV6        *bool            `yaml:"v6"`
+	V4oV6           *bool            `yaml:"v4ov6"`
+	Reconfigure     *bool            `yaml:"reconfigure"`
+	ForceRenew      *bool            `yaml:"forcerenew"`
+	ForceRenewNonce *bool            `yaml:"forcerenewnonce"`
+	Auth            *authMode        `yaml:"auth"`
+	AuthKeyFile     *string          `yaml:"authkeyfile"`
+	StackDelay      *time.Duration   `yaml:"stackdelay"`
+	V6MsgType       *string          `yaml:"v6msgtype"`
+	RelayHops       []relayHop       `yaml:"relayhop"`
+	LDRA            *bool            `yaml:"ldra"`
+	LinkAddr        *string          `yaml:"linkaddr"`
+	V6Servers       []netip.Addr     `yaml:"v6server"`
+	NeedNA          *bool            `yaml:"needna"`
+	NeedPD          *bool            `yaml:"needpd"`
+	DUIDType        *duidType        `yaml:"duidtype"`
+	IAID            *iaidScheme      `yaml:"iaid"`
+	NumOfNA         *uint            `yaml:"numna"`
+	NumOfPD         *uint            `yaml:"numpd"`
+	PDLenHint       *uint            `yaml:"pdlen"`
+	NAHint          *string          `yaml:"nahint"`
+	PDHint          *string          `yaml:"pdhint"`
+	V4Pools         []ipRange        `yaml:"v4pool"`
+	NAPools         []ipRange        `yaml:"napool"`
+	PDPools         []pdPool         `yaml:"pdpool"`
+	OptionRules     []optionRule     `yaml:"optrule"`
+	RID             *string          `yaml:"rid"`
+	CID             *string          `yaml:"cid"`
+	ClntID          *string          `yaml:"clntid"`
+	VendorClass     *string          `yaml:"vendorclass"`
+	SubscriberID    *string          `yaml:"subscriberid"`
+	ClientLLAddr    *bool            `yaml:"clientlladdr"`
+	RelaySrcPort    *bool            `yaml:"relaysrcport"`
+	RadiusAttrs     []customOption   `yaml:"radiusattr"`
+	CustomV4Options []customOption   `yaml:"customv4option"`
+	CustomV6Options []customOption   `yaml:"customv6option"`
+	GiAddr          *string          `yaml:"giaddr"`
+	ReqIPStart      *string          `yaml:"reqip"`
+	Flapping        *struct {
+		FlapNum     *int           `yaml:"flapnum"`
+		MinInterval *time.Duration `yaml:"flapmininterval"`
+		MaxInterval *time.Duration `yaml:"flapmaxinterval"`
+		StayDownDur *time.Duration `yaml:"flapstaydowndur"`
+		Renew       *bool          `yaml:"flaprenew"`
+	} `yaml:"flapping"`
+	Churn *struct {
+		Interval  *time.Duration `yaml:"churninterval"`
+		Num       *uint          `yaml:"churnnum"`
+		NoRelease *bool          `yaml:"churnnorelease"`
+	} `yaml:"churn"`
+}
+
+// apply returns a copy of base with fields overridden by gconf
+func (gconf groupConf) apply(base *testSetup) (*testSetup, error) {
+	r := new(testSetup)
+	*r = *base
+	r.groupName = gconf.Name
+	r.groups = nil
+	r.clientEntries = nil
+	r.authKeys = nil
+	//auto is resolved per group with its own settings
+	r.V6MsgType = base.v6MsgTypeConf
+	var err error
+	setIf := func(dst *uint, val *uint) {
+		if val != nil {
+			*dst = *val
+		}
+	}
+	setIf(&r.NumOfClients, gconf.NumOfClients)
+	setIf(&r.MacStep, gconf.MacStep)
+	setIf(&r.VLANStep, gconf.VLANStep)
+	setIf(&r.NumOfNA, gconf.NumOfNA)
+	setIf(&r.NumOfPD, gconf.NumOfPD)
+	setIf(&r.PDLenHint, gconf.PDLenHint)
+	if gconf.StartMAC != nil {
+		if r.StartMAC, err = net.ParseMAC(*gconf.StartMAC); err != nil {
+			return nil, fmt.Errorf("invalid mac %v, %w", *gconf.StartMAC, err)
+		}
+	}
+	if gconf.StartVLANs != nil {
+		r.StartVLANs = *gconf.StartVLANs
+	}
+	if gconf.ExcludedVLANs != nil {
+		r.ExcludedVLANs = gconf.ExcludedVLANs
+	}
+	if gconf.ClientFile != nil {
+		r.ClientFile = *gconf.ClientFile
+	}
+	if gconf.Interval != nil {
+		r.Interval = *gconf.Interval
+	}
+	if gconf.Auth != nil {
+		r.Auth = *gconf.Auth
+	}
+	if gconf.AuthKeyFile != nil {
+		r.AuthKeyFile = *gconf.AuthKeyFile
+	}
+	if gconf.StackDelay != nil {
+		r.StackDelay = *gconf.StackDelay
+	}
+	for _, b := range []struct {
+		dst *bool
+		val *bool
+	}{
+		{&r.EnableV4, gconf.EnableV4},
+		{&r.EnableV6, gconf.EnableV6},
+		{&r.V4oV6, gconf.V4oV6},
+		{&r.Reconfigure, gconf.Reconfigure},
+		{&r.ForceRenew, gconf.ForceRenew},
+		{&r.ForceRenewNonce, gconf.ForceRenewNonce},
+		{&r.NeedNA, gconf.NeedNA},
+		{&r.NeedPD, gconf.NeedPD},
+		{&r.LDRA, gconf.LDRA},
+		{&r.ClientLLAddr, gconf.ClientLLAddr},
+		{&r.RelaySrcPort, gconf.RelaySrcPort},
+	} {
+		if b.val != nil {
+			*b.dst = *b.val
+		}
+	}
+	for _, str := range []struct {
+		dst *string
+		val *string
+	}{
+		{&r.RID, gconf.RID},
+		{&r.CID, gconf.CID},
+		{&r.ClntID, gconf.ClntID},
+		{&r.VendorClass, gconf.VendorClass},
+		{&r.SubscriberID, gconf.SubscriberID},
+		{&r.NAHint, gconf.NAHint},
+		{&r.PDHint, gconf.PDHint},
+		{&r.LinkAddr, gconf.LinkAddr},
+	} {
+		if str.val != nil {
+			*str.dst = *str.val
+		}
+	}
+	if gconf.V6MsgType != nil {
+		mt, err := d6MsgTypeFromStr(*gconf.V6MsgType)
+		if err != nil {
+			return nil, err
+		}
+		r.V6MsgType = mt.(dhcpv6.MessageType)
+	}
+	if gconf.RelayHops != nil {
+		r.RelayHops = gconf.RelayHops
+	}
+	if gconf.RadiusAttrs != nil {
+		r.RadiusAttrs = gconf.RadiusAttrs
+	}
+	if gconf.V6Servers != nil {
+		r.V6Servers = gconf.V6Servers
+	}
+	if gconf.DUIDType != nil {
+		r.DUIDType = *gconf.DUIDType
+	}
+	if gconf.IAID != nil {
+		r.IAID = *gconf.IAID
+	}
+	if gconf.V4Pools != nil {
+		r.V4Pools = gconf.V4Pools
+	}
+	if gconf.NAPools != nil {
+		r.NAPools = gconf.NAPools
+	}
+	if gconf.PDPools != nil {
+		r.PDPools = gconf.PDPools
+	}
+	if gconf.OptionRules != nil {
+		r.OptionRules = gconf.OptionRules
+	}
+	if gconf.CustomV4Options != nil {
+		r.CustomV4Options = gconf.CustomV4Options
+	}
+	if gconf.CustomV6Options != nil {
+		r.CustomV6Options = gconf.CustomV6Options
+	}
+	if gconf.GiAddr != nil {
+		if err = r.GiAddr.UnmarshalText([]byte(*gconf.GiAddr)); err != nil {
+			return nil, fmt.Errorf("invalid giaddr %v, %w", *gconf.GiAddr, err)
+		}
+	}
+	if gconf.ReqIPStart != nil {
+		if err = r.ReqIPStart.UnmarshalText([]byte(*gconf.ReqIPStart)); err != nil {
+			return nil, fmt.Errorf("invalid reqip %v, %w", *gconf.ReqIPStart, err)
+		}
+	}
+	if gconf.Flapping != nil {
+		flap := FlappingConf{}
+		if base.Flapping != nil {
+			flap = *base.Flapping
+		}
+		r.Flapping = &flap
+		if gconf.Flapping.FlapNum != nil {
+			flap.FlapNum = *gconf.Flapping.FlapNum
+		}
+		if gconf.Flapping.Renew != nil {
+			flap.Renew = *gconf.Flapping.Renew
+		}
+		for _, d := range []struct {
+			dst *time.Duration
+			val *time.Duration
+		}{
+			{&flap.MinInterval, gconf.Flapping.MinInterval},
+			{&flap.MaxInterval, gconf.Flapping.MaxInterval},
+			{&flap.StayDownDur, gconf.Flapping.StayDownDur},
+		} {
+			if d.val != nil {
+				*d.dst = *d.val
+			}
+		}
+	}
+	if gconf.Churn != nil {
+		churn := ChurnConf{}
+		if base.Churn != nil {
+			churn = *base.Churn
+		}
+		r.Churn = &churn
+		if gconf.Churn.Interval != nil {
+			churn.Interval = *gconf.Churn.Interval
+		}
+		setIf(&churn.Num, gconf.Churn.Num)
+		if gconf.Churn.NoRelease != nil {
+			churn.NoRelease = *gconf.Churn.NoRelease
+		}
+	}
+	return r, nil
+}
+
+// loadGroupFile loads client groups from the YAML file inf, each group inherits settings from base
+func loadGroupFile(inf string, base *testSetup) ([]*testSetup, error) {
+	data, err := os.ReadFile(inf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group file %v, %w", inf, err)
+	}
+	var gconfs []groupConf
+	if err = yaml.Unmarshal(data, &gconfs); err != nil {
+		return nil, fmt.Errorf("failed to parse group file %v, %w", inf, err)
+	}
+	if len(gconfs) == 0 {
+		return nil, fmt.Errorf("there is no group in %v", inf)
+	}
+	r := []*testSetup{}
+	names := make(map[string]bool)
+	for i, gconf := range gconfs {
+		if gconf.Name == "" {
+			gconf.Name = fmt.Sprintf("group-%d", i)
+		}
+		if names[gconf.Name] {
+			return nil, fmt.Errorf("duplicate group name %v", gconf.Name)
+		}
+		names[gconf.Name] = true
+		g, err := gconf.apply(base)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group %v, %w", gconf.Name, err)
+		}
+		if err = g.initPopulation(); err != nil {
+			return nil, fmt.Errorf("invalid group %v, %w", gconf.Name, err)
+		}
+		r = append(r, g)
+	}
+	return r, nil
+}
+
+// populations returns all client groups, or setup itself if there is no group
+func (setup *testSetup) populations() []*testSetup {
+	if len(setup.groups) > 0 {
+		return setup.groups
+	}
+	return []*testSetup{setup}
+}
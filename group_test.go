@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestLoadGroupFile(t *testing.T) {
+	groupf := filepath.Join(t.TempDir(), "groups.yaml")
+	err := os.WriteFile(groupf, []byte(`
+- name: residential
+  n: 8000
+  mac: aa:bb:cc:00:00:01
+  vlan: "100"
+  v6: true
+  customv4option:
+    - "60:string:residential"
+  churn:
+    churninterval: 100ms
+    churnnorelease: true
+- name: business
+  n: 2000
+  mac: aa:bb:cc:10:00:01
+  interval: 10ms
+  customv4option:
+    - "60:string:business-@ID"
+  relayhop:
+    - "link=2001:db8::1;iid=agg-@SVLAN"
+  flapping:
+    flapnum: 10
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := newDefaultConf()
+	base.NumOfClients = 1
+	if err = base.initPopulation(); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := loadGroupFile(groupf, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("expect 2 groups, got %d", len(groups))
+	}
+	res, bus := groups[0], groups[1]
+	if res.groupName != "residential" || res.NumOfClients != 8000 || !res.EnableV6 || !res.EnableV4 {
+		t.Fatalf("wrong residential group %+v", res)
+	}
+	if res.V6MsgType != dhcpv6.MessageTypeSolicit || len(res.StartVLANs) != 1 {
+		t.Fatalf("wrong residential group %+v", res)
+	}
+	if bus.EnableV6 || bus.Interval != 10*time.Millisecond || bus.CustomV4Options[0].Value != "business-@ID" {
+		t.Fatalf("wrong business group %+v", bus)
+	}
+	//v6msgtype auto is resolved by settings of each group
+	if base.V6MsgType != dhcpv6.MessageTypeSolicit || bus.V6MsgType != dhcpv6.MessageTypeRelayForward {
+		t.Fatalf("wrong DHCPv6 exchange type, base %v, business %v", base.V6MsgType, bus.V6MsgType)
+	}
+	if len(bus.RelayHops) != 1 || bus.RelayHops[0].IID != "agg-@SVLAN" || len(res.RelayHops) != 0 {
+		t.Fatalf("wrong relay hops, %+v, %+v", res.RelayHops, bus.RelayHops)
+	}
+	if bus.Flapping.FlapNum != 10 || res.Flapping.FlapNum != 0 || bus.Flapping.MaxInterval != defualtMaxFlapInt {
+		t.Fatalf("wrong flapping settings, %+v, %+v", res.Flapping, bus.Flapping)
+	}
+	if !res.Churn.enabled() || !res.Churn.NoRelease || bus.Churn.enabled() {
+		t.Fatalf("wrong churn settings, %+v, %+v", res.Churn, bus.Churn)
+	}
+	if base.NumOfClients != 1 || base.Flapping.FlapNum != 0 || base.Churn.enabled() {
+		t.Fatalf("base setup is changed, %+v", base)
+	}
+	err = os.WriteFile(groupf, []byte(`
+- name: a
+- name: a
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadGroupFile(groupf, base); err == nil {
+		t.Fatal("duplicate group names should fail")
+	}
+}
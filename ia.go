@@ -0,0 +1,165 @@
+// ia
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+
+	"github.com/hujun-open/myaddr"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// naHint returns the address hint of n-th IA_NA, which is the expanded nahint plus n
+func (ccfg *clientConfig) naHint(n int) (net.IP, error) {
+	if ccfg.setup.NAHint == "" {
+		return nil, nil
+	}
+	s := genStrFromTemplate(ccfg.setup.NAHint, ccfg.vars)
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil {
+		return nil, fmt.Errorf("%v is not a valid IPv6 address hint", s)
+	}
+	return myaddr.IncAddr(ip, big.NewInt(int64(n)))
+}
+
+// pdHint returns the prefix hint of n-th IA_PD, which is the n-th prefix after the expanded pdhint;
+// if pdhint is not specified but pdlen is, then the hint is ::/pdlen
+func (ccfg *clientConfig) pdHint(n int) (*net.IPNet, error) {
+	if ccfg.setup.PDHint == "" {
+		if ccfg.setup.PDLenHint == 0 {
+			return nil, nil
+		}
+		return &net.IPNet{
+			IP:   net.IPv6zero,
+			Mask: net.CIDRMask(int(ccfg.setup.PDLenHint), 128),
+		}, nil
+	}
+	s := genStrFromTemplate(ccfg.setup.PDHint, ccfg.vars)
+	ip, prefix, err := net.ParseCIDR(s)
+	if err != nil || ip.To4() != nil {
+		return nil, fmt.Errorf("%v is not a valid IPv6 prefix hint", s)
+	}
+	plen, _ := prefix.Mask.Size()
+	step := new(big.Int).Lsh(big.NewInt(int64(n)), uint(128-plen))
+	if prefix.IP, err = myaddr.IncAddr(prefix.IP, step); err != nil {
+		return nil, err
+	}
+	return prefix, nil
+}
+
+// expectedPDLen returns the expected length of delegated prefix, 0 means any length
+func (ccfg *clientConfig) expectedPDLen() int {
+	if ccfg.setup.PDLenHint != 0 {
+		return int(ccfg.setup.PDLenHint)
+	}
+	if ccfg.setup.PDHint != "" {
+		if _, prefix, err := net.ParseCIDR(genStrFromTemplate(ccfg.setup.PDHint, ccfg.vars)); err == nil {
+			plen, _ := prefix.Mask.Size()
+			return plen
+		}
+	}
+	return 0
+}
+
+// iaOptions returns all IA_NA and IA_PD options the client requests
+func (ccfg *clientConfig) iaOptions() ([]dhcpv6.Option, error) {
+	r := []dhcpv6.Option{}
+	if ccfg.setup.NeedNA {
+		for i := 0; i < int(ccfg.setup.NumOfNA); i++ {
+			na := &dhcpv6.OptIANA{IaId: ccfg.setup.IAID.iaid(ccfg.vars, false, i)}
+			hint, err := ccfg.naHint(i)
+			if err != nil {
+				return nil, err
+			}
+			if hint != nil {
+				na.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: hint})
+			}
+			r = append(r, na)
+		}
+	}
+	if ccfg.setup.NeedPD {
+		for i := 0; i < int(ccfg.setup.NumOfPD); i++ {
+			pd := &dhcpv6.OptIAPD{IaId: ccfg.setup.IAID.iaid(ccfg.vars, true, i)}
+			hint, err := ccfg.pdHint(i)
+			if err != nil {
+				return nil, err
+			}
+			if hint != nil {
+				pd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: hint})
+			}
+			r = append(r, pd)
+		}
+	}
+	return r, nil
+}
+
+// v6StatusError is returned when the server rejects the request with a status code
+type v6StatusError struct {
+	where  string //e.g. "IA_NA 00000001", empty means the status is of the whole message
+	status *dhcpv6.OptStatusCode
+}
+
+func (se *v6StatusError) Error() string {
+	if se.where == "" {
+		return fmt.Sprintf("request is rejected, %v", se.status)
+	}
+	return fmt.Sprintf("%v is not assigned, %v", se.where, se.status)
+}
+
+// checkIAs returns error if any IA the client requests doesn't get a valid binding in msg
+func (ccfg *clientConfig) checkIAs(msg *dhcpv6.Message) error {
+	if status := msg.Options.Status(); status != nil && status.StatusCode != iana.StatusSuccess {
+		return &v6StatusError{status: status}
+	}
+	if ccfg.setup.NeedNA {
+		nas := make(map[[4]byte]*dhcpv6.OptIANA)
+		for _, na := range msg.Options.IANA() {
+			nas[na.IaId] = na
+		}
+		for i := 0; i < int(ccfg.setup.NumOfNA); i++ {
+			iaid := ccfg.setup.IAID.iaid(ccfg.vars, false, i)
+			na, ok := nas[iaid]
+			if !ok {
+				return fmt.Errorf("IA_NA %x is not in the response", iaid)
+			}
+			if status := na.Options.Status(); status != nil && status.StatusCode != iana.StatusSuccess {
+				return &v6StatusError{where: fmt.Sprintf("IA_NA %x", iaid), status: status}
+			}
+			if len(na.Options.Addresses()) == 0 {
+				return fmt.Errorf("no address is assigned in IA_NA %x", iaid)
+			}
+		}
+	}
+	if ccfg.setup.NeedPD {
+		pds := make(map[[4]byte]*dhcpv6.OptIAPD)
+		for _, pd := range msg.Options.IAPD() {
+			pds[pd.IaId] = pd
+		}
+		expectedLen := ccfg.expectedPDLen()
+		for i := 0; i < int(ccfg.setup.NumOfPD); i++ {
+			iaid := ccfg.setup.IAID.iaid(ccfg.vars, true, i)
+			pd, ok := pds[iaid]
+			if !ok {
+				return fmt.Errorf("IA_PD %x is not in the response", iaid)
+			}
+			if status := pd.Options.Status(); status != nil && status.StatusCode != iana.StatusSuccess {
+				return &v6StatusError{where: fmt.Sprintf("IA_PD %x", iaid), status: status}
+			}
+			prefixes := pd.Options.Prefixes()
+			if len(prefixes) == 0 {
+				return fmt.Errorf("no prefix is assigned in IA_PD %x", iaid)
+			}
+			for _, p := range prefixes {
+				if p.Prefix == nil {
+					return fmt.Errorf("empty prefix in IA_PD %x", iaid)
+				}
+				if plen, _ := p.Prefix.Mask.Size(); expectedLen != 0 && plen != expectedLen {
+					return fmt.Errorf("prefix %v in IA_PD %x is not /%d", p.Prefix, iaid, expectedLen)
+				}
+			}
+		}
+	}
+	return nil
+}
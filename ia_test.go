@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestIAOptions(t *testing.T) {
+	setup := newDefaultConf()
+	setup.NeedPD = true
+	setup.NumOfNA = 2
+	setup.NumOfPD = 2
+	setup.NAHint = "2001:db8::@{ID:x}00"
+	setup.PDHint = "2001:db8:@{ID:x}00::/56"
+	ccfg := &clientConfig{setup: setup, vars: templateVars{ID: 1}}
+	iaList, err := ccfg.iaOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(iaList) != 4 {
+		t.Fatalf("expect 4 IAs, got %d", len(iaList))
+	}
+	if hint := iaList[1].(*dhcpv6.OptIANA).Options.Addresses()[0].IPv6Addr; !hint.Equal(net.ParseIP("2001:db8::101")) {
+		t.Fatalf("wrong IA_NA hint %v", hint)
+	}
+	if hint := iaList[3].(*dhcpv6.OptIAPD).Options.Prefixes()[0].Prefix; hint.String() != "2001:db8:100:100::/56" {
+		t.Fatalf("wrong IA_PD hint %v", hint)
+	}
+	//reply with all IAs bound
+	reply, _ := dhcpv6.NewMessage()
+	for i, ia := range iaList {
+		switch o := ia.(type) {
+		case *dhcpv6.OptIANA:
+			reply.AddOption(o)
+		case *dhcpv6.OptIAPD:
+			_, prefix, _ := net.ParseCIDR("2001:db8:ff00::/56")
+			if i == 3 {
+				_, prefix, _ = net.ParseCIDR("2001:db8:ff00::/60")
+			}
+			reply.AddOption(&dhcpv6.OptIAPD{
+				IaId:    o.IaId,
+				Options: dhcpv6.PDOptions{Options: dhcpv6.Options{&dhcpv6.OptIAPrefix{Prefix: prefix}}},
+			})
+		}
+	}
+	if err = ccfg.checkIAs(reply); err == nil {
+		t.Fatal("prefix with wrong length should fail")
+	}
+	setup.PDHint = ""
+	if err = ccfg.checkIAs(reply); err != nil {
+		t.Fatal(err)
+	}
+	setup.NumOfNA = 3
+	if err = ccfg.checkIAs(reply); err == nil {
+		t.Fatal("missing IA_NA should fail")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"sync"
 
 	"github.com/hujun-open/dhcplt/common"
+	"github.com/hujun-open/dhcplt/dhcpv6relay"
 	"github.com/hujun-open/etherconn"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
@@ -126,6 +127,15 @@ type v6Lease struct {
 	Type                      dhcpv6.MessageType //rely or solicit
 	VLANList                  etherconn.VLANs
 	IDOptions, RelayIDOptions dhcpv6.Options
+	LinkAddr                  net.IP //link-address of DHCPv6 relay, nil if not relayed
+	RelayHops                 []dhcpv6relay.Hop
+}
+
+// v6HopExport is the exported format of dhcpv6relay.Hop, since dhcpv6.Options can't be encoded by gob
+type v6HopExport struct {
+	LinkAddr, PeerAddr net.IP
+	HopCount           int
+	OptionsBytes       []byte
 }
 
 type v6LeaseExport struct {
@@ -134,6 +144,8 @@ type v6LeaseExport struct {
 	Type                                dhcpv6.MessageType //rely or solicit
 	VLANList                            etherconn.VLANs
 	IDOptionsBytes, RelayIDOptionsBytes []byte
+	LinkAddr                            net.IP
+	RelayHops                           []v6HopExport
 }
 
 func (lease v6Lease) MarshalBinary() ([]byte, error) {
@@ -144,6 +156,15 @@ func (lease v6Lease) MarshalBinary() ([]byte, error) {
 		VLANList:            lease.VLANList,
 		IDOptionsBytes:      lease.IDOptions.ToBytes(),
 		RelayIDOptionsBytes: lease.RelayIDOptions.ToBytes(),
+		LinkAddr:            lease.LinkAddr,
+	}
+	for _, h := range lease.RelayHops {
+		export.RelayHops = append(export.RelayHops, v6HopExport{
+			LinkAddr:     h.LinkAddr,
+			PeerAddr:     h.PeerAddr,
+			HopCount:     h.HopCount,
+			OptionsBytes: h.Options.ToBytes(),
+		})
 	}
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -161,6 +182,7 @@ func (lease *v6Lease) UnmarshalBinary(b []byte) error {
 	lease.MAC = export.MAC
 	lease.Type = export.Type
 	lease.VLANList = export.VLANList
+	lease.LinkAddr = export.LinkAddr
 
 	err = (&lease.ReplyOptions).FromBytes(export.ReplyOptionsBytes)
 	if err != nil {
@@ -174,6 +196,14 @@ func (lease *v6Lease) UnmarshalBinary(b []byte) error {
 	if err != nil {
 		return err
 	}
+	lease.RelayHops = nil
+	for _, h := range export.RelayHops {
+		hop := dhcpv6relay.Hop{LinkAddr: h.LinkAddr, PeerAddr: h.PeerAddr, HopCount: h.HopCount}
+		if err = (&hop.Options).FromBytes(h.OptionsBytes); err != nil {
+			return err
+		}
+		lease.RelayHops = append(lease.RelayHops, hop)
+	}
 	return nil
 }
 
@@ -216,6 +246,31 @@ func (lease *v6Lease) Genv6Release(mt dhcpv6.MessageType) (*dhcpv6.Message, erro
 	return msg, nil
 }
 
+// Genv6Reboot returns the message to verify the lease after reboot, without server id:
+// Rebind if the lease has IA_PD, otherwise Confirm
+func (lease *v6Lease) Genv6Reboot() (*dhcpv6.Message, error) {
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg.MessageType = dhcpv6.MessageTypeConfirm
+	if len(lease.ReplyOptions.Get(dhcpv6.OptionIAPD)) > 0 {
+		msg.MessageType = dhcpv6.MessageTypeRebind
+	}
+	msg.AddOption(lease.ReplyOptions.GetOne(dhcpv6.OptionClientID))
+	msg.AddOption(dhcpv6.OptElapsedTime(0))
+	for _, na := range lease.ReplyOptions.Get(dhcpv6.OptionIANA) {
+		msg.AddOption(na)
+	}
+	for _, pd := range lease.ReplyOptions.Get(dhcpv6.OptionIAPD) {
+		msg.AddOption(pd)
+	}
+	for _, o := range lease.IDOptions {
+		msg.UpdateOption(o)
+	}
+	return msg, nil
+}
+
 func (lease *v6Lease) Apply(ifname string, apply bool) error {
 	link, err := netlink.LinkByName(ifname)
 	if err != nil {
@@ -6,6 +6,7 @@ import (
 	// "fmt"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -17,7 +18,9 @@ type L2Encap struct {
 	Vlans  etherconn.VLANs
 }
 
+// NDPProxy answers NS of DHCPv6 client link-local addresses, and ARP request of leased DHCPv4 addresses
 type NDPProxy struct {
+	lock    *sync.RWMutex
 	targets map[string]L2Encap //key is stringify IP
 	relay   etherconn.PacketRelay
 	econn   *etherconn.EtherConn
@@ -26,6 +29,7 @@ type NDPProxy struct {
 func NewNDPProxyFromRelay(targets map[string]L2Encap, relay etherconn.PacketRelay) *NDPProxy {
 	r := new(NDPProxy)
 	r.relay = relay
+	r.lock = new(sync.RWMutex)
 	r.targets = targets
 	r.econn = etherconn.NewEtherConn(net.HardwareAddr{0, 0, 0, 0, 0, 0},
 		r.relay, etherconn.WithDefault())
@@ -37,7 +41,10 @@ func (proxy *NDPProxy) processReq(pbuf []byte, peermac net.HardwareAddr) {
 	gpkt := gopacket.NewPacket(pbuf, layers.LayerTypeIPv6, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
 	if icmp6Layer := gpkt.Layer(layers.LayerTypeICMPv6NeighborSolicitation); icmp6Layer != nil {
 		req := icmp6Layer.(*layers.ICMPv6NeighborSolicitation)
-		if l2ep, ok := proxy.targets[req.TargetAddress.String()]; ok {
+		proxy.lock.RLock()
+		l2ep, ok := proxy.targets[req.TargetAddress.String()]
+		proxy.lock.RUnlock()
+		if ok {
 			peerIPlayer := gpkt.Layer(layers.LayerTypeIPv6)
 			resp := &layers.ICMPv6NeighborAdvertisement{
 				TargetAddress: req.TargetAddress,
@@ -75,12 +82,67 @@ func (proxy *NDPProxy) processReq(pbuf []byte, peermac net.HardwareAddr) {
 
 	}
 }
+
+// processARP answers ARP request of target ip
+func (proxy *NDPProxy) processARP(pbuf []byte, peermac net.HardwareAddr) {
+	gpkt := gopacket.NewPacket(pbuf, layers.LayerTypeARP, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	arpLayer := gpkt.Layer(layers.LayerTypeARP)
+	if arpLayer == nil {
+		return
+	}
+	req := arpLayer.(*layers.ARP)
+	if req.Operation != layers.ARPRequest {
+		return
+	}
+	proxy.lock.RLock()
+	l2ep, ok := proxy.targets[net.IP(req.DstProtAddress).String()]
+	proxy.lock.RUnlock()
+	if !ok {
+		return
+	}
+	resp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPReply,
+		SourceHwAddress:   l2ep.HwAddr,
+		SourceProtAddress: req.DstProtAddress,
+		DstHwAddress:      req.SourceHwAddress,
+		DstProtAddress:    req.SourceProtAddress,
+	}
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, resp)
+	_, err := proxy.econn.WritePktToFrom(buf.Bytes(), EthernetTypeARP, l2ep.HwAddr, peermac, l2ep.Vlans)
+	if err != nil {
+		log.Printf("failed to send ARP resp, %v", err)
+	}
+}
+
+// add adds target ip with l2 encap
+func (proxy *NDPProxy) add(ip string, l2ep L2Encap) {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	proxy.targets[ip] = l2ep
+}
+
+// remove removes target ip
+func (proxy *NDPProxy) remove(ip string) {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	delete(proxy.targets, ip)
+}
+
 func (proxy *NDPProxy) recv() {
 	for {
 		pkt, remote, err := proxy.econn.ReadPkt()
 		if err != nil {
 			log.Fatalf("failed from recv, %v", err)
 		}
+		if remote.Etype == EthernetTypeARP {
+			go proxy.processARP(pkt, remote.HwAddr)
+			continue
+		}
 		go proxy.processReq(pkt, remote.HwAddr)
 	}
 
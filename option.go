@@ -0,0 +1,328 @@
+// option
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+type optValueType int
+
+const (
+	optValString optValueType = iota
+	optValHex
+	optValIPv4
+	optValIPv6
+	optValUint8
+	optValUint16
+	optValUint32
+	optValBool
+	optValDomainList
+	optValNested
+)
+
+var optValTypeNames = map[optValueType]string{
+	optValString:     "string",
+	optValHex:        "hex",
+	optValIPv4:       "ipv4",
+	optValIPv6:       "ipv6",
+	optValUint8:      "uint8",
+	optValUint16:     "uint16",
+	optValUint32:     "uint32",
+	optValBool:       "bool",
+	optValDomainList: "domain-list",
+	optValNested:     "nested",
+}
+
+func (vt optValueType) String() string {
+	if s, ok := optValTypeNames[vt]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown option value type %d", int(vt))
+}
+
+func parseOptValueType(s string) (optValueType, bool) {
+	for t, name := range optValTypeNames {
+		if name == strings.ToLower(s) {
+			return t, true
+		}
+	}
+	return optValString, false
+}
+
+// message names a custom option could be restricted to
+const (
+	optMsgDiscover = "discover"
+	optMsgSolicit  = "solicit"
+	optMsgRequest  = "request"
+	optMsgRelay    = "relay"
+)
+
+// customOption is a user specified DHCPv4 or DHCPv6 option,
+// the text format is "<code>[/<msg>,<msg>...]:<type>:<value>", e.g. "60/discover:string:dhcplt-@ID";
+// value is a template, see templateVarNames;
+// for nested type, value is a list of sub-options in braces, e.g. "43:nested:{1:string:abc;2:ipv4:1.1.1.1}";
+// the legacy format "<code>:<value>" is treated as string type.
+type customOption struct {
+	Code  uint16
+	Msgs  []string
+	Type  optValueType
+	Value string
+	subs  []customOption
+}
+
+func (co customOption) MarshalText() ([]byte, error) {
+	r := strconv.Itoa(int(co.Code))
+	if len(co.Msgs) > 0 {
+		r += "/" + strings.Join(co.Msgs, ",")
+	}
+	r += fmt.Sprintf(":%v:%v", co.Type, co.Value)
+	return []byte(r), nil
+}
+
+func (co *customOption) UnmarshalText(text []byte) error {
+	strList := strings.SplitN(string(text), ":", 3)
+	if len(strList) < 2 {
+		return fmt.Errorf("invalid custom option %v", string(text))
+	}
+	codeStr, msgStr, hasMsgs := strings.Cut(strList[0], "/")
+	code, err := strconv.ParseUint(codeStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("%v is not a valid option code", codeStr)
+	}
+	r := customOption{
+		Code:  uint16(code),
+		Type:  optValString,
+		Value: strings.Join(strList[1:], ":"),
+	}
+	if hasMsgs {
+		for _, m := range strings.Split(msgStr, ",") {
+			r.Msgs = append(r.Msgs, strings.ToLower(strings.TrimSpace(m)))
+		}
+	}
+	if len(strList) == 3 {
+		if t, ok := parseOptValueType(strList[1]); ok {
+			r.Type = t
+			r.Value = strList[2]
+		}
+	}
+	if r.Type == optValNested {
+		inner := strings.TrimSpace(r.Value)
+		if !strings.HasPrefix(inner, "{") || !strings.HasSuffix(inner, "}") {
+			return fmt.Errorf("nested option value must be enclosed in braces, got %v", r.Value)
+		}
+		for _, s := range splitOptionList(inner[1 : len(inner)-1]) {
+			sub := customOption{}
+			if err = sub.UnmarshalText([]byte(s)); err != nil {
+				return fmt.Errorf("invalid sub-option of option %d, %w", r.Code, err)
+			}
+			r.subs = append(r.subs, sub)
+		}
+	}
+	*co = r
+	return nil
+}
+
+// splitOptionList splits s by ';', except those enclosed in braces
+func splitOptionList(s string) (r []string) {
+	depth := 0
+	start := 0
+	for i, c := range s {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ';':
+			if depth == 0 {
+				if strings.TrimSpace(s[start:i]) != "" {
+					r = append(r, strings.TrimSpace(s[start:i]))
+				}
+				start = i + 1
+			}
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		r = append(r, strings.TrimSpace(s[start:]))
+	}
+	return
+}
+
+// validate checks the option could be encoded for the specified stack
+func (co customOption) validate(isV6 bool) error {
+	if co.Code == 0 {
+		return fmt.Errorf("option code can't be zero")
+	}
+	if !isV6 && co.Code > 254 {
+		return fmt.Errorf("%d is not a valid DHCPv4 option code", co.Code)
+	}
+	for _, m := range co.Msgs {
+		switch m {
+		case optMsgRequest:
+		case optMsgDiscover:
+			if isV6 {
+				return fmt.Errorf("%v is not a DHCPv6 message", m)
+			}
+		case optMsgSolicit, optMsgRelay:
+			if !isV6 {
+				return fmt.Errorf("%v is not a DHCPv4 message", m)
+			}
+		default:
+			return fmt.Errorf("unknown message %v", m)
+		}
+	}
+	_, err := co.encode(isV6, templateVars{})
+	return err
+}
+
+// appliesTo returns true if the option should be included in msg,
+// an option without message list is included in both discover/solicit and request
+func (co customOption) appliesTo(msg string) bool {
+	if len(co.Msgs) == 0 {
+		return msg != optMsgRelay
+	}
+	for _, m := range co.Msgs {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+// encode returns the option value in wire format for the client with vars
+func (co customOption) encode(isV6 bool, vars templateVars) ([]byte, error) {
+	val, err := expandTemplate(co.Value, vars)
+	if err != nil {
+		return nil, err
+	}
+	switch co.Type {
+	case optValString:
+		return []byte(val), nil
+	case optValHex:
+		val = strings.NewReplacer(":", "", " ", "", "-", "").Replace(strings.TrimPrefix(val, "0x"))
+		return hex.DecodeString(val)
+	case optValIPv4, optValIPv6:
+		var r []byte
+		for _, s := range strings.Split(val, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if co.Type == optValIPv4 {
+				if ip == nil || ip.To4() == nil {
+					return nil, fmt.Errorf("%v is not a valid IPv4 address", s)
+				}
+				r = append(r, ip.To4()...)
+			} else {
+				if ip == nil || ip.To4() != nil {
+					return nil, fmt.Errorf("%v is not a valid IPv6 address", s)
+				}
+				r = append(r, ip.To16()...)
+			}
+		}
+		return r, nil
+	case optValUint8, optValUint16, optValUint32:
+		bitSize := map[optValueType]int{optValUint8: 8, optValUint16: 16, optValUint32: 32}[co.Type]
+		n, err := strconv.ParseUint(val, 0, bitSize)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a valid %v", val, co.Type)
+		}
+		r := make([]byte, 4)
+		binary.BigEndian.PutUint32(r, uint32(n))
+		return r[4-bitSize/8:], nil
+	case optValBool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a valid bool", val)
+		}
+		if b {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case optValDomainList:
+		labels := &rfc1035label.Labels{}
+		for _, s := range strings.Split(val, ",") {
+			labels.Labels = append(labels.Labels, strings.TrimSpace(s))
+		}
+		return labels.ToBytes(), nil
+	case optValNested:
+		var r []byte
+		for _, sub := range co.subs {
+			subVal, err := sub.encode(isV6, vars)
+			if err != nil {
+				return nil, err
+			}
+			if isV6 {
+				hdr := make([]byte, 4)
+				binary.BigEndian.PutUint16(hdr[:2], sub.Code)
+				binary.BigEndian.PutUint16(hdr[2:], uint16(len(subVal)))
+				r = append(r, hdr...)
+			} else {
+				if sub.Code > 255 || len(subVal) > 255 {
+					return nil, fmt.Errorf("sub-option %d of option %d is too big", sub.Code, co.Code)
+				}
+				r = append(r, byte(sub.Code), byte(len(subVal)))
+			}
+			r = append(r, subVal...)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported option value type %v", co.Type)
+}
+
+func (co customOption) v4Option(vars templateVars) (dhcpv4.Option, error) {
+	val, err := co.encode(false, vars)
+	if err != nil {
+		return dhcpv4.Option{}, fmt.Errorf("failed to encode DHCPv4 option %d, %w", co.Code, err)
+	}
+	return dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(co.Code), val), nil
+}
+
+// validateRadiusAttr checks attr could be encoded as a RADIUS attribute
+func validateRadiusAttr(attr customOption) error {
+	if attr.Code == 0 || attr.Code > 255 {
+		return fmt.Errorf("%d is not a valid RADIUS attribute type", attr.Code)
+	}
+	if len(attr.Msgs) > 0 {
+		return fmt.Errorf("message list is not supported for RADIUS attribute")
+	}
+	_, err := radiusOption([]customOption{attr}, templateVars{})
+	return err
+}
+
+// radiusOption returns the DHCPv6 RADIUS option (RFC7037) contains attrs for the client with vars,
+// attribute type is the option code
+func radiusOption(attrs []customOption, vars templateVars) (dhcpv6.Option, error) {
+	var r []byte
+	for _, attr := range attrs {
+		val, err := attr.encode(false, vars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode RADIUS attribute %d, %w", attr.Code, err)
+		}
+		if len(val) > 253 {
+			return nil, fmt.Errorf("RADIUS attribute %d is too big", attr.Code)
+		}
+		r = append(r, byte(attr.Code), byte(len(val)+2))
+		r = append(r, val...)
+	}
+	return &dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionRadius,
+		OptionData: r,
+	}, nil
+}
+
+func (co customOption) v6Option(vars templateVars) (dhcpv6.Option, error) {
+	val, err := co.encode(true, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode DHCPv6 option %d, %w", co.Code, err)
+	}
+	return &dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionCode(co.Code),
+		OptionData: val,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCustomOption(t *testing.T) {
+	testList := []struct {
+		input      string
+		isV6       bool
+		id         int
+		expected   []byte
+		shouldFail bool
+	}{
+		//case 0, legacy format
+		{
+			input:    "60:dhcplt",
+			expected: []byte("dhcplt"),
+		},
+		//case 1
+		{
+			input:    "60/discover:string:clnt-@ID",
+			id:       3,
+			expected: []byte("clnt-3"),
+		},
+		//case 2, value contains colon
+		{
+			input:    "82:hex:01:02:0a",
+			expected: []byte{1, 2, 10},
+		},
+		//case 3
+		{
+			input:    "6:ipv4:1.1.1.1,2.2.2.2",
+			expected: []byte{1, 1, 1, 1, 2, 2, 2, 2},
+		},
+		//case 4
+		{
+			input:    "100:uint16:1000",
+			expected: []byte{0x03, 0xe8},
+		},
+		//case 5
+		{
+			input:    "100:bool:true",
+			expected: []byte{1},
+		},
+		//case 6
+		{
+			input:    "119:domain-list:a.com",
+			expected: []byte{1, 'a', 3, 'c', 'o', 'm', 0},
+		},
+		//case 7
+		{
+			input:    "43:nested:{1:string:ab;2:nested:{3:uint8:7}}",
+			expected: []byte{1, 2, 'a', 'b', 2, 3, 3, 1, 7},
+		},
+		//case 8
+		{
+			input:    "17:nested:{1:uint8:7}",
+			isV6:     true,
+			expected: []byte{0, 1, 0, 1, 7},
+		},
+		//case 9
+		{
+			input:      "100:uint8:300",
+			shouldFail: true,
+		},
+		//case 10
+		{
+			input:      "300:string:abc",
+			shouldFail: true,
+		},
+		//case 11
+		{
+			input:      "60/solicit:string:abc",
+			shouldFail: true,
+		},
+		//case 12
+		{
+			input:      "43:nested:1:string:ab",
+			shouldFail: true,
+		},
+	}
+	for i, c := range testList {
+		co := new(customOption)
+		err := co.UnmarshalText([]byte(c.input))
+		if err == nil {
+			err = co.validate(c.isV6)
+		}
+		if err != nil {
+			if c.shouldFail {
+				t.Logf("case %d failed as expected, %v", i, err)
+				continue
+			}
+			t.Fatalf("case %d failed, %v", i, err)
+		}
+		if c.shouldFail {
+			t.Fatalf("case %d succeed but should fail", i)
+		}
+		val, err := co.encode(c.isV6, templateVars{ID: c.id})
+		if err != nil {
+			t.Fatalf("case %d failed to encode, %v", i, err)
+		}
+		if !bytes.Equal(val, c.expected) {
+			t.Fatalf("case %d expect %v but got %v", i, c.expected, val)
+		}
+	}
+}
+
+func TestRadiusOption(t *testing.T) {
+	attrs := []customOption{}
+	for _, s := range []string{"1:string:user-@ID", "4:ipv4:192.0.2.1"} {
+		co := customOption{}
+		if err := co.UnmarshalText([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		if err := validateRadiusAttr(co); err != nil {
+			t.Fatal(err)
+		}
+		attrs = append(attrs, co)
+	}
+	op, err := radiusOption(attrs, templateVars{ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{1, 8}, []byte("user-7")...)
+	expected = append(expected, 4, 6, 192, 0, 2, 1)
+	if !bytes.Equal(op.ToBytes(), expected) {
+		t.Fatalf("expect %v got %v", expected, op.ToBytes())
+	}
+	for _, s := range []string{"256:string:abc", "1/relay:string:abc"} {
+		co := customOption{}
+		if err := co.UnmarshalText([]byte(s)); err == nil {
+			if err = validateRadiusAttr(co); err == nil {
+				t.Fatalf("%v should be invalid", s)
+			}
+		}
+	}
+}
@@ -0,0 +1,386 @@
+// pool
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// ipRange is an inclusive range of IP addresses, text format is "<start>-<end>" or "<prefix>/<len>"
+type ipRange struct {
+	Start, End netip.Addr
+}
+
+func (ir ipRange) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%v-%v", ir.Start, ir.End)), nil
+}
+
+func (ir *ipRange) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("%v is not a valid prefix, %w", s, err)
+		}
+		prefix = prefix.Masked()
+		ir.Start = prefix.Addr()
+		ir.End = lastAddr(prefix)
+		return nil
+	}
+	startStr, endStr, found := strings.Cut(s, "-")
+	if !found {
+		return fmt.Errorf("%v is not a valid address range", s)
+	}
+	start, err := netip.ParseAddr(strings.TrimSpace(startStr))
+	if err != nil {
+		return fmt.Errorf("invalid start address of %v, %w", s, err)
+	}
+	end, err := netip.ParseAddr(strings.TrimSpace(endStr))
+	if err != nil {
+		return fmt.Errorf("invalid end address of %v, %w", s, err)
+	}
+	if start.Is4() != end.Is4() || end.Less(start) {
+		return fmt.Errorf("%v is not a valid address range", s)
+	}
+	ir.Start, ir.End = start, end
+	return nil
+}
+
+func (ir ipRange) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.Is4() == ir.Start.Is4() && !addr.Less(ir.Start) && !ir.End.Less(addr)
+}
+
+// lastAddr returns the last address of prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	buf := prefix.Masked().Addr().AsSlice()
+	for i := prefix.Bits(); i < len(buf)*8; i++ {
+		buf[i/8] |= 0x80 >> (i % 8)
+	}
+	r, _ := netip.AddrFromSlice(buf)
+	return r
+}
+
+// pdPool is an expected IA_PD pool, text format is "<aggregate prefix>/<len>[:<delegated len>]",
+// e.g. "2001:db8::/40:56"; delegated len 0 means any length
+type pdPool struct {
+	Aggregate netip.Prefix
+	Len       int
+}
+
+func (pp pdPool) MarshalText() ([]byte, error) {
+	if pp.Len == 0 {
+		return []byte(pp.Aggregate.String()), nil
+	}
+	return []byte(fmt.Sprintf("%v:%d", pp.Aggregate, pp.Len)), nil
+}
+
+func (pp *pdPool) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	slash := strings.Index(s, "/")
+	if slash < 0 {
+		return fmt.Errorf("%v is not a valid PD pool", s)
+	}
+	r := pdPool{}
+	if colon := strings.LastIndex(s, ":"); colon > slash {
+		l, err := strconv.Atoi(s[colon+1:])
+		if err != nil {
+			return fmt.Errorf("invalid delegated prefix length in %v", s)
+		}
+		r.Len = l
+		s = s[:colon]
+	}
+	var err error
+	if r.Aggregate, err = netip.ParsePrefix(s); err != nil {
+		return fmt.Errorf("%v is not a valid prefix, %w", s, err)
+	}
+	if r.Aggregate.Addr().Is4() {
+		return fmt.Errorf("%v is not an IPv6 prefix", s)
+	}
+	if r.Len != 0 && (r.Len < r.Aggregate.Bits() || r.Len > 128) {
+		return fmt.Errorf("delegated prefix length %d is not valid for %v", r.Len, r.Aggregate)
+	}
+	r.Aggregate = r.Aggregate.Masked()
+	*pp = r
+	return nil
+}
+
+func (pp pdPool) contains(prefix netip.Prefix) bool {
+	return prefix.Bits() >= pp.Aggregate.Bits() && pp.Aggregate.Contains(prefix.Addr())
+}
+
+// leaseCheckResult is the number of violations found in a lease
+type leaseCheckResult struct {
+	OutOfPool      int
+	WrongPrefixLen int
+	Duplicate      int
+}
+
+func (lcr leaseCheckResult) failed() bool {
+	return lcr.OutOfPool+lcr.WrongPrefixLen+lcr.Duplicate > 0
+}
+
+// prefixLen is a prefix length of an address family
+type prefixLen struct {
+	is4  bool
+	bits int
+}
+
+func lenOf(p netip.Prefix) prefixLen {
+	return prefixLen{is4: p.Addr().Is4(), bits: p.Bits()}
+}
+
+// leaseValidator detects addresses and prefixes assigned to more than one client across the whole run,
+// including a prefix overlapping one of another client, e.g. a /60 within a /56, or an IA_NA address within an IA_PD prefix
+type leaseValidator struct {
+	lock   *sync.Mutex
+	owners map[netip.Prefix]clientID
+	//lens is the lengths of registered prefixes, a length is indexed in inside once it is registered
+	lens map[prefixLen]bool
+	//inside is the number of registered prefixes of each owner within a prefix of an indexed length
+	inside map[netip.Prefix]map[clientID]int
+}
+
+func newLeaseValidator() *leaseValidator {
+	return &leaseValidator{
+		lock:   new(sync.Mutex),
+		owners: make(map[netip.Prefix]clientID),
+		lens:   make(map[prefixLen]bool),
+		inside: make(map[netip.Prefix]map[clientID]int),
+	}
+}
+
+// index adds delta to the number of prefixes of owner within p
+func (lv *leaseValidator) index(p netip.Prefix, owner clientID, delta int) {
+	m, ok := lv.inside[p]
+	if !ok {
+		m = make(map[clientID]int)
+		lv.inside[p] = m
+	}
+	m[owner] += delta
+	if m[owner] <= 0 {
+		delete(m, owner)
+	}
+	if len(m) == 0 {
+		delete(lv.inside, p)
+	}
+}
+
+// indexAll updates index of registered prefix p of owner with delta, for all indexed lengths shorter than p
+func (lv *leaseValidator) indexAll(p netip.Prefix, owner clientID, delta int) {
+	l := lenOf(p)
+	for ol := range lv.lens {
+		if ol.is4 == l.is4 && ol.bits < l.bits {
+			lv.index(netip.PrefixFrom(p.Addr(), ol.bits).Masked(), owner, delta)
+		}
+	}
+}
+
+// overlap returns the owner of a registered prefix overlapping p, other than id
+func (lv *leaseValidator) overlap(id clientID, p netip.Prefix) (clientID, bool) {
+	l := lenOf(p)
+	//p or a prefix containing p
+	for ol := range lv.lens {
+		if ol.is4 == l.is4 && ol.bits <= l.bits {
+			if owner, ok := lv.owners[netip.PrefixFrom(p.Addr(), ol.bits).Masked()]; ok && owner != id {
+				return owner, true
+			}
+		}
+	}
+	//prefixes within p
+	if lv.lens[l] {
+		for owner := range lv.inside[p] {
+			if owner != id {
+				return owner, true
+			}
+		}
+		return "", false
+	}
+	//length of p is not indexed yet
+	for q, owner := range lv.owners {
+		if owner != id && p.Overlaps(q) {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+// register records p is assigned to id, return the other owner if p overlaps a prefix assigned to another client
+func (lv *leaseValidator) register(id clientID, p netip.Prefix) (clientID, bool) {
+	lv.lock.Lock()
+	defer lv.lock.Unlock()
+	if owner, ok := lv.overlap(id, p); ok {
+		return owner, true
+	}
+	if _, ok := lv.owners[p]; ok {
+		return "", false
+	}
+	l := lenOf(p)
+	if !lv.lens[l] {
+		lv.lens[l] = true
+		for q, owner := range lv.owners {
+			if ql := lenOf(q); ql.is4 == l.is4 && ql.bits > l.bits {
+				lv.index(netip.PrefixFrom(q.Addr(), l.bits).Masked(), owner, 1)
+			}
+		}
+	}
+	lv.owners[p] = id
+	lv.indexAll(p, id, 1)
+	return "", false
+}
+
+// unregister removes p if it is assigned to id
+func (lv *leaseValidator) unregister(id clientID, p netip.Prefix) {
+	lv.lock.Lock()
+	defer lv.lock.Unlock()
+	if owner, ok := lv.owners[p]; ok && owner == id {
+		delete(lv.owners, p)
+		lv.indexAll(p, id, -1)
+	}
+}
+
+// v4LeasePrefixes returns assigned address of the v4 lease as a /32 prefix
+func (dc *DClient) v4LeasePrefixes() []netip.Prefix {
+	if dc.d4Lease == nil || dc.d4Lease.Lease == nil {
+		return nil
+	}
+	addr, ok := netip.AddrFromSlice(dc.d4Lease.Lease.ACK.YourIPAddr.To4())
+	if !ok {
+		return nil
+	}
+	return []netip.Prefix{netip.PrefixFrom(addr, 32)}
+}
+
+// v6LeasePrefixes returns all assigned IA_NA addresses as /128 prefix, and delegated prefixes of the v6 lease
+func (dc *DClient) v6LeasePrefixes() (nas, pds []netip.Prefix) {
+	if dc.d6Lease == nil {
+		return
+	}
+	for _, na := range dc.d6Lease.ReplyOptions.Get(dhcpv6.OptionIANA) {
+		for _, addr := range na.(*dhcpv6.OptIANA).Options.Addresses() {
+			if a, ok := netip.AddrFromSlice(addr.IPv6Addr.To16()); ok {
+				nas = append(nas, netip.PrefixFrom(a, 128))
+			}
+		}
+	}
+	for _, pd := range dc.d6Lease.ReplyOptions.Get(dhcpv6.OptionIAPD) {
+		for _, prefix := range pd.(*dhcpv6.OptIAPD).Options.Prefixes() {
+			if p, ok := ipNetToPrefix(prefix.Prefix); ok {
+				pds = append(pds, p)
+			}
+		}
+	}
+	return
+}
+
+func ipNetToPrefix(ipnet *net.IPNet) (netip.Prefix, bool) {
+	if ipnet == nil {
+		return netip.Prefix{}, false
+	}
+	addr, ok := netip.AddrFromSlice(ipnet.IP.To16())
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	plen, _ := ipnet.Mask.Size()
+	return netip.PrefixFrom(addr, plen).Masked(), true
+}
+
+func inRanges(addr netip.Addr, ranges []ipRange) bool {
+	for _, r := range ranges {
+		if r.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// register registers p of dc into validator, return true if p is a duplicate
+func (dc *DClient) registerLease(p netip.Prefix) bool {
+	if dc.validator == nil {
+		return false
+	}
+	if owner, dup := dc.validator.register(dc.id, p); dup {
+		common.MyLog("%v assigned to %v is already assigned to %v", p, dc.id, owner)
+		return true
+	}
+	return false
+}
+
+// checkV4Lease checks the v4 lease of dc against expected pools and leases of other clients
+func (dc *DClient) checkV4Lease() (r leaseCheckResult) {
+	for _, p := range dc.v4LeasePrefixes() {
+		if len(dc.cfg.setup.V4Pools) > 0 && !inRanges(p.Addr(), dc.cfg.setup.V4Pools) {
+			common.MyLog("%v assigned to %v is out of v4 pools", p.Addr(), dc.id)
+			r.OutOfPool++
+		}
+		if dc.registerLease(p) {
+			r.Duplicate++
+		}
+	}
+	return
+}
+
+// checkV6Lease checks the v6 lease of dc against expected pools and leases of other clients
+func (dc *DClient) checkV6Lease() (r leaseCheckResult) {
+	nas, pds := dc.v6LeasePrefixes()
+	for _, p := range nas {
+		if len(dc.cfg.setup.NAPools) > 0 && !inRanges(p.Addr(), dc.cfg.setup.NAPools) {
+			common.MyLog("%v assigned to %v is out of IA_NA pools", p.Addr(), dc.id)
+			r.OutOfPool++
+		}
+		if dc.registerLease(p) {
+			r.Duplicate++
+		}
+	}
+	for _, p := range pds {
+		if len(dc.cfg.setup.PDPools) > 0 {
+			var pool *pdPool
+			for i := range dc.cfg.setup.PDPools {
+				if dc.cfg.setup.PDPools[i].contains(p) {
+					pool = &dc.cfg.setup.PDPools[i]
+					break
+				}
+			}
+			switch {
+			case pool == nil:
+				common.MyLog("%v delegated to %v is out of PD pools", p, dc.id)
+				r.OutOfPool++
+			case pool.Len != 0 && pool.Len != p.Bits():
+				common.MyLog("%v delegated to %v is not /%d", p, dc.id, pool.Len)
+				r.WrongPrefixLen++
+			}
+		}
+		if dc.registerLease(p) {
+			r.Duplicate++
+		}
+	}
+	return
+}
+
+// unregisterV4Lease removes the v4 lease of dc from validator
+func (dc *DClient) unregisterV4Lease() {
+	if dc.validator == nil {
+		return
+	}
+	for _, p := range dc.v4LeasePrefixes() {
+		dc.validator.unregister(dc.id, p)
+	}
+}
+
+// unregisterV6Lease removes the v6 lease of dc from validator
+func (dc *DClient) unregisterV6Lease() {
+	if dc.validator == nil {
+		return
+	}
+	nas, pds := dc.v6LeasePrefixes()
+	for _, p := range append(nas, pds...) {
+		dc.validator.unregister(dc.id, p)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	var r ipRange
+	if err := r.UnmarshalText([]byte("192.0.2.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	if r.End != netip.MustParseAddr("192.0.2.255") || !r.contains(netip.MustParseAddr("192.0.2.10")) {
+		t.Fatalf("wrong range %v", r)
+	}
+	if err := r.UnmarshalText([]byte("2001:db8::10-2001:db8::20")); err != nil {
+		t.Fatal(err)
+	}
+	if r.contains(netip.MustParseAddr("2001:db8::21")) || !r.contains(netip.MustParseAddr("2001:db8::20")) {
+		t.Fatalf("wrong range %v", r)
+	}
+	if err := r.UnmarshalText([]byte("2001:db8::20-2001:db8::10")); err == nil {
+		t.Fatal("reversed range should fail")
+	}
+	var pp pdPool
+	if err := pp.UnmarshalText([]byte("2001:db8::/40:56")); err != nil {
+		t.Fatal(err)
+	}
+	if pp.Len != 56 || !pp.contains(netip.MustParsePrefix("2001:db8:ff:ff00::/56")) || pp.contains(netip.MustParsePrefix("2001:db9::/56")) {
+		t.Fatalf("wrong PD pool %+v", pp)
+	}
+	if err := pp.UnmarshalText([]byte("2001:db8::/40:32")); err == nil {
+		t.Fatal("delegated length shorter than aggregate should fail")
+	}
+	lv := newLeaseValidator()
+	p := netip.MustParsePrefix("192.0.2.1/32")
+	if _, dup := lv.register("a", p); dup {
+		t.Fatal("first register should not be duplicate")
+	}
+	if _, dup := lv.register("a", p); dup {
+		t.Fatal("same client register should not be duplicate")
+	}
+	if owner, dup := lv.register("b", p); !dup || owner != "a" {
+		t.Fatal("different client register should be duplicate")
+	}
+	lv.unregister("a", p)
+	if _, dup := lv.register("b", p); dup {
+		t.Fatal("register after release should not be duplicate")
+	}
+	for i, c := range []struct {
+		id     clientID
+		prefix string
+		owner  clientID //expected other owner, empty if no overlap
+	}{
+		{"a", "2001:db8:1::/60", ""},
+		//containing a /60 of another client
+		{"b", "2001:db8:1::/56", "a"},
+		{"b", "2001:db8:2::/56", ""},
+		//within a /56 of another client
+		{"a", "2001:db8:2:10::/60", "b"},
+		//IA_NA address within IA_PD prefix of another client
+		{"a", "2001:db8:2:1::1/128", "b"},
+		//IA_NA address within own IA_PD prefix
+		{"b", "2001:db8:2:1::1/128", ""},
+		{"c", "2001:db8:1:10::1/128", ""},
+		//containing addresses of two other clients
+		{"d", "2001:db8::/40", "a|c"},
+		//v6 doesn't overlap v4 192.0.2.1 of b
+		{"d", "::c000:201/128", ""},
+		//within v4 /24 of d
+		{"d", "192.0.2.0/24", "b"},
+	} {
+		owner, dup := lv.register(c.id, netip.MustParsePrefix(c.prefix))
+		if dup != (c.owner != "") || (dup && !strings.Contains(string(c.owner), string(owner))) {
+			t.Fatalf("case %d expect overlapping owner %q, got %q", i, c.owner, owner)
+		}
+	}
+	lv.unregister("a", netip.MustParsePrefix("2001:db8:1::/60"))
+	if owner, dup := lv.register("b", netip.MustParsePrefix("2001:db8:1::/56")); !dup || owner != "c" {
+		t.Fatalf("/56 containing address of c should overlap, got %q", owner)
+	}
+	lv.unregister("c", netip.MustParsePrefix("2001:db8:1:10::1/128"))
+	if _, dup := lv.register("b", netip.MustParsePrefix("2001:db8:1::/56")); dup {
+		t.Fatal("register after release should not overlap")
+	}
+}
@@ -0,0 +1,206 @@
+// reboot
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// rebootClntTimeout is the timeout of clients used for reboot,
+// the timeout of each transmission is enforced by retransmit via context instead
+const rebootClntTimeout = time.Hour
+
+// retransTimeout returns the timeout of n-th transmission (starting from 0),
+// which is base doubled for each retransmission, then randomized by +/- jitter percent
+func retransTimeout(base time.Duration, n int, jitter uint) time.Duration {
+	t := base << uint(n)
+	if jitter == 0 {
+		return t
+	}
+	j := int64(t) * int64(jitter) / 100
+	return t - time.Duration(j) + time.Duration(rand.Int63n(2*j+1))
+}
+
+// retransmit calls send until it succeeds or has been called retry times,
+// each call is bounded by the timeout returned from retransTimeout
+func (dc *DClient) retransmit(ctx context.Context, send func(ctx context.Context) error) (err error) {
+	for i := 0; i == 0 || i < int(dc.cfg.setup.Retry); i++ {
+		sctx, cancel := context.WithTimeout(ctx, retransTimeout(dc.cfg.setup.Timeout, i, dc.cfg.setup.RetransJitter))
+		err = send(sctx)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+// newV4Request returns a broadcast DHCPREQUEST with identities of dc's lease, without server id,
+// mods are applied after that
+func (dc *DClient) newV4Request(mods ...dhcpv4.Modifier) (*dhcpv4.DHCPv4, error) {
+	modList := []dhcpv4.Modifier{
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(dc.d4Lease.Lease.ACK.ClientHWAddr),
+		dhcpv4.WithBroadcast(true),
+		dhcpv4.WithGatewayIP(dc.cfg.setup.GiAddr.AsSlice()),
+	}
+	for t := range dc.d4Lease.IDOptions {
+		modList = append(modList,
+			dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(t),
+				dc.d4Lease.IDOptions.Get(dhcpv4.GenericOptionCode(t)))))
+	}
+	return dhcpv4.New(append(modList, mods...)...)
+}
+
+// rebootv4 does DHCPv4 INIT-REBOOT, which broadcasts a request with the address of saved lease in option 50,
+// without server id
+func (dc *DClient) rebootv4(ctx context.Context, wg *sync.WaitGroup) error {
+	common.MyLog("rebooting v4 for %v", dc.id)
+	if wg != nil {
+		defer wg.Done()
+	}
+	if dc.d4Lease == nil {
+		return nil
+	}
+	result := new(dialResult)
+	result.action = actionReboot
+	result.ExecResult = resultFailure
+	result.StartTime = time.Now()
+	result.IsDHCPv6 = false
+	result.L2EP = dc.id
+	defer func() {
+		result.FinishTime = time.Now()
+		dc.sendResult(result)
+	}()
+	req, err := dc.newV4Request(dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(dc.d4Lease.Lease.ACK.YourIPAddr)))
+	if err != nil {
+		return fmt.Errorf("failed to create INIT-REBOOT request for %v, %w", dc.id, err)
+	}
+	var resp *dhcpv4.DHCPv4
+	err = dc.retransmit(ctx, func(sctx context.Context) (err error) {
+		resp, err = dc.d4OtherClnt.SendAndRead(sctx, dc.d4OtherClnt.RemoteAddr(), req,
+			nclient4.IsMessageType(dhcpv4.MessageTypeAck, dhcpv4.MessageTypeNak))
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reboot v4 for %v, %w", dc.id, err)
+	}
+	if resp.MessageType() == dhcpv4.MessageTypeNak {
+		return fmt.Errorf("got NAK for INIT-REBOOT request of %v, %v", dc.id, resp.Message())
+	}
+	dc.d4Lease.Lease.ACK = resp
+	dc.d4Lease.Lease.CreationTime = time.Now()
+	result.RuleViolations = dc.checkV4Rules(ruleMsgAck, resp)
+	if len(result.RuleViolations) > 0 {
+		return fmt.Errorf("DHCPv4 server messages for %v violate option rules %v", dc.id, result.RuleViolations)
+	}
+	result.ExecResult = resultSuccess
+	return nil
+}
+
+// rebootv6 verifies the saved v6 lease after reboot, via Confirm if the lease only has IA_NA,
+// or via Rebind if the lease has IA_PD (RFC8415 section 18.2.12)
+func (dc *DClient) rebootv6(ctx context.Context, wg *sync.WaitGroup) error {
+	common.MyLog("rebooting v6 for %v", dc.id)
+	if wg != nil {
+		defer wg.Done()
+	}
+	if dc.d6Lease == nil {
+		return nil
+	}
+	result := new(dialResult)
+	result.action = actionReboot
+	result.ExecResult = resultFailure
+	result.StartTime = time.Now()
+	result.IsDHCPv6 = true
+	result.L2EP = dc.id
+	defer func() {
+		result.FinishTime = time.Now()
+		dc.sendResult(result)
+	}()
+	msg, err := dc.d6Lease.Genv6Reboot()
+	if err != nil {
+		return fmt.Errorf("failed to create v6 reboot msg for %v, %w", dc.id, err)
+	}
+	var reply *dhcpv6.Message
+	err = dc.retransmit(ctx, func(sctx context.Context) (err error) {
+		reply, err = dc.d6OtherClnt.SendAndRead(sctx,
+			nclient6.AllDHCPRelayAgentsAndServers, msg,
+			nclient6.IsMessageType(dhcpv6.MessageTypeReply))
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("failed to recv reply of %v for %v, %w", msg.MessageType, dc.id, err)
+	}
+	if err = checkLeaseReply(msg.MessageType, reply); err != nil {
+		return fmt.Errorf("%v of %v is rejected, %w", msg.MessageType, dc.id, err)
+	}
+	if msg.MessageType == dhcpv6.MessageTypeRebind {
+		dc.d6Lease.ReplyOptions = reply.Options.Options
+	}
+	result.RuleViolations = dc.checkV6Rules(ruleMsgReply, reply)
+	if len(result.RuleViolations) > 0 {
+		return fmt.Errorf("DHCPv6 server messages for %v violate option rules %v", dc.id, result.RuleViolations)
+	}
+	result.ExecResult = resultSuccess
+	return nil
+}
+
+// checkLeaseReply returns error if reply to a Confirm, Renew or Rebind doesn't confirm the lease
+func checkLeaseReply(mt dhcpv6.MessageType, reply *dhcpv6.Message) error {
+	if status := reply.Options.Status(); status != nil && status.StatusCode != iana.StatusSuccess {
+		return fmt.Errorf("%v", status)
+	}
+	if mt == dhcpv6.MessageTypeConfirm {
+		return nil
+	}
+	nas, pds := reply.Options.IANA(), reply.Options.IAPD()
+	if len(nas)+len(pds) == 0 {
+		return fmt.Errorf("no IA in the reply")
+	}
+	for _, na := range nas {
+		if status := na.Options.Status(); status != nil && status.StatusCode != iana.StatusSuccess {
+			return fmt.Errorf("IA_NA %x is rejected, %v", na.IaId, status)
+		}
+		if len(na.Options.Addresses()) == 0 {
+			return fmt.Errorf("no address in IA_NA %x", na.IaId)
+		}
+	}
+	for _, pd := range pds {
+		if status := pd.Options.Status(); status != nil && status.StatusCode != iana.StatusSuccess {
+			return fmt.Errorf("IA_PD %x is rejected, %v", pd.IaId, status)
+		}
+		if len(pd.Options.Prefixes()) == 0 {
+			return fmt.Errorf("no prefix in IA_PD %x", pd.IaId)
+		}
+	}
+	return nil
+}
+
+// reboundTime returns the duration from begin until pct percent of total were rebound,
+// finishes is the finish time of each successful reboot;
+// return false if pct percent of total were never rebound
+func reboundTime(begin time.Time, finishes []time.Time, total int, pct uint) (time.Duration, bool) {
+	need := (total*int(pct) + 99) / 100
+	if need == 0 {
+		return 0, true
+	}
+	if need > len(finishes) {
+		return 0, false
+	}
+	sorted := make([]time.Time, len(finishes))
+	copy(sorted, finishes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	return sorted[need-1].Sub(begin), true
+}
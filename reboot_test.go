@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestReboot(t *testing.T) {
+	if d := retransTimeout(time.Second, 2, 0); d != 4*time.Second {
+		t.Fatalf("timeout without jitter should be 4s, got %v", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := retransTimeout(time.Second, 1, 10); d < 1800*time.Millisecond || d > 2200*time.Millisecond {
+			t.Fatalf("timeout %v is out of jitter range", d)
+		}
+	}
+	begin := time.Now()
+	finishes := []time.Time{begin.Add(3 * time.Second), begin.Add(time.Second), begin.Add(2 * time.Second)}
+	if d, ok := reboundTime(begin, finishes, 4, 50); !ok || d != 2*time.Second {
+		t.Fatalf("50%% should be rebound at 2s, got %v %v", d, ok)
+	}
+	if d, ok := reboundTime(begin, finishes, 4, 75); !ok || d != 3*time.Second {
+		t.Fatalf("75%% should be rebound at 3s, got %v %v", d, ok)
+	}
+	if _, ok := reboundTime(begin, finishes, 4, 100); ok {
+		t.Fatal("100% should never be rebound")
+	}
+	reply, _ := dhcpv6.NewMessage()
+	reply.MessageType = dhcpv6.MessageTypeReply
+	if err := checkLeaseReply(dhcpv6.MessageTypeConfirm, reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLeaseReply(dhcpv6.MessageTypeRebind, reply); err == nil {
+		t.Fatal("rebind reply without IA should fail")
+	}
+	reply.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNotOnLink})
+	if err := checkLeaseReply(dhcpv6.MessageTypeConfirm, reply); err == nil {
+		t.Fatal("confirm reply with NotOnLink should fail")
+	}
+}
+
+func TestRebootSummary(t *testing.T) {
+	setup := newDefaultConf()
+	setup.Action = actionReboot
+	setup.ReboundPct = []uint{50, 100}
+	rs := newResultSummary(setup)
+	begin := time.Now()
+	for i, er := range []execResult{resultSuccess, resultFailure} {
+		rs.add(&dialResult{
+			action:     actionReboot,
+			ExecResult: er,
+			StartTime:  begin,
+			FinishTime: begin.Add(time.Duration(i+1) * time.Second),
+		})
+	}
+	if rs.Rebooted != 1 || rs.Failed != 1 {
+		t.Fatalf("expect 1 successful and 1 failed reboot, got %d and %d", rs.Rebooted, rs.Failed)
+	}
+	//failed reboot counts in the population
+	s := rs.String()
+	if !strings.Contains(s, "Time to 50% rebound:1s") || !strings.Contains(s, "Time to 100% rebound:never") {
+		t.Fatalf("wrong rebound time in summary\n%v", s)
+	}
+}
@@ -0,0 +1,222 @@
+// reconfigure
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// reconfigureStats counts DHCPv6 Reconfigure events of all clients
+type reconfigureStats struct {
+	Received    atomic.Uint64
+	Invalid     atomic.Uint64 //dropped due to no lease, mismatched ids or failed authentication
+	Renew       atomic.Uint64
+	Rebind      atomic.Uint64
+	InfoRequest atomic.Uint64
+	Failed      atomic.Uint64 //no valid reply for the response
+}
+
+func (rs *reconfigureStats) String() string {
+	r := fmt.Sprintf("DHCPv6 Reconfigure received:%d\n", rs.Received.Load())
+	r += fmt.Sprintf("DHCPv6 Reconfigure invalid:%d\n", rs.Invalid.Load())
+	r += fmt.Sprintf("DHCPv6 Reconfigure renew:%d\n", rs.Renew.Load())
+	r += fmt.Sprintf("DHCPv6 Reconfigure rebind:%d\n", rs.Rebind.Load())
+	r += fmt.Sprintf("DHCPv6 Reconfigure information-request:%d\n", rs.InfoRequest.Load())
+	r += fmt.Sprintf("DHCPv6 Reconfigure failed response:%d\n", rs.Failed.Load())
+	return r
+}
+
+// reconfState is the Reconfigure Key authentication state of a DHCPv6 client, RFC8415 section 20.4
+type reconfState struct {
+	lock *sync.Mutex
+	key  []byte //reconfigure key from server, nil if not received
+	rd   uint64 //last replay detection value
+}
+
+func newReconfState() *reconfState {
+	return &reconfState{lock: new(sync.Mutex)}
+}
+
+// update saves the reconfigure key in reply if there is one
+func (rs *reconfState) update(reply *dhcpv6.Message) {
+	auth, err := v6AuthOption(reply.Options.Options)
+	if err != nil || auth == nil || auth.Protocol != authProtoReconfigureKey {
+		return
+	}
+	if len(auth.Info) != 1+authDigestLen || auth.Info[0] != reconfKeyTypeKey {
+		return
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.key = auth.Info[1:]
+	rs.rd = auth.ReplayDetection
+}
+
+// validate checks raw is a valid Reconfigure for lease, returns the message type client should respond with
+func (rs *reconfState) validate(raw []byte, lease *v6Lease) (dhcpv6.MessageType, error) {
+	msg, err := dhcpv6.MessageFromBytes(raw)
+	if err != nil {
+		return 0, err
+	}
+	if msg.MessageType != dhcpv6.MessageTypeReconfigure {
+		return 0, fmt.Errorf("%v is not a Reconfigure", msg.MessageType)
+	}
+	for _, code := range []dhcpv6.OptionCode{dhcpv6.OptionServerID, dhcpv6.OptionClientID} {
+		expected, got := lease.ReplyOptions.GetOne(code), msg.Options.GetOne(code)
+		if got == nil || expected == nil || !bytes.Equal(got.ToBytes(), expected.ToBytes()) {
+			return 0, fmt.Errorf("%v doesn't match the lease", code)
+		}
+	}
+	op := msg.Options.GetOne(dhcpv6.OptionReconfMessage)
+	if op == nil || len(op.ToBytes()) != 1 {
+		return 0, fmt.Errorf("invalid or missing reconfigure message option")
+	}
+	mt := dhcpv6.MessageType(op.ToBytes()[0])
+	switch mt {
+	case dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeInformationRequest:
+	default:
+		return 0, fmt.Errorf("unsupported reconfigure message type %v", mt)
+	}
+	auth, err := v6AuthOption(msg.Options.Options)
+	if err != nil {
+		return 0, err
+	}
+	if auth == nil {
+		return 0, fmt.Errorf("no authentication option")
+	}
+	if auth.Protocol != authProtoReconfigureKey || auth.Algorithm != authAlgHMACMD5 || auth.RDM != authRDMMonotonic {
+		return 0, fmt.Errorf("unsupported authentication protocol %d algorithm %d RDM %d", auth.Protocol, auth.Algorithm, auth.RDM)
+	}
+	if len(auth.Info) != 1+authDigestLen || auth.Info[0] != reconfKeyTypeHMAC {
+		return 0, fmt.Errorf("authentication information is not HMAC-MD5 digest")
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.key == nil {
+		return 0, fmt.Errorf("no reconfigure key from server")
+	}
+	if auth.ReplayDetection <= rs.rd {
+		return 0, fmt.Errorf("replay detection %d is not bigger than %d", auth.ReplayDetection, rs.rd)
+	}
+	if err = verifyV6(raw, rs.key); err != nil {
+		return 0, err
+	}
+	rs.rd = auth.ReplayDetection
+	return mt, nil
+}
+
+// listenReconfigure handles Reconfigure received by dc until its reconfigure conn is closed
+func (dc *DClient) listenReconfigure() {
+	buf := make([]byte, maxDHCPv6Size)
+	for {
+		n, _, err := dc.reconfConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		dc.reconfStats.Received.Add(1)
+		lease := dc.d6Lease
+		if lease == nil {
+			common.MyLog("%v drops a Reconfigure without lease", dc.id)
+			dc.reconfStats.Invalid.Add(1)
+			continue
+		}
+		mt, err := dc.reconf.validate(buf[:n], lease)
+		if err != nil {
+			common.MyLog("%v drops an invalid Reconfigure, %v", dc.id, err)
+			dc.reconfStats.Invalid.Add(1)
+			continue
+		}
+		if err = dc.respondReconfigure(lease, mt); err != nil {
+			common.MyLog("%v failed to respond Reconfigure with %v, %v", dc.id, mt, err)
+			dc.reconfStats.Failed.Add(1)
+			continue
+		}
+		switch mt {
+		case dhcpv6.MessageTypeRenew:
+			dc.reconfStats.Renew.Add(1)
+		case dhcpv6.MessageTypeRebind:
+			dc.reconfStats.Rebind.Add(1)
+		default:
+			dc.reconfStats.InfoRequest.Add(1)
+		}
+	}
+}
+
+// respondReconfigure sends message of type mt for lease and waits for the reply,
+// lease is updated with the reply of Renew or Rebind;
+// the exchange is reported as a dial result, Information-Request is reported as renew
+func (dc *DClient) respondReconfigure(lease *v6Lease, mt dhcpv6.MessageType) error {
+	result := new(dialResult)
+	result.action = actionRenew
+	if mt == dhcpv6.MessageTypeRebind {
+		result.action = actionRebind
+	}
+	result.ExecResult = resultFailure
+	result.StartTime = time.Now()
+	result.IsDHCPv6 = true
+	result.L2EP = dc.id
+	defer func() {
+		result.FinishTime = time.Now()
+		dc.sendResult(result)
+	}()
+	var msg *dhcpv6.Message
+	var err error
+	switch mt {
+	case dhcpv6.MessageTypeInformationRequest:
+		if msg, err = dhcpv6.NewMessage(); err != nil {
+			return err
+		}
+		msg.MessageType = mt
+		msg.AddOption(lease.ReplyOptions.GetOne(dhcpv6.OptionClientID))
+		msg.AddOption(lease.ReplyOptions.GetOne(dhcpv6.OptionServerID))
+		msg.AddOption(dhcpv6.OptElapsedTime(0))
+	default:
+		if msg, err = lease.Genv6Release(mt); err != nil {
+			return err
+		}
+		if mt == dhcpv6.MessageTypeRebind {
+			msg.Options.Del(dhcpv6.OptionServerID)
+		}
+	}
+	msg.AddOption(optReconfAccept())
+	reply, err := dc.d6.SendAndRead(context.Background(),
+		nclient6.AllDHCPRelayAgentsAndServers, msg,
+		nclient6.IsMessageType(dhcpv6.MessageTypeReply))
+	if err != nil {
+		return err
+	}
+	if mt == dhcpv6.MessageTypeInformationRequest {
+		if status := reply.Options.Status(); status != nil && status.StatusCode != iana.StatusSuccess {
+			return fmt.Errorf("%v", status)
+		}
+	} else {
+		if err = checkLeaseReply(mt, reply); err != nil {
+			return err
+		}
+		//addresses may change with the reply
+		dc.proxyV6Lease(false)
+		lease.ReplyOptions = reply.Options.Options
+		dc.proxyV6Lease(true)
+	}
+	dc.reconf.update(reply)
+	result.RuleViolations = dc.checkV6Rules(ruleMsgReply, reply)
+	if len(result.RuleViolations) > 0 {
+		return fmt.Errorf("reply violates option rules %v", result.RuleViolations)
+	}
+	result.ExecResult = resultSuccess
+	return nil
+}
+
+// optReconfAccept returns a Reconfigure Accept option
+func optReconfAccept() dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfAccept}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// genReconfigure returns a Reconfigure signed with key
+func genReconfigure(t *testing.T, svrID, clntID dhcpv6.DUID, mt dhcpv6.MessageType, rd uint64, key []byte) []byte {
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.MessageType = dhcpv6.MessageTypeReconfigure
+	msg.TransactionID = dhcpv6.TransactionID{}
+	msg.AddOption(dhcpv6.OptServerID(svrID))
+	msg.AddOption(dhcpv6.OptClientID(clntID))
+	msg.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfMessage, OptionData: []byte{byte(mt)}})
+	auth := &authOption{
+		Protocol:        authProtoReconfigureKey,
+		Algorithm:       authAlgHMACMD5,
+		RDM:             authRDMMonotonic,
+		ReplayDetection: rd,
+		Info:            append([]byte{reconfKeyTypeHMAC}, make([]byte, authDigestLen)...),
+	}
+	msg.AddOption(auth.v6Option())
+	raw := msg.ToBytes()
+	if err = signV6(raw, key); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestReconfigure(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, authDigestLen)
+	svrID := &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{1, 1, 1, 1, 1, 1}}
+	clntID := &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}}
+	reply, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.MessageType = dhcpv6.MessageTypeReply
+	reply.AddOption(dhcpv6.OptServerID(svrID))
+	reply.AddOption(dhcpv6.OptClientID(clntID))
+	reply.AddOption((&authOption{
+		Protocol:        authProtoReconfigureKey,
+		Algorithm:       authAlgHMACMD5,
+		ReplayDetection: 1,
+		Info:            append([]byte{reconfKeyTypeKey}, key...),
+	}).v6Option())
+	lease := &v6Lease{ReplyOptions: reply.Options.Options}
+	rs := newReconfState()
+	if _, err = rs.validate(genReconfigure(t, svrID, clntID, dhcpv6.MessageTypeRenew, 2, key), lease); err == nil {
+		t.Fatal("Reconfigure before receiving reconfigure key should fail")
+	}
+	rs.update(reply)
+	if !bytes.Equal(rs.key, key) || rs.rd != 1 {
+		t.Fatalf("wrong reconfigure key %x or replay detection %d", rs.key, rs.rd)
+	}
+	otherID := &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{9, 9, 9, 9, 9, 9}}
+	for i, c := range []struct {
+		raw      []byte
+		expected dhcpv6.MessageType //0 means invalid
+	}{
+		{genReconfigure(t, svrID, clntID, dhcpv6.MessageTypeRenew, 2, key), dhcpv6.MessageTypeRenew},
+		//replayed
+		{genReconfigure(t, svrID, clntID, dhcpv6.MessageTypeRenew, 2, key), 0},
+		{genReconfigure(t, svrID, clntID, dhcpv6.MessageTypeRebind, 3, []byte("wrong key")), 0},
+		{genReconfigure(t, otherID, clntID, dhcpv6.MessageTypeRebind, 3, key), 0},
+		{genReconfigure(t, svrID, otherID, dhcpv6.MessageTypeRebind, 3, key), 0},
+		{genReconfigure(t, svrID, clntID, dhcpv6.MessageTypeSolicit, 3, key), 0},
+		{genReconfigure(t, svrID, clntID, dhcpv6.MessageTypeRebind, 3, key), dhcpv6.MessageTypeRebind},
+		{genReconfigure(t, svrID, clntID, dhcpv6.MessageTypeInformationRequest, 10, key), dhcpv6.MessageTypeInformationRequest},
+	} {
+		mt, err := rs.validate(c.raw, lease)
+		if c.expected == 0 {
+			if err == nil {
+				t.Fatalf("case %d should fail", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d failed, %v", i, err)
+		}
+		if mt != c.expected {
+			t.Fatalf("case %d expect %v got %v", i, c.expected, mt)
+		}
+	}
+}
+
+// newLeaseFileSched returns a Sched of action act with an empty lease file
+func newLeaseFileSched(t *testing.T, act actionType) *Sched {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(make(exportLeaseMap)); err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(t.TempDir(), "leases")
+	if err := os.WriteFile(f, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setup := newDefaultConf()
+	setup.Action = act
+	setup.LeaseFile = f
+	sch, err := NewSched(setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sch
+}
+
+func TestReconfigureStatsWithLeaseFile(t *testing.T) {
+	for _, act := range []actionType{actionRelease, actionRenew, actionRebind, actionReboot} {
+		if sch := newLeaseFileSched(t, act); sch.reconfStats == nil {
+			t.Fatalf("reconfigure stats of action %v is nil", act)
+		}
+	}
+}
+
+func TestReconfigureNDP(t *testing.T) {
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	addr := net.ParseIP("2001:db8::100")
+	reply, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.MessageType = dhcpv6.MessageTypeReply
+	reply.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{
+		&dhcpv6.OptIAAddress{IPv6Addr: addr, PreferredLifetime: 3600, ValidLifetime: 3600},
+	}}})
+	ndp := &NDPProxy{lock: new(sync.RWMutex), targets: make(map[string]L2Encap)}
+	dc := &DClient{
+		cfg:     &clientConfig{Mac: mac, setup: newDefaultConf()},
+		d6Lease: &v6Lease{ReplyOptions: reply.Options.Options},
+		arp:     ndp,
+	}
+	dc.proxyV6Lease(true)
+	if l2, ok := ndp.targets[addr.String()]; !ok || !bytes.Equal(l2.HwAddr, mac) {
+		t.Fatalf("NS of leased address is not answered, %v", ndp.targets)
+	}
+	//churn of the client removes the address
+	dc.leave(false)
+	if _, ok := ndp.targets[addr.String()]; ok {
+		t.Fatal("NS of address of left client is still answered")
+	}
+}
@@ -0,0 +1,327 @@
+// relay
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hujun-open/dhcplt/dhcpv4relay"
+	"github.com/hujun-open/dhcplt/dhcpv6relay"
+	"github.com/hujun-open/etherconn"
+	"github.com/hujun-open/myaddr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/server6"
+	"golang.org/x/net/ipv6"
+)
+
+// relayConnType is the network side conn type of action relay
+type relayConnType int
+
+const (
+	relayConnKernel relayConnType = iota
+	relayConnEtherConn
+)
+
+var relayConnTypeNames = map[relayConnType]string{
+	relayConnKernel:    "kernel",
+	relayConnEtherConn: "etherconn",
+}
+
+func (rct relayConnType) String() string {
+	buf, err := rct.MarshalText()
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+func (rct relayConnType) MarshalText() (text []byte, err error) {
+	if s, ok := relayConnTypeNames[rct]; ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unknown relay conn type %d", rct)
+}
+
+func (rct *relayConnType) UnmarshalText(text []byte) error {
+	for t, name := range relayConnTypeNames {
+		if name == strings.ToLower(string(text)) {
+			*rct = t
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown relay conn type %v", string(text))
+}
+
+// validateRelay checks settings of action relay
+func (setup *testSetup) validateRelay() error {
+	if setup.AccessIf == "" {
+		return fmt.Errorf("accessif is required by action relay")
+	}
+	if setup.AccessIf == setup.Ifname {
+		return fmt.Errorf("accessif must be different from interface %v", setup.Ifname)
+	}
+	if _, err := net.InterfaceByName(setup.AccessIf); err != nil {
+		return fmt.Errorf("can't find access interface %v, %w", setup.AccessIf, err)
+	}
+	if setup.ClientFile != "" || setup.GroupFile != "" {
+		return fmt.Errorf("clientfile and groupfile can't be used with action relay")
+	}
+	if setup.EnableV4 {
+		if setup.GiAddr.IsUnspecified() {
+			return fmt.Errorf("giaddr is required by DHCPv4 relay")
+		}
+	}
+	for _, svr := range setup.V4Servers {
+		if !svr.Is4() {
+			return fmt.Errorf("DHCPv4 server %v is not an IPv4 address", svr)
+		}
+	}
+	if setup.EnableV6 && (len(setup.RelayHops) > 0 || setup.LDRA) {
+		return fmt.Errorf("relayhop and ldra can't be used with action relay")
+	}
+	return nil
+}
+
+// v6ClientMAC returns client MAC from link-layer address in duid, or the modified EUI-64 interface-id of peer;
+// nil if neither is available
+func v6ClientMAC(duid dhcpv6.DUID, peer net.IP) net.HardwareAddr {
+	switch d := duid.(type) {
+	case *dhcpv6.DUIDLL:
+		return d.LinkLayerAddr
+	case *dhcpv6.DUIDLLT:
+		return d.LinkLayerAddr
+	}
+	if ip := peer.To16(); ip != nil && ip[11] == 0xff && ip[12] == 0xfe {
+		return net.HardwareAddr{ip[8] ^ 0x2, ip[9], ip[10], ip[13], ip[14], ip[15]}
+	}
+	return nil
+}
+
+// relayClients generates relay options of real clients of action relay from templates,
+// a client is identified by its MAC, or DUID if MAC is unknown, and is indexed in order of first seen
+type relayClients struct {
+	setup   *testSetup
+	lock    *sync.Mutex
+	clients map[string]*clientConfig
+}
+
+func newRelayClients(setup *testSetup) *relayClients {
+	return &relayClients{
+		setup:   setup,
+		lock:    new(sync.Mutex),
+		clients: make(map[string]*clientConfig),
+	}
+}
+
+// get returns the client of key, a new client with mac is generated if it doesn't exist
+func (rc *relayClients) get(key string, mac net.HardwareAddr) (*clientConfig, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if ccfg, ok := rc.clients[key]; ok {
+		return ccfg, nil
+	}
+	ccfg := &clientConfig{Mac: mac, setup: rc.setup}
+	if err := ccfg.genOptions(rc.setup.clientIdentity(), templateVars{ID: len(rc.clients), MAC: mac}); err != nil {
+		return nil, err
+	}
+	rc.clients[key] = ccfg
+	return ccfg, nil
+}
+
+// v4Options returns option 82 of the client sending req
+func (rc *relayClients) v4Options(req *dhcpv4.DHCPv4) ([]dhcpv4.Option, error) {
+	ccfg, err := rc.get(req.ClientHWAddr.String(), req.ClientHWAddr)
+	if err != nil {
+		return nil, err
+	}
+	r := []dhcpv4.Option{}
+	for _, op := range ccfg.V4Options {
+		if op.Code.Code() == dhcpv4.OptionRelayAgentInformation.Code() {
+			r = append(r, op)
+		}
+	}
+	return r, nil
+}
+
+// v6Params returns link-address and relay options of the client sending msg from peer,
+// MAC of the client is 00:00:00:00:00:00 if it can't be derived from DUID or peer
+func (rc *relayClients) v6Params(msg *dhcpv6.Message, peer net.IP) (net.IP, dhcpv6.Options, error) {
+	duid := msg.Options.ClientID()
+	mac := v6ClientMAC(duid, peer)
+	key := mac.String()
+	if mac == nil {
+		mac = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+		key = "duid-" + hex.EncodeToString(duid.ToBytes())
+	}
+	ccfg, err := rc.get(key, mac)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ccfg.V6LinkAddr, ccfg.V6RelayOptions, nil
+}
+
+// v4SvrAddrs returns the server addresses DHCPv4 relay sends to
+func (setup *testSetup) v4SvrAddrs() []*net.UDPAddr {
+	if len(setup.V4Servers) == 0 {
+		return []*net.UDPAddr{{IP: net.IPv4bcast, Port: dhcpv4.ServerPort}}
+	}
+	r := []*net.UDPAddr{}
+	for _, svr := range setup.V4Servers {
+		r = append(r, &net.UDPAddr{IP: svr.AsSlice(), Port: dhcpv4.ServerPort})
+	}
+	return r
+}
+
+// relayV4NetworkConn returns the network side conn of DHCPv4 relay with giaddr as source address,
+// proxy answers ARP of giaddr if it is etherconn
+func (setup *testSetup) relayV4NetworkConn(proxy *NDPProxy) (net.PacketConn, error) {
+	if setup.RelayConn == relayConnEtherConn {
+		econn := etherconn.NewEtherConn(setup.StartMAC, setup.pktRelay,
+			etherconn.WithVLANs(setup.StartVLANs),
+			etherconn.WithEtherTypes([]uint16{EthernetTypeIPv4}))
+		rudpconn, err := etherconn.NewRUDPConn(fmt.Sprintf("%v:%v", setup.GiAddr, dhcpv4.ServerPort), econn, etherconn.WithAcceptAny(true))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create raw udp conn for relay, %w", err)
+		}
+		proxy.add(setup.GiAddr.String(), L2Encap{HwAddr: setup.StartMAC, Vlans: setup.StartVLANs})
+		return rudpconn, nil
+	}
+	return server4.NewIPv4UDPConn(setup.Ifname, &net.UDPAddr{IP: setup.GiAddr.AsSlice(), Port: dhcpv4.ServerPort})
+}
+
+// relayV6NetworkConn returns the network side conn of DHCPv6 relay, and server addresses to send to;
+// proxy answers NS of the source address if it is etherconn
+func (setup *testSetup) relayV6NetworkConn(proxy *NDPProxy) (dhcpv6relay.DHCPConn, []*net.UDPAddr, error) {
+	svrs := setup.v6SvrAddrs()
+	if setup.RelayConn == relayConnEtherConn {
+		econn := etherconn.NewEtherConn(setup.StartMAC, setup.pktRelay,
+			etherconn.WithVLANs(setup.StartVLANs),
+			etherconn.WithEtherTypes([]uint16{EthernetTypeIPv6}))
+		src := myaddr.GetLLAFromMac(setup.StartMAC)
+		if !setup.SourceV6Addr.IsUnspecified() {
+			src = setup.SourceV6Addr.AsSlice()
+		}
+		rudpconn, err := etherconn.NewRUDPConn(fmt.Sprintf("[%v]:%v", src, dhcpv6.DefaultServerPort), econn, setup.v6ConnOptions()...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create raw udp conn for relay, %w", err)
+		}
+		proxy.add(src.String(), L2Encap{HwAddr: setup.StartMAC, Vlans: setup.StartVLANs})
+		return &dhcpv6relay.RUDPDHCPConn{RUDPConn: rudpconn}, svrs, nil
+	}
+	conn, err := server6.NewIPv6UDPConn(setup.Ifname, &net.UDPAddr{IP: setup.SourceV6Addr.AsSlice(), Port: dhcpv6.DefaultServerPort})
+	if err != nil {
+		return nil, nil, err
+	}
+	//link scoped destination needs the zone of network interface
+	for i, svr := range svrs {
+		if svr.IP.IsLinkLocalMulticast() || svr.IP.IsLinkLocalUnicast() {
+			svrs[i] = &net.UDPAddr{IP: svr.IP, Port: svr.Port, Zone: setup.Ifname}
+		}
+	}
+	return &dhcpv6relay.UDPDHCPConn{UDPConn: conn}, svrs, nil
+}
+
+// relayV6AccessConn returns the access side conn of DHCPv6 relay, which joins All_DHCP_Relay_Agents_and_Servers
+func (setup *testSetup) relayV6AccessConn() (*net.UDPConn, error) {
+	iff, err := net.InterfaceByName(setup.AccessIf)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := server6.NewIPv6UDPConn(setup.AccessIf, &net.UDPAddr{IP: net.IPv6unspecified, Port: dhcpv6.DefaultServerPort})
+	if err != nil {
+		return nil, err
+	}
+	if err = ipv6.NewPacketConn(conn).JoinGroup(iff, &net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to join %v on %v, %w", dhcpv6.AllDHCPRelayAgentsAndServers, setup.AccessIf, err)
+	}
+	return conn, nil
+}
+
+// runRelay runs DHCPv4 and/or DHCPv6 relay agents between accessif and network interface until ctx is done
+func runRelay(ctx context.Context, setup *testSetup) error {
+	clients := newRelayClients(setup)
+	conns := []interface{ Close() error }{}
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	var v4relay *dhcpv4relay.RelayAgent
+	var v6relay *dhcpv6relay.RelayAgent
+	var proxy *NDPProxy
+	if setup.RelayConn == relayConnEtherConn {
+		//answers ARP and NS of relay addresses on network side
+		proxy = NewNDPProxyFromRelay(make(map[string]L2Encap), setup.pktRelay)
+	}
+	if setup.EnableV4 {
+		access, err := server4.NewIPv4UDPConn(setup.AccessIf, &net.UDPAddr{Port: dhcpv4.ServerPort})
+		if err != nil {
+			return fmt.Errorf("failed to create DHCPv4 conn on %v, %w", setup.AccessIf, err)
+		}
+		conns = append(conns, access)
+		network, err := setup.relayV4NetworkConn(proxy)
+		if err != nil {
+			return fmt.Errorf("failed to create DHCPv4 conn on %v, %w", setup.Ifname, err)
+		}
+		conns = append(conns, network)
+		v4relay = dhcpv4relay.NewRelayAgent(ctx, access, network, setup.GiAddr.AsSlice(),
+			dhcpv4relay.WithSvrAddrs(setup.v4SvrAddrs()),
+			dhcpv4relay.WithOptionFunc(clients.v4Options))
+	}
+	if setup.EnableV6 {
+		access, err := setup.relayV6AccessConn()
+		if err != nil {
+			return fmt.Errorf("failed to create DHCPv6 conn on %v, %w", setup.AccessIf, err)
+		}
+		conns = append(conns, access)
+		network, svrs, err := setup.relayV6NetworkConn(proxy)
+		if err != nil {
+			return fmt.Errorf("failed to create DHCPv6 conn on %v, %w", setup.Ifname, err)
+		}
+		conns = append(conns, network)
+		v6relay = dhcpv6relay.NewRelayAgent(ctx, &dhcpv6relay.UDPDHCPConn{UDPConn: access}, network,
+			dhcpv6relay.WithAccessZone(setup.AccessIf),
+			dhcpv6relay.WithSvrAddrs(svrs),
+			dhcpv6relay.WithClientFunc(clients.v6Params))
+	}
+	status := func() string {
+		r := ""
+		if v4relay != nil {
+			r += fmt.Sprintf("DHCPv4 relay %v", v4relay.Stats())
+		}
+		if v6relay != nil {
+			if r != "" {
+				r += "\t"
+			}
+			r += fmt.Sprintf("DHCPv6 relay %v", v6relay.Stats())
+		}
+		return r
+	}
+	fmt.Printf("relaying between %v and %v...\n", setup.AccessIf, setup.Ifname)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("\nRelay Summary\n")
+			if v4relay != nil {
+				fmt.Printf("DHCPv4 relay: %v\n", v4relay.Stats())
+			}
+			if v6relay != nil {
+				fmt.Printf("DHCPv6 relay: %v\n", v6relay.Stats())
+			}
+			fmt.Printf("Clients:%d\n", len(clients.clients))
+			return nil
+		case <-ticker.C:
+			fmt.Printf("\r%v", status())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestV6ClientMAC(t *testing.T) {
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	for i, c := range []struct {
+		duid     dhcpv6.DUID
+		peer     net.IP
+		expected net.HardwareAddr
+	}{
+		{&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}, net.ParseIP("fe80::1"), mac},
+		{&dhcpv6.DUIDLLT{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}, nil, mac},
+		{&dhcpv6.DUIDUUID{}, net.ParseIP("fe80::201:2ff:fe03:405"), mac},
+		{&dhcpv6.DUIDUUID{}, net.ParseIP("fe80::1"), nil},
+	} {
+		if got := v6ClientMAC(c.duid, c.peer); !bytes.Equal(got, c.expected) {
+			t.Fatalf("case %d expect %v got %v", i, c.expected, got)
+		}
+	}
+}
+
+func TestRelayClients(t *testing.T) {
+	setup := newDefaultConf()
+	setup.CID = "port-@ID"
+	setup.VendorClass = "vc"
+	setup.LinkAddr = "2001:db8::@ID"
+	clients := newRelayClients(setup)
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	req, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := clients.v4Options(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != 1 || opts[0].Code.Code() != dhcpv4.OptionRelayAgentInformation.Code() {
+		t.Fatalf("expect only option 82, got %v", opts)
+	}
+	msg, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDUUID{UUID: [16]byte{1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//2nd client, since MAC derived from peer is different
+	link, relayOpts, err := clients.v6Params(msg, net.ParseIP("fe80::201:2ff:fe03:406"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !link.Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("wrong link-address %v", link)
+	}
+	if iid := relayOpts.GetOne(dhcpv6.OptionInterfaceID); iid == nil || string(iid.ToBytes()) != "port-1" {
+		t.Fatalf("wrong interface-id %v", iid)
+	}
+	//same client as the DHCPv4 one
+	if link, _, err = clients.v6Params(msg, net.ParseIP("fe80::201:2ff:fe03:405")); err != nil || !link.Equal(net.ParseIP("2001:db8::")) {
+		t.Fatalf("wrong link-address %v, %v", link, err)
+	}
+	if len(clients.clients) != 2 {
+		t.Fatalf("expect 2 clients, got %d", len(clients.clients))
+	}
+}
@@ -0,0 +1,151 @@
+// relayhop
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hujun-open/dhcplt/dhcpv6relay"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// relayHop is an additional DHCPv6 relay hop between the emulated relay agent and server,
+// the text format is a list of "<key>=<value>" separated by ';', e.g. "link=2001:db8::1;hop=1;iid=agg-@SVLAN", keys are:
+//   - link: link-address, "::" if not specified
+//   - peer: peer-address, the link-address of the previous hop if not specified
+//   - hop: hop-count, hop-count of the previous hop plus 1 if not specified
+//   - iid: Interface-ID option
+//   - rid: Remote-ID option with BBF enterprise number
+//   - opt: custom option in "<code>:<type>:<value>" format, could be specified multiple times
+//
+// all values except hop are templates, see templateVarNames
+type relayHop struct {
+	Link     string
+	Peer     string
+	HopCount int //negative means auto
+	IID      string
+	RID      string
+	Options  []customOption
+}
+
+func (rh relayHop) MarshalText() ([]byte, error) {
+	fields := []string{}
+	add := func(k, v string) {
+		if v != "" {
+			fields = append(fields, k+"="+v)
+		}
+	}
+	add("link", rh.Link)
+	add("peer", rh.Peer)
+	if rh.HopCount >= 0 {
+		add("hop", strconv.Itoa(rh.HopCount))
+	}
+	add("iid", rh.IID)
+	add("rid", rh.RID)
+	for _, co := range rh.Options {
+		buf, err := co.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		add("opt", string(buf))
+	}
+	return []byte(strings.Join(fields, ";")), nil
+}
+
+func (rh *relayHop) UnmarshalText(text []byte) error {
+	r := relayHop{HopCount: -1}
+	for _, field := range splitOptionList(string(text)) {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("invalid relay hop field %v, must be key=value", field)
+		}
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "link":
+			r.Link = v
+		case "peer":
+			r.Peer = v
+		case "hop":
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return fmt.Errorf("invalid hop-count %v", v)
+			}
+			r.HopCount = int(n)
+		case "iid":
+			r.IID = v
+		case "rid":
+			r.RID = v
+		case "opt":
+			co := customOption{}
+			if err := co.UnmarshalText([]byte(v)); err != nil {
+				return err
+			}
+			r.Options = append(r.Options, co)
+		default:
+			return fmt.Errorf("unknown relay hop field %v", k)
+		}
+	}
+	*rh = r
+	return nil
+}
+
+// validate checks all templates and options of the hop
+func (rh relayHop) validate() error {
+	for _, s := range []string{rh.Link, rh.Peer, rh.IID, rh.RID} {
+		if err := validateTemplate(s); err != nil {
+			return err
+		}
+	}
+	for _, co := range rh.Options {
+		if err := co.validate(true); err != nil {
+			return fmt.Errorf("invalid option %d, %w", co.Code, err)
+		}
+	}
+	_, err := rh.hop(templateVars{MAC: net.HardwareAddr{0, 0, 0, 0, 0, 0}, VLANs: []uint16{1, 1}})
+	return err
+}
+
+// hop returns the relay hop of the client with vars
+func (rh relayHop) hop(vars templateVars) (dhcpv6relay.Hop, error) {
+	r := dhcpv6relay.Hop{
+		LinkAddr: net.IPv6unspecified,
+		HopCount: rh.HopCount,
+	}
+	parseAddr := func(name, tmpl string) (net.IP, error) {
+		s := genStrFromTemplate(tmpl, vars)
+		addr := net.ParseIP(s)
+		if addr == nil || addr.To4() != nil {
+			return nil, fmt.Errorf("%v %v is not a valid IPv6 address", name, s)
+		}
+		return addr, nil
+	}
+	var err error
+	if rh.Link != "" {
+		if r.LinkAddr, err = parseAddr("link-address", rh.Link); err != nil {
+			return r, err
+		}
+	}
+	if rh.Peer != "" {
+		if r.PeerAddr, err = parseAddr("peer-address", rh.Peer); err != nil {
+			return r, err
+		}
+	}
+	if rh.IID != "" {
+		r.Options.Add(dhcpv6.OptInterfaceID([]byte(genStrFromTemplate(rh.IID, vars))))
+	}
+	if rh.RID != "" {
+		r.Options.Add(&dhcpv6.OptRemoteID{
+			EnterpriseNumber: BBFEnterpriseNumber,
+			RemoteID:         []byte(genStrFromTemplate(rh.RID, vars)),
+		})
+	}
+	for _, co := range rh.Options {
+		op, err := co.v6Option(vars)
+		if err != nil {
+			return r, err
+		}
+		r.Options.Add(op)
+	}
+	return r, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/hujun-open/dhcplt/dhcpv6relay"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestRelayHop(t *testing.T) {
+	rh := relayHop{}
+	if err := rh.UnmarshalText([]byte("link=2001:db8::@ID;hop=3;iid=agg-@SVLAN;rid=node1;opt=38:hex:0102")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rh.validate(); err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := rh.MarshalText()
+	if string(buf) != "link=2001:db8::@ID;hop=3;iid=agg-@SVLAN;rid=node1;opt=38:hex:0102" {
+		t.Fatalf("wrong text %v", string(buf))
+	}
+	h, err := rh.hop(templateVars{ID: 10, MAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, VLANs: []uint16{100, 200}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.LinkAddr.Equal(net.ParseIP("2001:db8::10")) || h.PeerAddr != nil || h.HopCount != 3 {
+		t.Fatalf("wrong hop %+v", h)
+	}
+	if iid := h.Options.GetOne(dhcpv6.OptionInterfaceID); iid == nil || string(iid.ToBytes()) != "agg-100" {
+		t.Fatalf("wrong interface-id %v", iid)
+	}
+	if len(h.Options) != 3 {
+		t.Fatalf("hop should have 3 options, got %v", h.Options)
+	}
+	auto := relayHop{}
+	if err := auto.UnmarshalText([]byte("peer=fe80::1")); err != nil {
+		t.Fatal(err)
+	}
+	if h, err = auto.hop(templateVars{}); err != nil || h.HopCount >= 0 || !h.LinkAddr.Equal(net.IPv6unspecified) {
+		t.Fatalf("wrong auto hop %+v, %v", h, err)
+	}
+	for _, s := range []string{"link", "hop=300", "foo=bar", "link=1.1.1.1"} {
+		bad := relayHop{}
+		if err := bad.UnmarshalText([]byte(s)); err == nil {
+			if err = bad.validate(); err == nil {
+				t.Fatalf("%v should be invalid", s)
+			}
+		}
+	}
+}
+
+func TestV6RelayAddr(t *testing.T) {
+	setup := &testSetup{LinkAddr: "2001:db8:@SVLAN::1"}
+	addr, err := setup.v6LinkAddr(templateVars{VLANs: []uint16{100, 200}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !addr.Equal(net.ParseIP("2001:db8:100::1")) {
+		t.Fatalf("wrong link-address %v", addr)
+	}
+	setup.LinkAddr = "10.1.1.@ID"
+	if _, err = setup.v6LinkAddr(templateVars{ID: 1}); err == nil {
+		t.Fatal("IPv4 link-address should be invalid")
+	}
+	if svrs := setup.v6SvrAddrs(); len(svrs) != 1 || !svrs[0].IP.Equal(net.ParseIP("ff02::1:2")) {
+		t.Fatalf("wrong default server addresses %v", svrs)
+	}
+	setup.V6Servers = []netip.Addr{netip.MustParseAddr("2001:db8::547"), netip.MustParseAddr("2001:db8::548")}
+	svrs := setup.v6SvrAddrs()
+	if len(svrs) != 2 || !svrs[1].IP.Equal(net.ParseIP("2001:db8::548")) || svrs[1].Port != dhcpv6.DefaultServerPort {
+		t.Fatalf("wrong server addresses %v", svrs)
+	}
+}
+
+func TestRelayOptions(t *testing.T) {
+	setup := newDefaultConf()
+	setup.SubscriberID = "sub-@ID"
+	setup.ClientLLAddr = true
+	setup.RelaySrcPort = true
+	setup.RadiusAttrs = []customOption{{Code: 1, Type: optValString, Value: "user-@ID"}}
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	ccfg := &clientConfig{Mac: mac, setup: setup}
+	if err := ccfg.genOptions(setup.clientIdentity(), templateVars{ID: 2, MAC: mac}); err != nil {
+		t.Fatal(err)
+	}
+	for code, expected := range map[dhcpv6.OptionCode][]byte{
+		dhcpv6.OptionRelayAgentSubscriberID: []byte("sub-2"),
+		dhcpv6.OptionClientLinkLayerAddr:    {0, 1, 0, 1, 2, 3, 4, 5},
+		dhcpv6.OptionRelayPort:              {0, 0},
+		dhcpv6.OptionRadius:                 append([]byte{1, 8}, []byte("user-2")...),
+	} {
+		op := ccfg.V6RelayOptions.GetOne(code)
+		if op == nil {
+			t.Fatalf("missing relay option %v", code)
+		}
+		if string(op.ToBytes()) != string(expected) {
+			t.Fatalf("relay option %v expect %v got %v", code, expected, op.ToBytes())
+		}
+	}
+}
+
+// relayLeaseMsg relays a Renew of the v6 lease of dc, returns the outermost relay-forward
+func relayLeaseMsg(t *testing.T, dc *DClient) *dhcpv6.RelayMessage {
+	mods, err := dc.leaseRelayModifiers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	accessClnt, accessRelay := conpair.NewPacketConnPair()
+	netRelay, netSvr := conpair.NewPacketConnPair()
+	dhcpv6relay.NewRelayAgent(ctx, &dhcpv6relay.PairDHCPConn{PacketConnPair: accessRelay},
+		&dhcpv6relay.PairDHCPConn{PacketConnPair: netRelay}, mods...)
+	renew, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: dc.d6Lease.MAC}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	renew.MessageType = dhcpv6.MessageTypeRenew
+	if _, err = accessClnt.WriteTo(renew.ToBytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+	netSvr.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxDHCPv6Size)
+	n, _, err := netSvr.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayfwd, err := dhcpv6.RelayMessageFromBytes(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return relayfwd
+}
+
+func TestLeaseRelay(t *testing.T) {
+	setup := newDefaultConf()
+	setup.V6MsgType = dhcpv6.MessageTypeRelayForward
+	setup.LinkAddr = "2001:db8::@ID"
+	for _, s := range []string{"link=2001:db8:1::@ID;iid=agg1", "link=2001:db8:2::1;peer=2001:db8::2;hop=5"} {
+		rh := relayHop{}
+		if err := rh.UnmarshalText([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		setup.RelayHops = append(setup.RelayHops, rh)
+	}
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	ccfg := &clientConfig{Mac: mac, setup: setup}
+	if err := ccfg.genOptions(setup.clientIdentity(), templateVars{ID: 10, MAC: mac}); err != nil {
+		t.Fatal(err)
+	}
+	lease := &v6Lease{MAC: mac, LinkAddr: ccfg.V6LinkAddr, RelayIDOptions: ccfg.V6RelayOptions, RelayHops: ccfg.V6RelayHops}
+	buf, err := lease.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//lease loaded from file
+	dc := &DClient{cfg: &clientConfig{setup: setup}, d6Lease: new(v6Lease)}
+	if err = dc.d6Lease.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	//client relay agent plus 2 hops
+	var levels []*dhcpv6.RelayMessage
+	for msg := dhcpv6.DHCPv6(relayLeaseMsg(t, dc)); msg.IsRelay(); msg = msg.(*dhcpv6.RelayMessage).Options.RelayMessage() {
+		levels = append(levels, msg.(*dhcpv6.RelayMessage))
+	}
+	if len(levels) != 3 {
+		t.Fatalf("expect 3 levels of relay-forward, got %d", len(levels))
+	}
+	if outer := levels[0]; outer.HopCount != 5 || !outer.LinkAddr.Equal(net.ParseIP("2001:db8:2::1")) {
+		t.Fatalf("wrong outer hop %v", outer.Summary())
+	}
+	if mid := levels[1]; !mid.LinkAddr.Equal(net.ParseIP("2001:db8:1::10")) || string(mid.Options.InterfaceID()) != "agg1" {
+		t.Fatalf("wrong middle hop %v", mid.Summary())
+	}
+	if inner := levels[2]; !inner.LinkAddr.Equal(net.ParseIP("2001:db8::10")) {
+		t.Fatalf("wrong link-address %v", inner.LinkAddr)
+	}
+}
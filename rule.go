@@ -0,0 +1,180 @@
+// rule
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hujun-open/dhcplt/common"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// server messages an option rule could apply to
+const (
+	ruleMsgOffer     = "offer"
+	ruleMsgAck       = "ack"
+	ruleMsgAdvertise = "advertise"
+	ruleMsgReply     = "reply"
+)
+
+// comparison operators, longer ones first so that they are matched first
+var ruleOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// optionRule is an option expected in a server message, text format is "<msg>:[!]<code>[<op><value>]":
+//   - msg: offer | ack | advertise | reply
+//   - "!" means the option must not be present
+//   - op: == | != | > | >= | < | <=, option value is compared as a big endian unsigned integer
+//
+// e.g. "ack:51>=3600" means ACK must contain option 51 with value no less than 3600,
+// "reply:23" means Reply must contain option 23
+type optionRule struct {
+	Msg    string
+	Code   uint16
+	Absent bool
+	Op     string
+	Value  uint64
+}
+
+func (or optionRule) MarshalText() ([]byte, error) {
+	r := or.Msg + ":"
+	if or.Absent {
+		r += "!"
+	}
+	r += strconv.Itoa(int(or.Code))
+	if or.Op != "" {
+		r += or.Op + strconv.FormatUint(or.Value, 10)
+	}
+	return []byte(r), nil
+}
+
+func (or optionRule) String() string {
+	buf, _ := or.MarshalText()
+	return string(buf)
+}
+
+func (or *optionRule) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	msg, expr, found := strings.Cut(s, ":")
+	if !found {
+		return fmt.Errorf("invalid option rule %v", s)
+	}
+	r := optionRule{Msg: strings.ToLower(strings.TrimSpace(msg))}
+	switch r.Msg {
+	case ruleMsgOffer, ruleMsgAck, ruleMsgAdvertise, ruleMsgReply:
+	default:
+		return fmt.Errorf("unknown message %v in option rule %v", msg, s)
+	}
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "!") {
+		r.Absent = true
+		expr = expr[1:]
+	}
+	codeStr := expr
+	for _, op := range ruleOps {
+		if i := strings.Index(expr, op); i > 0 {
+			if r.Absent {
+				return fmt.Errorf("absent option can't have a value in option rule %v", s)
+			}
+			codeStr = expr[:i]
+			r.Op = op
+			v, err := strconv.ParseUint(strings.TrimSpace(expr[i+len(op):]), 0, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value in option rule %v", s)
+			}
+			r.Value = v
+			break
+		}
+	}
+	code, err := strconv.ParseUint(strings.TrimSpace(codeStr), 10, 16)
+	if err != nil || code == 0 {
+		return fmt.Errorf("invalid option code in option rule %v", s)
+	}
+	r.Code = uint16(code)
+	if r.isV4() && r.Code > 254 {
+		return fmt.Errorf("%d is not a valid DHCPv4 option code", r.Code)
+	}
+	*or = r
+	return nil
+}
+
+func (or optionRule) isV4() bool {
+	return or.Msg == ruleMsgOffer || or.Msg == ruleMsgAck
+}
+
+// check returns error if the option with data violates the rule, present is false if the option is not in the message
+func (or optionRule) check(data []byte, present bool) error {
+	if or.Absent {
+		if present {
+			return fmt.Errorf("option %d is present", or.Code)
+		}
+		return nil
+	}
+	if !present {
+		return fmt.Errorf("option %d is missing", or.Code)
+	}
+	if or.Op == "" {
+		return nil
+	}
+	if len(data) == 0 || len(data) > 8 {
+		return fmt.Errorf("option %d value %x is not an integer", or.Code, data)
+	}
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	var ok bool
+	switch or.Op {
+	case "==":
+		ok = v == or.Value
+	case "!=":
+		ok = v != or.Value
+	case ">":
+		ok = v > or.Value
+	case ">=":
+		ok = v >= or.Value
+	case "<":
+		ok = v < or.Value
+	case "<=":
+		ok = v <= or.Value
+	}
+	if !ok {
+		return fmt.Errorf("option %d value %d is not %v%d", or.Code, v, or.Op, or.Value)
+	}
+	return nil
+}
+
+// checkV4Rules returns rules for msgName that msg violates
+func (dc *DClient) checkV4Rules(msgName string, msg *dhcpv4.DHCPv4) (r []string) {
+	for _, rule := range dc.cfg.setup.OptionRules {
+		if rule.Msg != msgName {
+			continue
+		}
+		data := msg.Options.Get(dhcpv4.GenericOptionCode(rule.Code))
+		if err := rule.check(data, msg.Options.Has(dhcpv4.GenericOptionCode(rule.Code))); err != nil {
+			common.MyLog("%v from server for %v violates rule %v, %v\n%v", msgName, dc.id, rule, err, msg.Summary())
+			r = append(r, rule.String())
+		}
+	}
+	return
+}
+
+// checkV6Rules returns rules for msgName that msg violates
+func (dc *DClient) checkV6Rules(msgName string, msg *dhcpv6.Message) (r []string) {
+	for _, rule := range dc.cfg.setup.OptionRules {
+		if rule.Msg != msgName {
+			continue
+		}
+		var data []byte
+		opt := msg.GetOneOption(dhcpv6.OptionCode(rule.Code))
+		if opt != nil {
+			data = opt.ToBytes()
+		}
+		if err := rule.check(data, opt != nil); err != nil {
+			common.MyLog("%v from server for %v violates rule %v, %v\n%v", msgName, dc.id, rule, err, msg.Summary())
+			r = append(r, rule.String())
+		}
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/dhcplt/conpair"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+func TestOptionRule(t *testing.T) {
+	testList := []struct {
+		input      string
+		data       []byte
+		present    bool
+		violated   bool
+		shouldFail bool
+	}{
+		//case 0
+		{
+			input:   "ack:51>=3600",
+			data:    []byte{0, 0, 0x0e, 0x10},
+			present: true,
+		},
+		//case 1
+		{
+			input:    "ack:51>=3600",
+			data:     []byte{0, 0, 0x0e, 0x0f},
+			present:  true,
+			violated: true,
+		},
+		//case 2
+		{
+			input:    "reply:23",
+			violated: true,
+		},
+		//case 3
+		{
+			input:    "offer:!82",
+			data:     []byte{1, 1, 1},
+			present:  true,
+			violated: true,
+		},
+		//case 4
+		{
+			input:   "advertise:7==255",
+			data:    []byte{255},
+			present: true,
+		},
+		//case 5
+		{
+			input:      "ack:300",
+			shouldFail: true,
+		},
+		//case 6
+		{
+			input:      "nak:51",
+			shouldFail: true,
+		},
+		//case 7
+		{
+			input:      "ack:!51>1",
+			shouldFail: true,
+		},
+	}
+	for i, c := range testList {
+		rule := new(optionRule)
+		err := rule.UnmarshalText([]byte(c.input))
+		if err != nil {
+			if c.shouldFail {
+				t.Logf("case %d failed as expected, %v", i, err)
+				continue
+			}
+			t.Fatalf("case %d failed, %v", i, err)
+		}
+		if c.shouldFail {
+			t.Fatalf("case %d succeed but should fail", i)
+		}
+		if rule.String() != c.input {
+			t.Fatalf("case %d marshal to %v", i, rule)
+		}
+		err = rule.check(c.data, c.present)
+		if (err != nil) != c.violated {
+			t.Fatalf("case %d expect violated %v, got %v", i, c.violated, err)
+		}
+	}
+}
+
+func TestRenewRuleViolation(t *testing.T) {
+	svrID := net.ParseIP("192.0.2.1")
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	clnt, svr := conpair.NewPacketConnPair()
+	//closing svr stops receive loop of d4
+	defer svr.Close()
+	go fakeRenewServer(svr, svrID)
+	d4, err := nclient4.NewWithConn(clnt, mac, nclient4.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup := newDefaultConf()
+	rule := optionRule{}
+	//ACK of fakeRenewServer has no renewal time
+	if err = rule.UnmarshalText([]byte("ack:58")); err != nil {
+		t.Fatal(err)
+	}
+	setup.OptionRules = []optionRule{rule}
+	dc := &DClient{
+		cfg:          &clientConfig{Mac: mac, setup: setup},
+		d4:           d4,
+		d4Lease:      genV4Lease(t, svrID, mac, nil),
+		dialResultCh: make(chan *dialResult, 1),
+	}
+	if err = dc.renewOrRebindv4(context.Background(), nil, actionRenew); err == nil {
+		t.Fatal("renew ACK violating option rule is accepted")
+	}
+	r := <-dc.dialResultCh
+	if r.ExecResult != resultFailure || len(r.RuleViolations) != 1 || r.RuleViolations[0] != "ack:58" {
+		t.Fatalf("wrong result %+v", r)
+	}
+}
@@ -22,7 +22,6 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
-	"github.com/insomniacslk/dhcp/iana"
 )
 
 type actionType int
@@ -32,6 +31,11 @@ const (
 	actionRelease
 	actionRenew
 	actionRebind
+	actionReboot
+	actionExhaust
+	actionConform
+	actionFuzz
+	actionRelay
 )
 
 func (act actionType) String() string {
@@ -54,6 +58,16 @@ func (act actionType) MarshalText() (text []byte, err error) {
 		return []byte("renew"), nil
 	case actionRebind:
 		return []byte("rebind"), nil
+	case actionReboot:
+		return []byte("reboot"), nil
+	case actionExhaust:
+		return []byte("exhaust"), nil
+	case actionConform:
+		return []byte("conform"), nil
+	case actionFuzz:
+		return []byte("fuzz"), nil
+	case actionRelay:
+		return []byte("relay"), nil
 	}
 }
 
@@ -73,7 +87,31 @@ func (act *actionType) UnmarshalText(text []byte) error {
 	case "rebind":
 		*act = actionRebind
 		return nil
+	case "reboot":
+		*act = actionReboot
+		return nil
+	case "exhaust":
+		*act = actionExhaust
+		return nil
+	case "conform":
+		*act = actionConform
+		return nil
+	case "fuzz":
+		*act = actionFuzz
+		return nil
+	case "relay":
+		*act = actionRelay
+		return nil
+	}
+}
+
+// needLease returns true if act works on leases loaded from lease file
+func (act actionType) needLease() bool {
+	switch act {
+	case actionRelease, actionRenew, actionRebind, actionReboot:
+		return true
 	}
+	return false
 }
 
 type dialResult struct {
@@ -83,35 +121,51 @@ type dialResult struct {
 	L2EP       clientID
 	StartTime  time.Time
 	FinishTime time.Time
+	Group      string //name of the client group, empty if there is no group
+	LeaseCheck leaseCheckResult
+	//violated option rules
+	RuleViolations []string
 }
 
 type DClient struct {
-	d4           *nclient4.Client
-	d6           *nclient6.Client
-	d4OtherClnt  *nclient4.Client //for release or renew
-	d6OtherClnt  *nclient6.Client // for release or renew
-	d6relay      *dhcpv6relay.RelayAgent
-	d4Lease      *v4Lease
-	d6Lease      *v6Lease
-	cfg          *clientConfig
-	id           clientID
-	dialResultCh chan *dialResult
+	d4              *nclient4.Client
+	d6              *nclient6.Client
+	d4OtherClnt     *nclient4.Client //for release or renew
+	d6OtherClnt     *nclient6.Client // for release or renew
+	d6relay         *dhcpv6relay.RelayAgent
+	v4conn          *v4SrcConn          //raw udp conn of d4
+	v6conn          *etherconn.RUDPConn //raw udp conn of d6, or network side of d6relay
+	d4Lease         *v4Lease
+	d6Lease         *v6Lease
+	cfg             *clientConfig
+	id              clientID
+	dialResultCh    chan *dialResult
+	validator       *leaseValidator
+	reconfConn      net.PacketConn //receives DHCPv6 Reconfigure, nil if reconfigure is disabled
+	reconf          *reconfState
+	reconfStats     *reconfigureStats
+	forceRenewConn  net.PacketConn //receives DHCPv4 FORCERENEW, nil if forcerenew is disabled
+	forceRenew      *forceRenewState
+	forceRenewStats *forceRenewStats
+	arp             *NDPProxy //answers ARP/NS of leased addresses, nil if forcerenew and reconfigure are disabled
 	// saveLeaseCh  chan interface{}
 }
 
+func (dc *DClient) sendResult(result *dialResult) {
+	result.Group = dc.cfg.setup.groupName
+	dc.dialResultCh <- result
+}
+
 func (dc *DClient) createV4OtherClnt(act actionType) error {
 	if dc.d4Lease == nil {
 		return fmt.Errorf("can't create v4 release client for %v without v4 lease", dc.id)
 	}
-	localPort := dhcpv4.ClientPort
-	if !dc.cfg.setup.GiAddr.IsUnspecified() {
-		localPort = dhcpv4.ServerPort
-	}
-	if dc.cfg.setup.SourceV4Port != dhcpv4.ClientPort {
-		localPort = int(dc.cfg.setup.SourceV4Port)
-	}
-
+	localPort := dc.cfg.setup.v4LocalPort()
 	localaddr := myaddr.GenConnectionAddrStr("", dc.d4Lease.Lease.ACK.YourIPAddr, localPort)
+	if act == actionReboot {
+		//client doesn't have an address in INIT-REBOOT state
+		localaddr = fmt.Sprintf("0.0.0.0:%d", localPort)
+	}
 	if !dc.cfg.setup.SourceV4Addr.IsUnspecified() {
 		localaddr = myaddr.GenConnectionAddrStr("", dc.cfg.setup.SourceV4Addr.AsSlice(), localPort)
 	}
@@ -124,7 +178,12 @@ func (dc *DClient) createV4OtherClnt(act actionType) error {
 		clntModList = append(clntModList, nclient4.WithDebugLogger())
 
 	}
-	if act != actionRebind {
+	if act == actionReboot {
+		clntModList = append(clntModList,
+			nclient4.WithRetry(1),
+			nclient4.WithTimeout(rebootClntTimeout))
+	}
+	if act != actionRebind && act != actionReboot {
 		svrUDPAddr := &net.UDPAddr{
 			IP:   dc.d4Lease.Lease.ACK.ServerIdentifier(),
 			Port: dhcpv4.ServerPort,
@@ -132,58 +191,150 @@ func (dc *DClient) createV4OtherClnt(act actionType) error {
 		clntModList = append(clntModList, nclient4.WithServerAddr(svrUDPAddr))
 	}
 
-	dc.d4OtherClnt, err = nclient4.NewWithConn(rudpconn, dc.d4Lease.Lease.ACK.ClientHWAddr, clntModList...)
+	dc.d4OtherClnt, err = nclient4.NewWithConn(dc.cfg.withV4Auth(rudpconn), dc.d4Lease.Lease.ACK.ClientHWAddr, clntModList...)
 	if err != nil {
 		return fmt.Errorf("failed to create dhcpv4 release client for %v,%v", dc.id, err)
 	}
 	return nil
 }
 
-func (dc *DClient) createV6OtherClnt() error {
+func (dc *DClient) createV6OtherClnt(act actionType) error {
 	if dc.d6Lease == nil {
 		return fmt.Errorf("can't create v6 release client for %v without v6 lease", dc.id)
 	}
 	// dc.d6ReleaseClnt = dc.d6
 
-	localaddr := fmt.Sprintf("[%v]:%v",
-		myaddr.GetLLAFromMac(dc.d6Lease.MAC),
-		dc.cfg.setup.SourceV6Port)
-	if !dc.cfg.setup.SourceV6Addr.IsUnspecified() {
-		localaddr = fmt.Sprintf("[%v]:%v",
-			dc.cfg.setup.SourceV6Addr,
-			dc.cfg.setup.SourceV6Port)
-	}
+	localaddr := dc.cfg.setup.v6LocalAddr(dc.d6Lease.MAC)
 	// var rudpconn *etherconn.RUDPConn
 	// var err error
-	rudpconn, err := etherconn.NewRUDPConn(localaddr, dc.cfg.v6econn, etherconn.WithAcceptAny(true))
+	rudpconn, err := etherconn.NewRUDPConn(localaddr, dc.cfg.v6econn, dc.cfg.setup.v6ConnOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to create raw udp conn for %v for other actions, %w", dc.id, err)
 	}
+	mods := []nclient6.ClientOpt{}
+	if act == actionReboot {
+		mods = append(mods, nclient6.WithRetry(1), nclient6.WithTimeout(rebootClntTimeout))
+	}
 	switch dc.cfg.setup.V6MsgType {
 	case dhcpv6.MessageTypeSolicit:
 
-		dc.d6OtherClnt, err = nclient6.NewWithConn(rudpconn, dc.d6Lease.MAC)
+		dc.d6OtherClnt, err = nclient6.NewWithConn(dc.cfg.withV6Auth(rudpconn), dc.d6Lease.MAC, mods...)
 		if err != nil {
 			return fmt.Errorf("failed to create dhcp6 client %v for other actions, %w", dc.id, err)
 		}
 	case dhcpv6.MessageTypeRelayForward:
 		accessConClnt, accessConRelay := conpair.NewPacketConnPair()
-		dc.d6OtherClnt, err = nclient6.NewWithConn(accessConClnt, dc.d6Lease.MAC)
+		dc.d6OtherClnt, err = nclient6.NewWithConn(dc.cfg.withV6Auth(accessConClnt), dc.d6Lease.MAC, mods...)
 		if err != nil {
 			return fmt.Errorf("failed to create dhcp6 client %v for for other actions, %w", dc.id, err)
 		}
+		mods, err := dc.leaseRelayModifiers()
+		if err != nil {
+			return err
+		}
 		dc.d6relay = dhcpv6relay.NewRelayAgent(context.Background(),
 			&dhcpv6relay.PairDHCPConn{PacketConnPair: accessConRelay},
-			&dhcpv6relay.RUDPDHCPConn{RUDPConn: rudpconn},
-			dhcpv6relay.WithLinkAddr(net.ParseIP("::")),
-			dhcpv6relay.WithPeerAddr(myaddr.GetLLAFromMac(dc.d6Lease.MAC)),
-			dhcpv6relay.WithOptions(dc.d6Lease.RelayIDOptions))
+			&dhcpv6relay.RUDPDHCPConn{RUDPConn: rudpconn}, mods...)
 	}
 
 	return nil
 }
 
-// threeRAll means release, renew and rebind
+// leaseRelayModifiers returns the modifiers of DHCPv6 relay agent of dc, which relays messages of its v6 lease
+func (dc *DClient) leaseRelayModifiers() ([]dhcpv6relay.Modifier, error) {
+	linkAddr := dc.d6Lease.LinkAddr
+	if linkAddr == nil {
+		//lease saved by older version, only MAC is known for link-address template
+		var err error
+		if linkAddr, err = dc.cfg.setup.v6LinkAddr(templateVars{MAC: dc.d6Lease.MAC}); err != nil {
+			return nil, err
+		}
+	}
+	return dc.cfg.setup.relayModifiers(
+		dhcpv6relay.WithLinkAddr(linkAddr),
+		dhcpv6relay.WithPeerAddr(myaddr.GetLLAFromMac(dc.d6Lease.MAC)),
+		dhcpv6relay.WithOptions(dc.d6Lease.RelayIDOptions),
+		dhcpv6relay.WithHops(dc.d6Lease.RelayHops)), nil
+}
+
+// v6LocalAddr returns the local address of the DHCPv6 raw udp conn of the client with mac,
+// it is the network side of relay agent with DHCPv6 relay, LDRA uses server port
+func (setup *testSetup) v6LocalAddr(mac net.HardwareAddr) string {
+	port := setup.SourceV6Port
+	if setup.LDRA {
+		port = dhcpv6.DefaultServerPort
+	}
+	if !setup.SourceV6Addr.IsUnspecified() {
+		return fmt.Sprintf("[%v]:%v", setup.SourceV6Addr, port)
+	}
+	return fmt.Sprintf("[%v]:%v", myaddr.GetLLAFromMac(mac), port)
+}
+
+// v6LinkAddr returns the link-address of DHCPv6 relay for the client with vars
+func (setup *testSetup) v6LinkAddr(vars templateVars) (net.IP, error) {
+	s := genStrFromTemplate(setup.LinkAddr, vars)
+	addr := net.ParseIP(s)
+	if addr == nil || addr.To4() != nil {
+		return nil, fmt.Errorf("link-address %v is not a valid IPv6 address", s)
+	}
+	return addr, nil
+}
+
+// v6SvrAddrs returns the server addresses DHCPv6 relay sends to
+func (setup *testSetup) v6SvrAddrs() []*net.UDPAddr {
+	if len(setup.V6Servers) == 0 {
+		return []*net.UDPAddr{nclient6.AllDHCPRelayAgentsAndServers}
+	}
+	r := []*net.UDPAddr{}
+	for _, svr := range setup.V6Servers {
+		r = append(r, &net.UDPAddr{IP: svr.AsSlice(), Port: dhcpv6.DefaultServerPort})
+	}
+	return r
+}
+
+// v6ConnOptions returns the options of DHCPv6 raw udp conn,
+// unicast packets are sent to next hop MAC if it is specified
+func (setup *testSetup) v6ConnOptions() []etherconn.RUDPConnOption {
+	r := []etherconn.RUDPConnOption{etherconn.WithAcceptAny(true)}
+	if len(setup.V6NextHopMAC) > 0 {
+		nexthop := setup.V6NextHopMAC
+		r = append(r, etherconn.WithResolveNextHopMacFunc(func(ip net.IP) net.HardwareAddr {
+			if ip.IsMulticast() {
+				return etherconn.BroadCastMAC
+			}
+			return nexthop
+		}))
+	}
+	return r
+}
+
+// relayModifiers returns mods with modifiers of setup appended
+func (setup *testSetup) relayModifiers(mods ...dhcpv6relay.Modifier) []dhcpv6relay.Modifier {
+	mods = append(mods, dhcpv6relay.WithSvrAddrs(setup.v6SvrAddrs()))
+	if setup.LDRA {
+		mods = append(mods, dhcpv6relay.WithLDRA())
+	}
+	return mods
+}
+
+// v4Clnt returns the client for release, renew and rebind, which is d4OtherClnt if it is created, otherwise d4;
+// d4 is used by clients that already dialed, since a second client on the same EtherConn would steal its packets
+func (dc *DClient) v4Clnt() *nclient4.Client {
+	if dc.d4OtherClnt != nil {
+		return dc.d4OtherClnt
+	}
+	return dc.d4
+}
+
+// v6Clnt returns the client for release and renew, which is d6OtherClnt if it is created, otherwise d6
+func (dc *DClient) v6Clnt() *nclient6.Client {
+	if dc.d6OtherClnt != nil {
+		return dc.d6OtherClnt
+	}
+	return dc.d6
+}
+
+// threeRAll means release, renew and rebind, also does reboot
 func (dc *DClient) threeRAll(ctx context.Context, wg *sync.WaitGroup, act actionType) {
 
 	var err error
@@ -211,7 +362,7 @@ func (dc *DClient) threeRAll(ctx context.Context, wg *sync.WaitGroup, act action
 		}
 	}
 	if dc.d6Lease != nil && dc.cfg.setup.EnableV6 {
-		err = dc.createV6OtherClnt()
+		err = dc.createV6OtherClnt(act)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -226,7 +377,8 @@ func (dc *DClient) threeRAll(ctx context.Context, wg *sync.WaitGroup, act action
 					err = dc.releasev4(subwg)
 				case actionRenew, actionRebind:
 					err = dc.renewOrRebindv4(ctx, subwg, act)
-
+				case actionReboot:
+					err = dc.rebootv4(ctx, subwg)
 				}
 				if err != nil {
 					common.MyLog("failed to %v DHCPv4, %v", act, err)
@@ -237,11 +389,16 @@ func (dc *DClient) threeRAll(ctx context.Context, wg *sync.WaitGroup, act action
 		if dc.d6OtherClnt != nil {
 			subwg.Add(1)
 			go func() {
-				mtype := dhcpv6.MessageTypeRelease
-				if act == actionRenew {
-					mtype = dhcpv6.MessageTypeRenew
+				switch act {
+				case actionReboot:
+					err = dc.rebootv6(ctx, subwg)
+				default:
+					mtype := dhcpv6.MessageTypeRelease
+					if act == actionRenew {
+						mtype = dhcpv6.MessageTypeRenew
+					}
+					err = dc.releaseOrRenewV6(subwg, mtype)
 				}
-				err = dc.releaseOrRenewV6(subwg, mtype)
 				if err != nil {
 					common.MyLog("failed to %v DHCPv6, %v", act, err)
 				}
@@ -252,11 +409,16 @@ func (dc *DClient) threeRAll(ctx context.Context, wg *sync.WaitGroup, act action
 		if dc.d6OtherClnt != nil {
 			subwg.Add(1)
 			go func() {
-				mtype := dhcpv6.MessageTypeRelease
-				if act == actionRenew {
-					mtype = dhcpv6.MessageTypeRenew
+				switch act {
+				case actionReboot:
+					err = dc.rebootv6(ctx, subwg)
+				default:
+					mtype := dhcpv6.MessageTypeRelease
+					if act == actionRenew {
+						mtype = dhcpv6.MessageTypeRenew
+					}
+					err = dc.releaseOrRenewV6(subwg, mtype)
 				}
-				err = dc.releaseOrRenewV6(subwg, mtype)
 				if err != nil {
 					common.MyLog("failed to %v DHCPv6, %v", act, err)
 				}
@@ -271,7 +433,8 @@ func (dc *DClient) threeRAll(ctx context.Context, wg *sync.WaitGroup, act action
 					err = dc.releasev4(subwg)
 				case actionRenew, actionRebind:
 					err = dc.renewOrRebindv4(ctx, subwg, act)
-
+				case actionReboot:
+					err = dc.rebootv4(ctx, subwg)
 				}
 				if err != nil {
 					common.MyLog("failed to %v DHCPv4, %v", act, err)
@@ -280,11 +443,7 @@ func (dc *DClient) threeRAll(ctx context.Context, wg *sync.WaitGroup, act action
 		}
 
 	}
-	if dc.d4 != nil || dc.d6 != nil {
-		subwg.Wait()
-
-	}
-
+	subwg.Wait()
 }
 
 func (dc *DClient) dialAll(wg *sync.WaitGroup) {
@@ -401,24 +560,13 @@ func (dc *DClient) sendRS() error {
 }
 
 func (dc *DClient) dialv6(wg *sync.WaitGroup) error {
-	defer wg.Done()
+	if wg != nil {
+		defer wg.Done()
+	}
 	if dc.d6 == nil {
 		return fmt.Errorf("dhcpv6 is not configured")
 	}
-	checkResp := func(msg *dhcpv6.Message) error {
-		if dc.cfg.setup.NeedNA {
-
-			if len(msg.Options.OneIANA().Options.Addresses()) == 0 {
-				return fmt.Errorf("no IANA address is assigned")
-			}
-		}
-		if dc.cfg.setup.NeedPD {
-			if len(msg.Options.OneIAPD().Options.Prefixes()) == 0 {
-				return fmt.Errorf("no IAPD prefix is assigned")
-			}
-		}
-		return nil
-	}
+	checkResp := dc.cfg.checkIAs
 	result := new(dialResult)
 	result.action = actionDORA
 	result.IsDHCPv6 = true
@@ -427,12 +575,19 @@ func (dc *DClient) dialv6(wg *sync.WaitGroup) error {
 	defer func() {
 		result.L2EP = dc.id
 		result.FinishTime = time.Now()
-		dc.dialResultCh <- result
+		dc.sendResult(result)
 	}()
 	solicitMsg, err := buildSolicit(*dc.cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create solicit msg for %v, %v", dc.id, err)
 	}
+	reqMods := []dhcpv6.Modifier{}
+	for _, o := range dc.cfg.V6Options {
+		reqMods = append(reqMods, dhcpv6.WithOption(o))
+	}
+	for _, o := range dc.cfg.V6RequestOnly {
+		reqMods = append(reqMods, dhcpv6.WithOption(o))
+	}
 	var reply *dhcpv6.Message
 	switch dc.cfg.setup.V6MsgType {
 	case dhcpv6.MessageTypeSolicit:
@@ -440,13 +595,14 @@ func (dc *DClient) dialv6(wg *sync.WaitGroup) error {
 			nclient6.AllDHCPRelayAgentsAndServers, solicitMsg,
 			nclient6.IsMessageType(dhcpv6.MessageTypeAdvertise))
 		if err != nil {
-			return fmt.Errorf("failed recv DHCPv6 advertisement for %v, %v", dc.id, err)
+			return fmt.Errorf("failed recv DHCPv6 advertisement for %v, %w", dc.id, err)
 		}
+		result.RuleViolations = append(result.RuleViolations, dc.checkV6Rules(ruleMsgAdvertise, adv)...)
 		err = checkResp(adv)
 		if err != nil {
-			return fmt.Errorf("got invalid advertise msg for clnt %v, %v", dc.id, err)
+			return fmt.Errorf("got invalid advertise msg for clnt %v, %w", dc.id, err)
 		}
-		request, err := NewRequestFromAdv(adv)
+		request, err := NewRequestFromAdv(adv, reqMods...)
 		if err != nil {
 			return fmt.Errorf("failed to build request msg for clnt %v, %v", dc.id, err)
 		}
@@ -454,24 +610,25 @@ func (dc *DClient) dialv6(wg *sync.WaitGroup) error {
 			nclient6.AllDHCPRelayAgentsAndServers,
 			request, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
 		if err != nil {
-			return fmt.Errorf("failed to recv DHCPv6 reply for %v, %v", dc.id, err)
+			return fmt.Errorf("failed to recv DHCPv6 reply for %v, %w", dc.id, err)
 		}
 		err = checkResp(reply)
 		if err != nil {
-			return fmt.Errorf("got invalid reply msg for %v, %v", dc.id, err)
+			return fmt.Errorf("got invalid reply msg for %v, %w", dc.id, err)
 		}
 	case dhcpv6.MessageTypeRelayForward:
 		adv, err := dc.d6.SendAndRead(context.Background(),
 			nclient6.AllDHCPRelayAgentsAndServers, solicitMsg,
 			nclient6.IsMessageType(dhcpv6.MessageTypeAdvertise))
 		if err != nil {
-			return fmt.Errorf("failed recv DHCPv6 advertisement for %v, %v", dc.id, err)
+			return fmt.Errorf("failed recv DHCPv6 advertisement for %v, %w", dc.id, err)
 		}
+		result.RuleViolations = append(result.RuleViolations, dc.checkV6Rules(ruleMsgAdvertise, adv)...)
 		err = checkResp(adv)
 		if err != nil {
-			return fmt.Errorf("got invalid advertise msg for clnt %v, %v", dc.id, err)
+			return fmt.Errorf("got invalid advertise msg for clnt %v, %w", dc.id, err)
 		}
-		request, err := NewRequestFromAdv(adv)
+		request, err := NewRequestFromAdv(adv, reqMods...)
 		if err != nil {
 			return fmt.Errorf("failed to build request msg for clnt %v, %v", dc.id, err)
 		}
@@ -479,14 +636,15 @@ func (dc *DClient) dialv6(wg *sync.WaitGroup) error {
 			nclient6.AllDHCPRelayAgentsAndServers,
 			request, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
 		if err != nil {
-			return fmt.Errorf("failed to recv DHCPv6 reply for %v, %v", dc.id, err)
+			return fmt.Errorf("failed to recv DHCPv6 reply for %v, %w", dc.id, err)
 		}
 		err = checkResp(reply)
 		if err != nil {
-			return fmt.Errorf("got invalid reply msg for %v, %v", dc.id, err)
+			return fmt.Errorf("got invalid reply msg for %v, %w", dc.id, err)
 		}
 
 	}
+	result.RuleViolations = append(result.RuleViolations, dc.checkV6Rules(ruleMsgReply, reply)...)
 	lease := &v6Lease{
 		MAC:            dc.cfg.Mac,
 		ReplyOptions:   reply.Options.Options,
@@ -495,7 +653,15 @@ func (dc *DClient) dialv6(wg *sync.WaitGroup) error {
 		IDOptions:      dc.cfg.V6Options,
 		RelayIDOptions: dc.cfg.V6RelayOptions,
 	}
+	if dc.cfg.setup.V6MsgType == dhcpv6.MessageTypeRelayForward {
+		lease.LinkAddr = dc.cfg.V6LinkAddr
+		lease.RelayHops = dc.cfg.V6RelayHops
+	}
 	dc.d6Lease = lease
+	if dc.reconf != nil {
+		dc.reconf.update(reply)
+		dc.proxyV6Lease(true)
+	}
 	if dc.cfg.setup.ApplyLease {
 		err = lease.Apply(dc.cfg.setup.Ifname, true)
 		if err != nil {
@@ -509,13 +675,22 @@ func (dc *DClient) dialv6(wg *sync.WaitGroup) error {
 		}
 	}
 
+	result.LeaseCheck = dc.checkV6Lease()
+	if result.LeaseCheck.failed() {
+		return fmt.Errorf("got invalid v6 lease for %v, %+v", dc.id, result.LeaseCheck)
+	}
+	if len(result.RuleViolations) > 0 {
+		return fmt.Errorf("DHCPv6 server messages for %v violate option rules %v", dc.id, result.RuleViolations)
+	}
 	result.ExecResult = resultSuccess
 	return nil
 
 }
 
 func (dc *DClient) dialv4(wg *sync.WaitGroup) error {
-	defer wg.Done()
+	if wg != nil {
+		defer wg.Done()
+	}
 	if dc.d4 == nil {
 		return fmt.Errorf("dhcpv4 is not configured")
 	}
@@ -527,35 +702,59 @@ func (dc *DClient) dialv4(wg *sync.WaitGroup) error {
 	defer func() {
 		result.L2EP = dc.id
 		result.FinishTime = time.Now()
-		dc.dialResultCh <- result
+		dc.sendResult(result)
 	}()
 	common.MyLog("doing DORA for %v , on if %v",
 		dc.id, dc.cfg.setup.Ifname)
 
-	dhcpModList := []dhcpv4.Modifier{}
-	for _, op := range dc.cfg.V4Options {
-		dhcpModList = append(dhcpModList, dhcpv4.WithOption(op))
+	genModList := func(msgOptions []dhcpv4.Option) []dhcpv4.Modifier {
+		dhcpModList := []dhcpv4.Modifier{}
+		for _, op := range dc.cfg.V4Options {
+			dhcpModList = append(dhcpModList, dhcpv4.WithOption(op))
+		}
+		for _, op := range msgOptions {
+			dhcpModList = append(dhcpModList, dhcpv4.WithOption(op))
+		}
+		return append(dhcpModList, dhcpv4.WithGatewayIP(dc.cfg.setup.GiAddr.AsSlice()))
 	}
-	dhcpModList = append(dhcpModList, dhcpv4.WithGatewayIP(dc.cfg.setup.GiAddr.AsSlice()))
 	result.StartTime = time.Now()
 	result.IsDHCPv6 = false
-	lease, err := dc.d4.Request(context.Background(), dhcpModList...)
+	offer, err := dc.d4.DiscoverOffer(context.Background(), genModList(dc.cfg.V4DiscoverOnly)...)
+	if err != nil {
+		return fmt.Errorf("failed complete DORA for %v,unable to receive an offer: %w", dc.id, err)
+	}
+	result.RuleViolations = append(result.RuleViolations, dc.checkV4Rules(ruleMsgOffer, offer)...)
+	lease, err := dc.d4.RequestFromOffer(context.Background(), offer, genModList(dc.cfg.V4RequestOnly)...)
 	if err != nil {
-		return fmt.Errorf("failed complete DORA for %v,%v", dc.id, err)
+		return fmt.Errorf("failed complete DORA for %v,%w", dc.id, err)
 	}
+	result.RuleViolations = append(result.RuleViolations, dc.checkV4Rules(ruleMsgAck, lease.ACK)...)
 	dc.d4Lease = newV4Lease()
 	myl := myDHCPv4Lease(*lease)
 	dc.d4Lease.Lease = &myl
 	dc.d4Lease.VLANList = dc.cfg.VLANs
+	for _, op := range dc.cfg.V4Options {
+		dc.d4Lease.IDOptions.Update(op)
+	}
+	if dc.forceRenew != nil {
+		dc.forceRenew.update(lease.ACK)
+	}
+	if dc.arp != nil {
+		dc.arp.add(lease.ACK.YourIPAddr.String(), L2Encap{
+			HwAddr: dc.cfg.Mac,
+			Vlans:  dc.cfg.VLANs,
+		})
+	}
 	if dc.cfg.setup.ApplyLease {
 		err = dc.d4Lease.Apply(dc.cfg.setup.Ifname, true)
 		if err != nil {
 			return fmt.Errorf("failed to apply v4 lease for clnt %v, %v", dc.id, err)
 		}
 	}
-	if dc.cfg.setup.saveV4Chan != nil {
+	//DHCPv4 over DHCPv6 lease is not saved, since lease actions only work with native DHCPv4
+	if dc.cfg.setup.saveV4Chan != nil && !dc.cfg.setup.V4oV6 {
 		dc.cfg.setup.saveV4Chan <- &v4LeaseWithID{
-			ID:    getClientIDFromL2Key(dc.cfg.v4econn.LocalAddr().GetKey()),
+			ID:    dc.id,
 			Lease: dc.d4Lease,
 		}
 	}
@@ -573,11 +772,49 @@ func (dc *DClient) dialv4(wg *sync.WaitGroup) error {
 	// if dc.cfg.setup.SaveLease {
 	// 	dc.saveLeaseCh <- dc.d4Lease
 	// }
+	result.LeaseCheck = dc.checkV4Lease()
+	if result.LeaseCheck.failed() {
+		return fmt.Errorf("got invalid v4 lease for %v, %+v", dc.id, result.LeaseCheck)
+	}
+	if len(result.RuleViolations) > 0 {
+		return fmt.Errorf("DHCPv4 server messages for %v violate option rules %v", dc.id, result.RuleViolations)
+	}
 	result.ExecResult = resultSuccess
 
 	return nil
 }
 
+// proxyV6Lease adds IA_NA addresses of the v6 lease to NDP proxy, or removes them if add is false,
+// so that server could unicast Reconfigure to them; addresses of relayed clients are not proxied
+func (dc *DClient) proxyV6Lease(add bool) {
+	if dc.arp == nil || dc.d6relay != nil || dc.d6Lease == nil {
+		return
+	}
+	for _, na := range dc.d6Lease.ReplyOptions.Get(dhcpv6.OptionIANA) {
+		for _, addr := range na.(*dhcpv6.OptIANA).Options.Addresses() {
+			if add {
+				dc.arp.add(addr.IPv6Addr.String(), L2Encap{
+					HwAddr: dc.cfg.Mac,
+					Vlans:  dc.cfg.VLANs,
+				})
+			} else {
+				dc.arp.remove(addr.IPv6Addr.String())
+			}
+		}
+	}
+}
+
+// v4IDModifiers returns modifiers adding identity options of v4 lease
+func (dc *DClient) v4IDModifiers() []dhcpv4.Modifier {
+	modList := []dhcpv4.Modifier{}
+	for t := range dc.d4Lease.IDOptions {
+		modList = append(modList,
+			dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(t),
+				dc.d4Lease.IDOptions.Get(dhcpv4.GenericOptionCode(t)))))
+	}
+	return modList
+}
+
 func (dc *DClient) renewOrRebindv4(ctx context.Context, wg *sync.WaitGroup, act actionType) error {
 	common.MyLog("%v v4 for %v", act, dc.id)
 	if wg != nil {
@@ -586,15 +823,16 @@ func (dc *DClient) renewOrRebindv4(ctx context.Context, wg *sync.WaitGroup, act
 	if dc.d4Lease == nil {
 		return nil
 	}
-	modList := []dhcpv4.Modifier{}
-	for t := range dc.d4Lease.IDOptions {
-		modList = append(modList,
-			dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(t),
-				dc.d4Lease.IDOptions.Get(dhcpv4.GenericOptionCode(t)))))
-	}
+	modList := dc.v4IDModifiers()
 	modList = append(modList, dhcpv4.WithRelay(dc.cfg.setup.GiAddr.AsSlice()))
-	dl := nclient4.Lease(*dc.d4Lease.Lease)
-	_, err := dc.d4OtherClnt.Renew(ctx, &dl, modList...)
+	var newLease *nclient4.Lease
+	var err error
+	if act == actionRenew {
+		newLease, err = dc.renewV4(ctx, dc.d4Lease, modList...)
+	} else {
+		dl := nclient4.Lease(*dc.d4Lease.Lease)
+		newLease, err = dc.v4Clnt().Renew(ctx, &dl, modList...)
+	}
 	result := new(dialResult)
 	result.StartTime = time.Now()
 	result.ExecResult = resultSuccess
@@ -606,11 +844,18 @@ func (dc *DClient) renewOrRebindv4(ctx context.Context, wg *sync.WaitGroup, act
 	result.L2EP = dc.id
 	defer func() {
 		result.FinishTime = time.Now()
-		dc.dialResultCh <- result
+		dc.sendResult(result)
 	}()
 	if err != nil {
 		result.ExecResult = resultFailure
-		return fmt.Errorf("failed to release v4 lease for clnt %v, %v", dc.id, err)
+		return fmt.Errorf("failed to %v v4 lease for clnt %v, %v", act, dc.id, err)
+	}
+	myl := myDHCPv4Lease(*newLease)
+	dc.d4Lease.Lease = &myl
+	result.RuleViolations = dc.checkV4Rules(ruleMsgAck, newLease.ACK)
+	if len(result.RuleViolations) > 0 {
+		result.ExecResult = resultFailure
+		return fmt.Errorf("DHCPv4 server messages for %v violate option rules %v", dc.id, result.RuleViolations)
 	}
 	return nil
 
@@ -624,16 +869,11 @@ func (dc *DClient) releasev4(wg *sync.WaitGroup) error {
 	if dc.d4Lease == nil {
 		return nil
 	}
-	modList := []dhcpv4.Modifier{}
-	for t := range dc.d4Lease.IDOptions {
-		modList = append(modList,
-			dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(t),
-				dc.d4Lease.IDOptions.Get(dhcpv4.GenericOptionCode(t)))))
-	}
+	modList := dc.v4IDModifiers()
 	var err error
 	for i := 0; i < 3; i++ {
 		dl := nclient4.Lease(*dc.d4Lease.Lease)
-		err = dc.d4OtherClnt.Release(&dl, modList...)
+		err = dc.unicastV4(dc.d4Lease, func() error { return dc.v4Clnt().Release(&dl, modList...) })
 		if err == nil {
 			break
 		}
@@ -649,17 +889,21 @@ func (dc *DClient) releasev4(wg *sync.WaitGroup) error {
 	result.L2EP = dc.id
 	defer func() {
 		result.FinishTime = time.Now()
-		dc.dialResultCh <- result
+		dc.sendResult(result)
 	}()
 	if err != nil {
 		result.ExecResult = resultFailure
 		return fmt.Errorf("failed to release v4 lease for clnt %v, %v", dc.id, err)
 	}
+	if dc.arp != nil {
+		dc.arp.remove(dc.d4Lease.Lease.ACK.YourIPAddr.String())
+	}
+	dc.unregisterV4Lease()
 	return nil
 }
 
 func (dc *DClient) releaseOrRenewV6(wg *sync.WaitGroup, mt dhcpv6.MessageType) error {
-	common.MyLog("releasing v6 for %v", dc.id)
+	common.MyLog("%v v6 for %v", mt, dc.id)
 	if wg != nil {
 		defer wg.Done()
 	}
@@ -667,21 +911,25 @@ func (dc *DClient) releaseOrRenewV6(wg *sync.WaitGroup, mt dhcpv6.MessageType) e
 		return nil
 	}
 	result := new(dialResult)
-	result.ExecResult = resultSuccess
+	result.ExecResult = resultFailure
 	result.action = actionRelease
+	if mt == dhcpv6.MessageTypeRenew {
+		result.action = actionRenew
+	}
 	result.StartTime = time.Now()
 	result.IsDHCPv6 = true
 	result.L2EP = dc.id
 	defer func() {
 		result.FinishTime = time.Now()
-		dc.dialResultCh <- result
+		dc.sendResult(result)
 	}()
 	releaseMsg, err := dc.d6Lease.Genv6Release(mt)
 	if err != nil {
 		return fmt.Errorf("failed to create v6 release msg for clnt %v, %v", dc.id, err)
 	}
+	var reply *dhcpv6.Message
 	for i := 0; i < 3; i++ {
-		_, err = dc.d6OtherClnt.SendAndRead(context.Background(),
+		reply, err = dc.v6Clnt().SendAndRead(context.Background(),
 			nclient6.AllDHCPRelayAgentsAndServers, releaseMsg,
 			nclient6.IsMessageType(dhcpv6.MessageTypeReply))
 		if err == nil {
@@ -689,16 +937,72 @@ func (dc *DClient) releaseOrRenewV6(wg *sync.WaitGroup, mt dhcpv6.MessageType) e
 		}
 	}
 	if err != nil {
-		return fmt.Errorf("failed to release v6 lease for clnt %v, %v", dc.id, err)
+		return fmt.Errorf("failed to %v v6 lease for clnt %v, %v", mt, dc.id, err)
+	}
+	result.RuleViolations = dc.checkV6Rules(ruleMsgReply, reply)
+	if mt == dhcpv6.MessageTypeRelease {
+		dc.proxyV6Lease(false)
+		dc.unregisterV6Lease()
+	} else {
+		if err = checkLeaseReply(mt, reply); err != nil {
+			return fmt.Errorf("failed to renew v6 lease for clnt %v, %w", dc.id, err)
+		}
+		//addresses may change with the reply
+		dc.proxyV6Lease(false)
+		dc.d6Lease.ReplyOptions = reply.Options.Options
+		dc.proxyV6Lease(true)
 	}
+	if len(result.RuleViolations) > 0 {
+		return fmt.Errorf("DHCPv6 server messages for %v violate option rules %v", dc.id, result.RuleViolations)
+	}
+	result.ExecResult = resultSuccess
 	return nil
 }
 
+// clientGroup is a group of clients sharing the same setup
+type clientGroup struct {
+	setup   *testSetup
+	clients []*DClient
+	summary *resultSummary
+	gen     *clientConfigGenerator //generates new clients for churn, nil if clients are loaded from client file
+	churned int                    //number of clients replaced by churn
+}
+
+// relayStats returns the sum of DHCPv6 relay agent stats of all clients in g
+func (g *clientGroup) relayStats() dhcpv6relay.Stats {
+	r := dhcpv6relay.Stats{}
+	for _, dc := range g.clients {
+		if dc.d6relay != nil {
+			r.Add(dc.d6relay.Stats())
+		}
+	}
+	return r
+}
+
+func (g *clientGroup) dialAll(wg *sync.WaitGroup) {
+	defer wg.Done()
+	subwg := new(sync.WaitGroup)
+	for _, c := range g.clients {
+		subwg.Add(1)
+		go c.dialAll(subwg)
+		time.Sleep(g.setup.Interval)
+	}
+	subwg.Wait()
+}
+
 type Sched struct {
-	ClntList     map[clientID]*DClient
-	dialResultCh chan *dialResult
-	summary      *resultSummary
-	setup        *testSetup
+	ClntList        map[clientID]*DClient
+	groups          []*clientGroup
+	dialResultCh    chan *dialResult
+	summary         *resultSummary
+	setup           *testSetup
+	ndp             *NDPProxy
+	validator       *leaseValidator
+	flapper         *flapper
+	reconfStats     *reconfigureStats
+	forceRenewStats *forceRenewStats
+	authStats       *authStats //nil if authentication is disabled
+	listenWG        sync.WaitGroup
 }
 
 const (
@@ -711,12 +1015,15 @@ func NewSched(setup *testSetup) (*Sched, error) {
 	r.ClntList = make(map[clientID]*DClient)
 	r.summary = newResultSummary(setup)
 	r.dialResultCh = make(chan *dialResult, dialResultChanLen)
-	if setup.Action != actionDORA {
+	r.reconfStats = new(reconfigureStats)
+	r.forceRenewStats = new(forceRenewStats)
+	if setup.Action.needLease() {
 		saveLeases, err := loadLeaseFromFile(setup.LeaseFile)
 		if err != nil {
 			log.Fatal(err)
 		}
 		fmt.Printf("loaded %d leases from %v\n", len(saveLeases), setup.LeaseFile)
+		g := &clientGroup{setup: setup, summary: r.summary}
 		for id, fullLeases := range saveLeases {
 			dc := new(DClient)
 			dc.cfg = new(clientConfig)
@@ -727,175 +1034,336 @@ func NewSched(setup *testSetup) (*Sched, error) {
 			dc.d6Lease = fullLeases.V6
 			fmt.Printf("%v v6 lease loaded is %+v\n", dc.id, dc.d6Lease)
 			dc.dialResultCh = r.dialResultCh
+			if setup.Auth == authDelayed {
+				var mac net.HardwareAddr
+				if dc.d4Lease != nil {
+					mac = dc.d4Lease.Lease.ACK.ClientHWAddr
+				} else if dc.d6Lease != nil {
+					mac = dc.d6Lease.MAC
+				}
+				if dc.cfg.authKey, err = setup.authKeyOf(mac); err != nil {
+					return nil, err
+				}
+			}
+			if setup.Action == actionReboot {
+				if dc.d4Lease != nil && setup.EnableV4 {
+					r.summary.rebootTotal++
+				}
+				if dc.d6Lease != nil && setup.EnableV6 {
+					r.summary.rebootTotal++
+				}
+			}
 			r.ClntList[id] = dc
+			g.clients = append(g.clients, dc)
 		}
+		r.groups = []*clientGroup{g}
 		return r, nil
 	}
-
-	clntConfs, err := genClientConfigurations(setup)
-	if err != nil {
-		return nil, err
-	}
-	for _, cfg := range clntConfs {
-		dc := new(DClient)
-		dc.cfg = new(clientConfig)
-		*dc.cfg = cfg
-		var key etherconn.L2EndpointKey
-		if dc.cfg.v4econn != nil {
-			key = dc.cfg.v4econn.LocalAddr().GetKey()
-			localPort := dhcpv4.ClientPort
-			if !dc.cfg.setup.GiAddr.IsUnspecified() {
-				localPort = dhcpv4.ServerPort
+	llaList := make(map[string]L2Encap)
+	r.validator = newLeaseValidator()
+	forceRenew := false
+	for _, p := range setup.populations() {
+		if p.Auth != authNone {
+			if r.authStats == nil {
+				r.authStats = new(authStats)
 			}
-			if dc.cfg.setup.SourceV4Port != dhcpv4.ClientPort {
-				localPort = int(dc.cfg.setup.SourceV4Port)
+			p.authStats = r.authStats
+		}
+		clntConfs, gen, err := genClientConfigurations(p)
+		if err != nil {
+			return nil, err
+		}
+		g := &clientGroup{setup: p, summary: r.summary, gen: gen}
+		if len(setup.groups) > 0 {
+			g.summary = newResultSummary(p)
+		}
+		for _, cfg := range clntConfs {
+			dc, err := newDClient(cfg)
+			if err != nil {
+				return nil, err
 			}
-			localaddr := fmt.Sprintf("0.0.0.0:%d", localPort)
-			if !dc.cfg.setup.SourceV4Addr.IsUnspecified() {
-				localaddr = fmt.Sprintf("%v:%d", dc.cfg.setup.SourceV4Addr, localPort)
+			if _, ok := r.ClntList[dc.id]; ok {
+				return nil, fmt.Errorf("client %v of group %v is already used by another group", dc.id, p.groupName)
 			}
-			rudpconn, err := etherconn.NewRUDPConn(localaddr, dc.cfg.v4econn,
-				etherconn.WithAcceptAny(true))
-			if err != nil {
-				return nil, fmt.Errorf("failed to create raw udp conn for %v,%v", dc.cfg.Mac, err)
+			dc.dialResultCh = r.dialResultCh
+			dc.validator = r.validator
+			if dc.reconfConn != nil {
+				dc.reconfStats = r.reconfStats
+				r.goListen(dc.listenReconfigure)
 			}
-			clntModList := []nclient4.ClientOpt{
-				nclient4.WithRetry(int(dc.cfg.setup.Retry)),
-				nclient4.WithTimeout(dc.cfg.setup.Timeout),
+			if dc.forceRenewConn != nil {
+				dc.forceRenewStats = r.forceRenewStats
+				forceRenew = true
 			}
-			if setup.Debug {
-				clntModList = append(clntModList, nclient4.WithDebugLogger())
+			r.ClntList[dc.id] = dc
+			g.clients = append(g.clients, dc)
+			if p.v6Transport() {
+				llaList[myaddr.GetLLAFromMac(cfg.Mac).String()] = L2Encap{
+					HwAddr: cfg.Mac,
+					Vlans:  cfg.VLANs,
+				}
 			}
-			clntModList = append(clntModList, nclient4.WithHWAddr(dc.cfg.Mac))
-			dc.d4, err = nclient4.NewWithConn(rudpconn, dc.cfg.Mac, clntModList...)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create dhcpv4 client for %v,%v", dc.cfg.Mac, err)
+		}
+		r.groups = append(r.groups, g)
+	}
+	//start NDPProxy for DHCPv6, and for ARP of leased addresses with forcerenew
+	if len(llaList) > 0 || forceRenew {
+		r.ndp = NewNDPProxyFromRelay(llaList, r.setup.pktRelay)
+	}
+	for _, dc := range r.ClntList {
+		if dc.reconfConn != nil {
+			dc.arp = r.ndp
+		}
+		if dc.forceRenewConn != nil {
+			dc.arp = r.ndp
+			r.goListen(dc.listenForceRenew)
+		}
+	}
+	return r, nil
+}
+
+// goListen runs listen of a client for server initiated messages in a goroutine
+func (sch *Sched) goListen(listen func()) {
+	sch.listenWG.Add(1)
+	go func() {
+		defer sch.listenWG.Done()
+		listen()
+	}()
+}
+
+// stopListening closes Reconfigure and FORCERENEW conns of all clients,
+// and waits for their listeners to return, so that no more result is sent
+func (sch *Sched) stopListening() {
+	for _, g := range sch.groups {
+		for _, dc := range g.clients {
+			if dc.reconfConn != nil {
+				dc.reconfConn.Close()
+			}
+			if dc.forceRenewConn != nil {
+				dc.forceRenewConn.Close()
 			}
 		}
+	}
+	sch.listenWG.Wait()
+}
 
-		if dc.cfg.v6econn != nil {
-			if dc.cfg.setup.SendRSFirst {
-				err := dc.sendRS()
-				if err != nil {
-					return nil, fmt.Errorf("client %v %v failed to get RA,%w", dc.id, dc.cfg.Mac, err)
-				}
+// newDClient creates a DHCP client with cfg
+func newDClient(cfg clientConfig) (*DClient, error) {
+	dc := new(DClient)
+	dc.cfg = new(clientConfig)
+	*dc.cfg = cfg
+	setup := cfg.setup
+	var key etherconn.L2EndpointKey
+	if dc.cfg.v4econn != nil {
+		key = dc.cfg.v4econn.LocalAddr().GetKey()
+		localPort := dc.cfg.setup.v4LocalPort()
+		localaddr := fmt.Sprintf("0.0.0.0:%d", localPort)
+		if !dc.cfg.setup.SourceV4Addr.IsUnspecified() {
+			localaddr = fmt.Sprintf("%v:%d", dc.cfg.setup.SourceV4Addr, localPort)
+		}
+		rudpconn, err := etherconn.NewRUDPConn(localaddr, dc.cfg.v4econn,
+			etherconn.WithAcceptAny(true))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create raw udp conn for %v,%v", dc.cfg.Mac, err)
+		}
+		dc.v4conn = newV4SrcConn(rudpconn)
+		var v4Conn net.PacketConn = dc.v4conn
+		if setup.ForceRenew {
+			demux := newDHCPv4Demux(dc.v4conn)
+			v4Conn = demux.side()
+			dc.forceRenewConn = demux.side(messageTypeForceRenew)
+			dc.forceRenew = newForceRenewState(setup.ForceRenewNonce)
+			demux.start()
+		}
+		dc.d4, err = nclient4.NewWithConn(dc.cfg.withV4Auth(v4Conn), dc.cfg.Mac, dc.cfg.v4ClntOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dhcpv4 client for %v,%v", dc.cfg.Mac, err)
+		}
+	}
+
+	if dc.cfg.v6econn != nil {
+		if dc.cfg.setup.SendRSFirst {
+			err := dc.sendRS()
+			if err != nil {
+				return nil, fmt.Errorf("client %v %v failed to get RA,%w", dc.id, dc.cfg.Mac, err)
 			}
+		}
 
-			key = dc.cfg.v6econn.LocalAddr().GetKey()
+		key = dc.cfg.v6econn.LocalAddr().GetKey()
 
-			localaddr := fmt.Sprintf("[%v]:%v",
-				myaddr.GetLLAFromMac(dc.cfg.Mac),
-				setup.SourceV6Port)
-			if !dc.cfg.setup.SourceV6Addr.IsUnspecified() {
-				localaddr = fmt.Sprintf("[%v]:%v",
-					dc.cfg.setup.SourceV6Addr,
-					setup.SourceV6Port)
+		localaddr := setup.v6LocalAddr(dc.cfg.Mac)
+		rudpconn, err := etherconn.NewRUDPConn(localaddr, dc.cfg.v6econn, setup.v6ConnOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create raw udp conn for %v,%v", dc.cfg.Mac, err)
+		}
+		dc.v6conn = rudpconn
+		//clntConn is the DHCPv6 conn on client side
+		var clntConn net.PacketConn
+		switch dc.cfg.setup.V6MsgType {
+		case dhcpv6.MessageTypeSolicit:
+			clntConn = rudpconn
+		case dhcpv6.MessageTypeRelayForward:
+			accessConClnt, accessConRelay := conpair.NewPacketConnPair()
+			clntConn = accessConClnt
+			dc.d6relay = dhcpv6relay.NewRelayAgent(context.Background(),
+				&dhcpv6relay.PairDHCPConn{PacketConnPair: accessConRelay},
+				&dhcpv6relay.RUDPDHCPConn{RUDPConn: rudpconn},
+				setup.relayModifiers(
+					dhcpv6relay.WithLinkAddr(dc.cfg.V6LinkAddr),
+					dhcpv6relay.WithPeerAddr(myaddr.GetLLAFromMac(dc.cfg.Mac)),
+					dhcpv6relay.WithOptions(dc.cfg.V6RelayOptions),
+					dhcpv6relay.WithHops(dc.cfg.V6RelayHops))...)
+		default:
+			return nil, fmt.Errorf("un-supported DHCPv6 msg type %v", dc.cfg.setup.V6MsgType)
+
+		}
+		v6Conn, v4o6Conn := clntConn, clntConn
+		if setup.V4oV6 || setup.Reconfigure {
+			demux := newDHCPv6Demux(clntConn)
+			if setup.EnableV6 {
+				v6Conn = demux.side()
+			}
+			if setup.V4oV6 {
+				v4o6Conn = demux.side(dhcpv6.MessageTypeDHCPv4Response)
+			}
+			if setup.Reconfigure {
+				dc.reconfConn = demux.side(dhcpv6.MessageTypeReconfigure)
+				dc.reconf = newReconfState()
 			}
-			rudpconn, err := etherconn.NewRUDPConn(localaddr, dc.cfg.v6econn, etherconn.WithAcceptAny(true))
+			demux.start()
+		}
+		if setup.V4oV6 {
+			dc.d4, err = nclient4.NewWithConn(dc.cfg.withV4Auth(newDHCP4o6Conn(v4o6Conn, dc.cfg.DUID)), dc.cfg.Mac, dc.cfg.v4ClntOptions()...)
 			if err != nil {
-				return nil, fmt.Errorf("failed to create raw udp conn for %v,%v", dc.cfg.Mac, err)
+				return nil, fmt.Errorf("failed to create DHCPv4-over-DHCPv6 client for %v,%v", dc.cfg.Mac, err)
 			}
+		}
+		if setup.EnableV6 {
 			mods := []nclient6.ClientOpt{}
 			if setup.Debug {
 				mods = []nclient6.ClientOpt{nclient6.WithDebugLogger(), nclient6.WithLogDroppedPackets()}
 			}
-			switch dc.cfg.setup.V6MsgType {
-			case dhcpv6.MessageTypeSolicit:
-				dc.d6, err = nclient6.NewWithConn(rudpconn, dc.cfg.Mac, mods...)
-				if err != nil {
-					return nil, fmt.Errorf("failed to create DHCPv6 client for %v, %v", dc.cfg.Mac, err)
-				}
-			case dhcpv6.MessageTypeRelayForward:
-				accessConClnt, accessConRelay := conpair.NewPacketConnPair()
-				dc.d6, err = nclient6.NewWithConn(accessConClnt, dc.cfg.Mac, mods...)
-				if err != nil {
-					return nil, fmt.Errorf("failed to create DHCPv6 client for %v, %v", dc.cfg.Mac, err)
-				}
-				dc.d6relay = dhcpv6relay.NewRelayAgent(context.Background(),
-					&dhcpv6relay.PairDHCPConn{PacketConnPair: accessConRelay},
-					&dhcpv6relay.RUDPDHCPConn{RUDPConn: rudpconn},
-					dhcpv6relay.WithLinkAddr(net.ParseIP("::")),
-					dhcpv6relay.WithPeerAddr(myaddr.GetLLAFromMac(dc.cfg.Mac)),
-					dhcpv6relay.WithOptions(dc.cfg.V6RelayOptions))
-			default:
-				return nil, fmt.Errorf("un-supported DHCPv6 msg type %v", dc.cfg.setup.V6MsgType)
-
+			dc.d6, err = nclient6.NewWithConn(dc.cfg.withV6Auth(v6Conn), dc.cfg.Mac, mods...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create DHCPv6 client for %v, %v", dc.cfg.Mac, err)
 			}
 		}
-		dc.id = getClientIDFromL2Key(key)
-		dc.dialResultCh = r.dialResultCh
-		r.ClntList[dc.id] = dc
 	}
-	//start NDPProxy for DHCPv6
-	if r.setup.EnableV6 {
-		llaList := make(map[string]L2Encap)
-		for _, cfg := range clntConfs {
-			llaList[myaddr.GetLLAFromMac(cfg.Mac).String()] = L2Encap{
-				HwAddr: cfg.Mac,
-				Vlans:  cfg.VLANs,
-			}
+	dc.id = getClientIDFromL2Key(key)
+	return dc, nil
+}
+
+// newGroupClient creates a new client of g, with identities following the last generated client
+func (sch *Sched) newGroupClient(g *clientGroup) (*DClient, error) {
+	if g.gen == nil {
+		return nil, fmt.Errorf("group %v can't generate new clients", g.setup.groupName)
+	}
+	cfg, err := g.gen.next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate new client, %w", err)
+	}
+	dc, err := newDClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new client, %w", err)
+	}
+	dc.dialResultCh = sch.dialResultCh
+	dc.validator = sch.validator
+	if dc.reconfConn != nil {
+		dc.reconfStats = sch.reconfStats
+		dc.arp = sch.ndp
+		sch.goListen(dc.listenReconfigure)
+	}
+	if dc.forceRenewConn != nil {
+		dc.forceRenewStats = sch.forceRenewStats
+		dc.arp = sch.ndp
+		sch.goListen(dc.listenForceRenew)
+	}
+	if sch.ndp != nil && g.setup.v6Transport() {
+		sch.ndp.add(myaddr.GetLLAFromMac(cfg.Mac).String(), L2Encap{
+			HwAddr: cfg.Mac,
+			Vlans:  cfg.VLANs,
+		})
+	}
+	return dc, nil
+}
+
+// saveLeases starts saving leases to lease file if savelease is true,
+// the returned function stops saving, leases dialed after that are not saved
+func (sch *Sched) saveLeases(ctx context.Context) (stop func()) {
+	if !sch.setup.SaveLease {
+		return func() {}
+	}
+	savectx, cancel := context.WithCancel(ctx)
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go saveLeaseToFiles(savectx, wg, sch.setup.saveV4Chan,
+		sch.setup.saveV6Chan, sch.setup.LeaseFile)
+	return func() {
+		cancel()
+		wg.Wait()
+		for _, p := range sch.setup.populations() {
+			p.saveV4Chan, p.saveV6Chan = nil, nil
 		}
-		NewNDPProxyFromRelay(llaList, r.setup.pktRelay)
 	}
-	return r, nil
 }
 
 func (sch *Sched) collectResults(wg *sync.WaitGroup) {
 	defer wg.Done()
-	var beginTime, endTime time.Time
-	totalSuccessTime := time.Duration(0)
-	beginTime = time.Now().AddDate(10, 0, 0)
-	endTime = time.Time{}
 	for r := range sch.dialResultCh {
-		completeTime := r.FinishTime.Sub(r.StartTime)
-		if r.FinishTime.After(endTime) {
-			endTime = r.FinishTime
-		}
-		if completeTime < 0 {
-			completeTime = 0
-		}
-		if r.StartTime.Before(beginTime) {
-			beginTime = r.StartTime
-		}
-		sch.summary.Total++
-		switch r.action {
-		case actionRelease:
-			sch.summary.Released++
-		case actionRebind:
-			sch.summary.Rebinded++
-		case actionRenew:
-			sch.summary.Renewed++
-
-		}
-		switch r.ExecResult {
-		case resultFailure:
-			sch.summary.Failed++
-		case resultSuccess:
-			if r.action == actionDORA {
-				sch.summary.Success++
-				sch.summary.AvgSuccessTime.Add(float64(completeTime))
-				totalSuccessTime += completeTime
-				if completeTime < time.Second {
-					sch.summary.LessThanSecond++
-				}
-				if completeTime > sch.summary.Longest {
-					sch.summary.Longest = completeTime
-				}
-				if completeTime < sch.summary.Shortest {
-					sch.summary.Shortest = completeTime
+		sch.summary.add(r)
+		if r.Group != "" {
+			for _, g := range sch.groups {
+				if g.setup.groupName == r.Group {
+					g.summary.add(r)
+					break
 				}
 			}
-
-		}
-		sch.summary.TotalTime = endTime.Sub(beginTime)
-		if sch.summary.Shortest == maxDuration {
-			sch.summary.Shortest = 0
 		}
 		fmt.Printf("\rdial succed: %7d\t released: %7d\t trans failed: %7d",
 			sch.summary.Success, sch.summary.Released, sch.summary.Failed)
 	}
 
 }
+
+// printSummary prints the overall summary, and summary of each group if there are multiple groups
+func (sch *Sched) printSummary(title string) {
+	total := dhcpv6relay.Stats{}
+	for _, g := range sch.groups {
+		g.summary.RelayStats = g.relayStats()
+		total.Add(g.summary.RelayStats)
+	}
+	sch.summary.RelayStats = total
+	fmt.Printf("\n%v resutls are:\n%v", title, sch.summary)
+	churned := 0
+	for _, g := range sch.groups {
+		churned += g.churned
+	}
+	if churned > 0 {
+		fmt.Printf("Churned clients:%d\n", churned)
+	}
+	if sch.flapper != nil && len(sch.flapper.clients) > 0 {
+		fmt.Printf("%v", sch.flapper)
+	}
+	if sch.reconfStats.Received.Load() > 0 {
+		fmt.Printf("%v", sch.reconfStats)
+	}
+	if sch.forceRenewStats.Received.Load() > 0 {
+		fmt.Printf("%v", sch.forceRenewStats)
+	}
+	if sch.authStats != nil {
+		fmt.Printf("%v", sch.authStats)
+	}
+	if len(sch.setup.groups) == 0 {
+		return
+	}
+	for _, g := range sch.groups {
+		fmt.Printf("\n%v", g.summary)
+		if g.churned > 0 {
+			fmt.Printf("Churned clients:%d\n", g.churned)
+		}
+	}
+}
+
 func (sch *Sched) Stop() {
 	close(sch.dialResultCh)
 }
@@ -909,122 +1377,121 @@ func (sch *Sched) run(ctx context.Context, taskWG *sync.WaitGroup) {
 	default:
 		log.Fatal("invalid action", sch.setup.Action)
 
-	case actionRelease, actionRenew, actionRebind:
+	case actionRelease, actionRenew, actionRebind, actionReboot:
 		threeRWG := new(sync.WaitGroup)
 		for _, c := range sch.ClntList {
 			threeRWG.Add(1)
+			if sch.setup.Action == actionReboot && sch.setup.RebootWindow > 0 {
+				//all clients reboot at a random time within the window, like an access node coming back up
+				go func(c *DClient, delay time.Duration) {
+					time.Sleep(delay)
+					c.threeRAll(ctx, threeRWG, actionReboot)
+				}(c, time.Duration(rand.Int63n(int64(sch.setup.RebootWindow))))
+				continue
+			}
 			go c.threeRAll(ctx, threeRWG, sch.setup.Action)
 			time.Sleep(sch.setup.Interval)
 		}
 		threeRWG.Wait()
-		fmt.Printf("\n%v resutls are:\n%v", sch.setup.Action, sch.summary)
+		sch.printSummary(sch.setup.Action.String())
+	case actionExhaust:
+		stopSaving := sch.saveLeases(ctx)
+		reports := []*exhaustReport{}
+		for _, g := range sch.groups {
+			reports = append(reports, sch.exhaust(ctx, g))
+		}
+		time.Sleep(time.Second)
+		stopSaving()
+		sch.printSummary("pool exhaustion")
+		for _, r := range reports {
+			fmt.Printf("\n%v", r)
+		}
+	case actionConform:
+		table := newConformTable()
+		conformWG := new(sync.WaitGroup)
+		for _, g := range sch.groups {
+			for _, c := range g.clients {
+				conformWG.Add(1)
+				go c.conformAll(ctx, conformWG, table)
+				time.Sleep(g.setup.Interval)
+			}
+		}
+		conformWG.Wait()
+		sch.printSummary("conformance")
+		fmt.Printf("\nConformance Result:\n%v", table)
+	case actionFuzz:
+		fz := newFuzzer(sch.groups)
+		fuzzctx, cancel := context.WithCancel(ctx)
+		fuzzWG := new(sync.WaitGroup)
+		fuzzWG.Add(1)
+		go func() {
+			defer fuzzWG.Done()
+			fz.run(fuzzctx)
+		}()
+		fmt.Printf("\nstart fuzzing with %d clients...\n", len(fz.clients))
+		//normal clients dial while fuzzing clients send malformed messages
+		wg := new(sync.WaitGroup)
+		for _, g := range sch.groups {
+			wg.Add(1)
+			go g.dialAll(wg)
+		}
+		wg.Wait()
+		cancel()
+		fuzzWG.Wait()
+		time.Sleep(time.Second)
+		sch.printSummary("fuzz")
+		fmt.Printf("%v", fz)
 	case actionDORA:
-		//save lease
-		var savectx context.Context
-		var savecancelf context.CancelFunc
-		saveWG := new(sync.WaitGroup)
-		if sch.setup.SaveLease {
-			savectx, savecancelf = context.WithCancel(ctx)
-			saveWG.Add(1)
-			go saveLeaseToFiles(savectx, saveWG, sch.setup.saveV4Chan,
-				sch.setup.saveV6Chan, sch.setup.LeaseFile)
-		}
-		//intial dialing
+		stopSaving := sch.saveLeases(ctx)
+		//intial dialing, each group dials at its own rate
 		wg := new(sync.WaitGroup)
-
-		var err error
-		for _, c := range sch.ClntList {
+		for _, g := range sch.groups {
 			wg.Add(1)
-			go c.dialAll(wg)
-			time.Sleep(sch.setup.Interval)
+			go g.dialAll(wg)
 		}
 		wg.Wait()
 		common.MyLog("dial finished")
 		time.Sleep(time.Second)
-		if sch.setup.SaveLease {
-			savecancelf()
-			saveWG.Wait()
-		}
-		fmt.Printf("\ninitial dialing resutls are:\n%v", sch.summary)
-		if sch.setup.Flapping != nil {
-			if sch.setup.Flapping.FlapNum > 0 {
-				for _, cc := range sch.ClntList {
-					if cc.d4OtherClnt == nil && cc.d4Lease != nil {
-						err = cc.createV4OtherClnt(actionRelease)
-						if err != nil {
-							common.MyLog("%v", err)
-							return
-						}
-					}
-					if cc.d6OtherClnt == nil && cc.d6Lease != nil {
-						err = cc.createV6OtherClnt()
-						if err != nil {
-							common.MyLog("%v", err)
-							return
-						}
-					}
-				}
-				fmt.Printf("\nstart flapping %d clients...\n", sch.setup.Flapping.FlapNum)
-				intervalRange := sch.setup.Flapping.MaxInterval - sch.setup.Flapping.MinInterval
-				flapFunc := func(ctx context.Context, dc *DClient, wg *sync.WaitGroup) {
-					defer wg.Done()
-					for {
-						select {
-						case <-ctx.Done():
-							return
-						default:
-						}
-						time.Sleep(sch.setup.Flapping.MinInterval + time.Duration(rand.Int63n(int64(intervalRange))))
-						select {
-						case <-ctx.Done():
-							return
-						default:
-						}
-						if dc.d4Lease != nil {
-							err = dc.releasev4(nil)
-							if err != nil {
-								common.MyLog("%v", err)
-							}
-						}
-						select {
-						case <-ctx.Done():
-							return
-						default:
-						}
-						if dc.d6Lease != nil {
-							err = dc.releaseOrRenewV6(nil, dhcpv6.MessageTypeRelease)
-							if err != nil {
-								common.MyLog("%v", err)
-							}
-						}
-						select {
-						case <-ctx.Done():
-							return
-						default:
-						}
-						time.Sleep(sch.setup.Flapping.StayDownDur)
-						select {
-						case <-ctx.Done():
-							return
-						default:
-						}
-						dc.dialAll(nil)
-					}
-				}
-				i := 0
-				wg = new(sync.WaitGroup)
-				for _, dc := range sch.ClntList {
-					if i < sch.setup.Flapping.FlapNum {
-						wg.Add(1)
-						go flapFunc(ctx, dc, wg)
-					}
-				}
-				wg.Wait()
-				fmt.Printf("\nFinal result:\n%v", sch.summary)
+		//leases of flapping and churned clients are not saved
+		stopSaving()
+		sch.printSummary("initial dialing")
+		churnWG := new(sync.WaitGroup)
+		churning := false
+		for _, g := range sch.groups {
+			if g.setup.Churn.enabled() {
+				fmt.Printf("\nstart churning...\n")
+				churning = true
+				churnWG.Add(1)
+				go sch.churn(ctx, churnWG, g)
 			}
 		}
+		reconf, forceRenew := false, false
+		for _, g := range sch.groups {
+			reconf = reconf || g.setup.Reconfigure
+			forceRenew = forceRenew || g.setup.ForceRenew
+		}
+		if reconf {
+			fmt.Printf("\nlistening for DHCPv6 Reconfigure...\n")
+		}
+		if forceRenew {
+			fmt.Printf("\nlistening for DHCPv4 FORCERENEW...\n")
+		}
+		listening := reconf || forceRenew
+		sch.flapper = newFlapper(sch.groups)
+		if len(sch.flapper.clients) > 0 {
+			fmt.Printf("\nstart flapping...\n")
+			sch.flapper.run(ctx)
+		}
+		churnWG.Wait()
+		if listening {
+			<-ctx.Done()
+		}
+		if len(sch.flapper.clients) > 0 || churning || listening {
+			sch.printSummary("Final")
+		}
 
 	}
+	sch.stopListening()
 	close(sch.dialResultCh)
 	otherTG.Wait()
 }
@@ -1039,28 +1506,27 @@ func buildSolicit(ccfg clientConfig) (*dhcpv6.Message, error) {
 	for _, o := range ccfg.V6Options {
 		optModList = append(optModList, dhcpv6.WithOption(o))
 	}
-	if ccfg.setup.NeedNA {
-		optModList = append(optModList, dhcpv6.WithIAID(getIAIDviaInt(0)))
-	}
-	if ccfg.setup.NeedPD {
-		optModList = append(optModList, dhcpv6.WithIAPD(getIAIDviaInt(1)))
+	for _, o := range ccfg.V6SolicitOnly {
+		optModList = append(optModList, dhcpv6.WithOption(o))
 	}
-	duid := &dhcpv6.DUIDLLT{
-		HWType:        iana.HWTypeEthernet,
-		Time:          dhcpv6.GetTime(),
-		LinkLayerAddr: ccfg.Mac,
+	iaList, err := ccfg.iaOptions()
+	if err != nil {
+		return nil, err
 	}
 	m, err := dhcpv6.NewMessage()
 	if err != nil {
 		return nil, err
 	}
 	m.MessageType = dhcpv6.MessageTypeSolicit
-	m.AddOption(dhcpv6.OptClientID(duid))
+	m.AddOption(dhcpv6.OptClientID(ccfg.DUID))
 	m.AddOption(dhcpv6.OptRequestedOption(
 		dhcpv6.OptionDNSRecursiveNameServer,
 		dhcpv6.OptionDomainSearchList,
 	))
 	m.AddOption(dhcpv6.OptElapsedTime(0))
+	for _, ia := range iaList {
+		m.AddOption(ia)
+	}
 	for _, mod := range optModList {
 		mod(m)
 	}
@@ -1094,16 +1560,11 @@ func NewRequestFromAdv(adv *dhcpv6.Message, modifiers ...dhcpv6.Modifier) (*dhcp
 	req.AddOption(sid)
 	// add Elapsed Time
 	req.AddOption(dhcpv6.OptElapsedTime(0))
-	// add IA_NA
-	if iana := adv.Options.OneIANA(); iana != nil {
+	// add all IA_NA and IA_PD
+	for _, iana := range adv.Options.IANA() {
 		req.AddOption(iana)
 	}
-	// if iana == nil {
-	// 	return nil, fmt.Errorf("IA_NA cannot be nil in ADVERTISE when building REQUEST")
-	// }
-	// req.AddOption(iana)
-	// add IA_PD
-	if iaPd := adv.GetOneOption(dhcpv6.OptionIAPD); iaPd != nil {
+	for _, iaPd := range adv.Options.IAPD() {
 		req.AddOption(iaPd)
 	}
 	req.AddOption(dhcpv6.OptRequestedOption(
@@ -4,146 +4,314 @@ import (
 	"fmt"
 	"math/big"
 	"net"
-	"strings"
-	"time"
 
 	"github.com/hujun-open/dhcplt/common"
+	"github.com/hujun-open/dhcplt/dhcpv6relay"
 	"github.com/hujun-open/etherconn"
 	"github.com/hujun-open/myaddr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
 )
 
 type clientConfig struct {
 	Mac              net.HardwareAddr
 	VLANs            etherconn.VLANs
-	V4Options        []dhcpv4.Option
-	V6Options        dhcpv6.Options
+	V4Options        []dhcpv4.Option //included in both discover and request
+	V4DiscoverOnly   []dhcpv4.Option
+	V4RequestOnly    []dhcpv4.Option
+	V6Options        dhcpv6.Options //included in both solicit and request
+	V6SolicitOnly    dhcpv6.Options
+	V6RequestOnly    dhcpv6.Options
 	V6RelayOptions   dhcpv6.Options
+	V6RelayHops      []dhcpv6relay.Hop
+	V6LinkAddr       net.IP //link-address of DHCPv6 relay
+	DUID             dhcpv6.DUID
+	authKey          *authKey //key of delayed authentication
+	vars             templateVars
 	setup            *testSetup
 	v4econn, v6econn *etherconn.EtherConn
 }
 
-func genClientConfigurations(setup *testSetup) ([]clientConfig, error) {
+// genClientConfigurations returns configurations of all clients of setup,
+// and a generator that continues after the last client, the generator is nil if clients are loaded from client file
+func genClientConfigurations(setup *testSetup) ([]clientConfig, *clientConfigGenerator, error) {
+	if setup.clientEntries != nil {
+		r, err := genClientConfigurationsFromEntries(setup)
+		return r, nil, err
+	}
 	r := []clientConfig{}
-	clntmac := setup.StartMAC
-	vlans := setup.StartVLANs
-	var err error
+	gen := newClientConfigGenerator(setup)
 	for i := 0; i < int(setup.NumOfClients); i++ {
-		ccfg := clientConfig{}
-		ccfg.setup = setup
-		//assign mac
-		ccfg.Mac = clntmac
-		if i > 0 {
-			ccfg.Mac, err = myaddr.IncMACAddr(clntmac, big.NewInt(int64(setup.MacStep)))
-			if err != nil {
-				return []clientConfig{}, fmt.Errorf("failed to generate mac address,%v", err)
-			}
+		ccfg, err := gen.next()
+		if err != nil {
+			return []clientConfig{}, nil, err
+		}
+		r = append(r, ccfg)
+	}
+	return r, gen, nil
+}
+
+// clientConfigGenerator generates configurations of consecutive clients from starting MAC and VLAN of setup,
+// it could keep generating clients beyond the number of clients
+type clientConfigGenerator struct {
+	setup *testSetup
+	index int //index of next client
+	mac   net.HardwareAddr
+	vlans etherconn.VLANs
+}
 
+func newClientConfigGenerator(setup *testSetup) *clientConfigGenerator {
+	return &clientConfigGenerator{
+		setup: setup,
+		mac:   setup.StartMAC,
+		vlans: setup.StartVLANs,
+	}
+}
+
+// next returns the configuration of next client
+func (gen *clientConfigGenerator) next() (clientConfig, error) {
+	setup := gen.setup
+	i := gen.index
+	var err error
+	ccfg := clientConfig{}
+	ccfg.setup = setup
+	//assign mac
+	ccfg.Mac = gen.mac
+	if i > 0 {
+		ccfg.Mac, err = myaddr.IncMACAddr(gen.mac, big.NewInt(int64(setup.MacStep)))
+		if err != nil {
+			return ccfg, fmt.Errorf("failed to generate mac address,%v", err)
 		}
-		clntmac = ccfg.Mac
-		//assign vlan
-		ccfg.VLANs = vlans.Clone()
 
-		incvidFunc := func(ids, excludes []uint16, step int) ([]uint16, error) {
-			newids := ids
-			for i := 0; i < 10; i++ {
-				newids, err = myaddr.IncreaseVLANIDs(newids, step)
-				if err != nil {
-					return []uint16{}, err
-				}
-				excluded := false
-			L1:
-				for _, v := range newids {
-					for _, exc := range excludes {
-						if v == exc {
-							excluded = true
-							break L1
-						}
+	}
+	//assign vlan
+	ccfg.VLANs = gen.vlans.Clone()
+
+	incvidFunc := func(ids, excludes []uint16, step int) ([]uint16, error) {
+		newids := ids
+		for i := 0; i < 10; i++ {
+			newids, err = myaddr.IncreaseVLANIDs(newids, step)
+			if err != nil {
+				return []uint16{}, err
+			}
+			excluded := false
+		L1:
+			for _, v := range newids {
+				for _, exc := range excludes {
+					if v == exc {
+						excluded = true
+						break L1
 					}
 				}
-				if !excluded {
-					return newids, nil
-				}
 			}
-			return []uint16{}, fmt.Errorf("you shouldn't see this")
+			if !excluded {
+				return newids, nil
+			}
 		}
+		return []uint16{}, fmt.Errorf("you shouldn't see this")
+	}
 
-		if (len(vlans) > 0 && i > 0) || setup.excluded(vlans.IDs()) {
-			rids, err := incvidFunc(vlans.IDs(), setup.ExcludedVLANs, int(setup.VLANStep))
-			if err != nil {
-				return []clientConfig{}, fmt.Errorf("failed to generate vlan id,%v", err)
-			}
-			err = ccfg.VLANs.SetIDs(rids)
-			if err != nil {
-				return []clientConfig{}, fmt.Errorf("failed to generate and apply vlan id,%v", err)
-			}
+	if (len(gen.vlans) > 0 && i > 0) || setup.excluded(gen.vlans.IDs()) {
+		rids, err := incvidFunc(gen.vlans.IDs(), setup.ExcludedVLANs, int(setup.VLANStep))
+		if err != nil {
+			return ccfg, fmt.Errorf("failed to generate vlan id,%v", err)
 		}
-		vlans = ccfg.VLANs
-		//options
-		ccfg.V4Options = []dhcpv4.Option{}
-		ccfg.V4Options = append(ccfg.V4Options, setup.v4Options...)
-		ccfg.V6Options = []dhcpv6.Option{}
-		ccfg.V6Options = append(ccfg.V6Options, setup.v6Options...)
-		genStrFunc := func(s string, id int) string {
-			const varname = "@ID"
-			if strings.Contains(s, varname) {
-				ss := strings.ReplaceAll(s, varname, "%d")
-				return fmt.Sprintf(ss, id)
-			}
-			return s
+		err = ccfg.VLANs.SetIDs(rids)
+		if err != nil {
+			return ccfg, fmt.Errorf("failed to generate and apply vlan id,%v", err)
 		}
+	}
+	vars := templateVars{
+		ID:    i,
+		MAC:   ccfg.Mac,
+		VLANs: ccfg.VLANs.IDs(),
+	}
+	ident := setup.clientIdentity()
+	if !setup.ReqIPStart.IsUnspecified() {
+		reqip, err := myaddr.IncAddr(setup.ReqIPStart.AsSlice(), big.NewInt(int64(i)))
+		if err != nil {
+			return ccfg, fmt.Errorf("failed to generate requested address,%v", err)
+		}
+		ident.ReqIP = reqip.String()
+	}
+	if err = ccfg.genOptions(ident, vars); err != nil {
+		return ccfg, err
+	}
+	ccfg.createEtherConns()
+	gen.mac = ccfg.Mac
+	gen.vlans = ccfg.VLANs
+	gen.index++
+	return ccfg, nil
+}
 
-		if setup.RID != "" || setup.CID != "" {
-			subOptList := []dhcpv4.Option{}
-			if setup.RID != "" {
-				subOptList = append(subOptList, dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte(genStrFunc(setup.RID, i))))
-				ccfg.V6RelayOptions.Add(&dhcpv6.OptRemoteID{
-					EnterpriseNumber: BBFEnterpriseNumber,
-					RemoteID:         []byte(genStrFunc(setup.RID, i)),
-				})
-			}
-			if setup.CID != "" {
-				subOptList = append(subOptList, dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(genStrFunc(setup.CID, i))))
-				ccfg.V6RelayOptions.Add(dhcpv6.OptInterfaceID([]byte((genStrFunc(setup.CID, i)))))
-			}
+// clientIdentity holds templates of per client identifiers and options
+type clientIdentity struct {
+	RID, CID, ClntID, VendorClass string
+	SubscriberID                  string
+	ReqIP                         string //requested IPv4 address in discover
+	V4Options, V6Options          []customOption
+}
 
-			ccfg.V4Options = append(ccfg.V4Options, dhcpv4.OptRelayAgentInfo(subOptList...))
+func (setup *testSetup) clientIdentity() clientIdentity {
+	return clientIdentity{
+		RID:          setup.RID,
+		CID:          setup.CID,
+		ClntID:       setup.ClntID,
+		VendorClass:  setup.VendorClass,
+		SubscriberID: setup.SubscriberID,
+		V4Options:    setup.CustomV4Options,
+		V6Options:    setup.CustomV6Options,
+	}
+}
 
+// genOptions generates DHCP options of ccfg from ident, with template variables vars
+func (ccfg *clientConfig) genOptions(ident clientIdentity, vars templateVars) error {
+	var err error
+	ccfg.vars = vars
+	ccfg.V4Options = []dhcpv4.Option{}
+	ccfg.V6Options = []dhcpv6.Option{}
+	clntID := genStrFromTemplate(ident.ClntID, vars)
+	ccfg.DUID, err = ccfg.setup.genDUID(clntID, ccfg.Mac)
+	if err != nil {
+		return err
+	}
+	if ident.VendorClass != "" {
+		vc := genStrFromTemplate(ident.VendorClass, vars)
+		ccfg.V4Options = append(ccfg.V4Options, dhcpv4.OptClassIdentifier(vc))
+		ccfg.V6Options.Add(&dhcpv6.OptVendorClass{
+			EnterpriseNumber: BBFEnterpriseNumber,
+			Data:             [][]byte{[]byte(vc)},
+		})
+	}
+	if ident.RID != "" || ident.CID != "" {
+		subOptList := []dhcpv4.Option{}
+		if ident.RID != "" {
+			rid := []byte(genStrFromTemplate(ident.RID, vars))
+			subOptList = append(subOptList, dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, rid))
+			ccfg.V6RelayOptions.Add(&dhcpv6.OptRemoteID{
+				EnterpriseNumber: BBFEnterpriseNumber,
+				RemoteID:         rid,
+			})
 		}
-		if setup.ClntID != "" {
-			common.MyLog("gened clnt id is %v", genStrFunc(setup.ClntID, i))
-			ccfg.V4Options = append(ccfg.V4Options, dhcpv4.OptClientIdentifier([]byte(genStrFunc(setup.ClntID, i))))
-			ccfg.V6Options.Add(dhcpv6.OptClientID(
-				&dhcpv6.DUIDEN{
-					EnterpriseNumber:     BBFEnterpriseNumber,
-					EnterpriseIdentifier: []byte(genStrFunc(setup.ClntID, i)),
-				}))
+		if ident.CID != "" {
+			cid := []byte(genStrFromTemplate(ident.CID, vars))
+			subOptList = append(subOptList, dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, cid))
+			ccfg.V6RelayOptions.Add(dhcpv6.OptInterfaceID(cid))
 		}
-		if setup.EnableV4 {
-			ccfg.v4econn = etherconn.NewEtherConn(ccfg.Mac, setup.pktRelay,
-				etherconn.WithVLANs(ccfg.VLANs),
-				etherconn.WithEtherTypes([]uint16{EthernetTypeIPv4}))
+
+		ccfg.V4Options = append(ccfg.V4Options, dhcpv4.OptRelayAgentInfo(subOptList...))
+
+	}
+	if ident.SubscriberID != "" {
+		ccfg.V6RelayOptions.Add(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionRelayAgentSubscriberID,
+			OptionData: []byte(genStrFromTemplate(ident.SubscriberID, vars)),
+		})
+	}
+	if ccfg.setup.Reconfigure || ccfg.setup.Auth == authReconfKey {
+		ccfg.V6Options.Add(optReconfAccept())
+	}
+	if ccfg.setup.Auth == authDelayed {
+		if ccfg.authKey, err = ccfg.setup.authKeyOf(ccfg.Mac); err != nil {
+			return err
 		}
-		if setup.EnableV6 {
-			ccfg.v6econn = etherconn.NewEtherConn(ccfg.Mac, setup.pktRelay,
-				etherconn.WithVLANs(ccfg.VLANs),
-				etherconn.WithEtherTypes([]uint16{EthernetTypeIPv6}))
+	}
+	if ccfg.setup.ForceRenewNonce {
+		ccfg.V4Options = append(ccfg.V4Options, optForceRenewNonceCapable())
+	}
+	if ccfg.setup.ClientLLAddr {
+		ccfg.V6RelayOptions.Add(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, ccfg.Mac))
+	}
+	if ccfg.setup.RelaySrcPort {
+		//emulated relay agent receives from client directly, so downstream source port is 0
+		ccfg.V6RelayOptions.Add(dhcpv6.OptRelayPort(0))
+	}
+	if len(ccfg.setup.RadiusAttrs) > 0 {
+		op, err := radiusOption(ccfg.setup.RadiusAttrs, vars)
+		if err != nil {
+			return err
 		}
-		r = append(r, ccfg)
+		ccfg.V6RelayOptions.Add(op)
 	}
-	return r, nil
+	if ident.ClntID != "" {
+		common.MyLog("gened clnt id is %v", clntID)
+		ccfg.V4Options = append(ccfg.V4Options, dhcpv4.OptClientIdentifier([]byte(clntID)))
+	}
+	if ident.ReqIP != "" {
+		reqip := net.ParseIP(genStrFromTemplate(ident.ReqIP, vars))
+		if reqip == nil || reqip.To4() == nil {
+			return fmt.Errorf("%v is not a valid requested IPv4 address", ident.ReqIP)
+		}
+		ccfg.V4DiscoverOnly = append(ccfg.V4DiscoverOnly, dhcpv4.OptRequestedIPAddress(reqip))
+	}
+	for _, co := range ident.V4Options {
+		op, err := co.v4Option(vars)
+		if err != nil {
+			return err
+		}
+		switch {
+		case co.appliesTo(optMsgDiscover) && co.appliesTo(optMsgRequest):
+			ccfg.V4Options = append(ccfg.V4Options, op)
+		case co.appliesTo(optMsgDiscover):
+			ccfg.V4DiscoverOnly = append(ccfg.V4DiscoverOnly, op)
+		case co.appliesTo(optMsgRequest):
+			ccfg.V4RequestOnly = append(ccfg.V4RequestOnly, op)
+		}
+	}
+	for _, co := range ident.V6Options {
+		op, err := co.v6Option(vars)
+		if err != nil {
+			return err
+		}
+		switch {
+		case co.appliesTo(optMsgSolicit) && co.appliesTo(optMsgRequest):
+			ccfg.V6Options.Add(op)
+		case co.appliesTo(optMsgSolicit):
+			ccfg.V6SolicitOnly.Add(op)
+		case co.appliesTo(optMsgRequest):
+			ccfg.V6RequestOnly.Add(op)
+		}
+		if co.appliesTo(optMsgRelay) {
+			ccfg.V6RelayOptions.Add(op)
+		}
+	}
+	if ccfg.V6LinkAddr, err = ccfg.setup.v6LinkAddr(vars); err != nil {
+		return err
+	}
+	ccfg.V6RelayHops = nil
+	for _, rh := range ccfg.setup.RelayHops {
+		h, err := rh.hop(vars)
+		if err != nil {
+			return err
+		}
+		ccfg.V6RelayHops = append(ccfg.V6RelayHops, h)
+	}
+	return nil
 }
 
-type FlappingConf struct {
-	FlapNum     int           `alias:"flapnum" usage:"number of client flapping"`
-	MinInterval time.Duration `alias:"flapmaxinterval" usage:"minimal flapping interval"`
-	MaxInterval time.Duration `alias:"flapmininterval" usage:"max flapping interval"`
-	StayDownDur time.Duration `alias:"flapstaydowndur" usage:"duriation of stay down"`
+func (ccfg *clientConfig) createEtherConns() {
+	if ccfg.setup.EnableV4 && !ccfg.setup.V4oV6 {
+		ccfg.v4econn = etherconn.NewEtherConn(ccfg.Mac, ccfg.setup.pktRelay,
+			etherconn.WithVLANs(ccfg.VLANs),
+			etherconn.WithEtherTypes([]uint16{EthernetTypeIPv4}))
+	}
+	if ccfg.setup.v6Transport() {
+		ccfg.v6econn = etherconn.NewEtherConn(ccfg.Mac, ccfg.setup.pktRelay,
+			etherconn.WithVLANs(ccfg.VLANs),
+			etherconn.WithEtherTypes([]uint16{EthernetTypeIPv6}))
+	}
 }
 
-const (
-	defaultMinFlapInt   = 5 * time.Second
-	defualtMaxFlapInt   = 30 * time.Second
-	defaultFlapStayDown = 10 * time.Second
-)
+// v4ClntOptions returns options of the DHCPv4 client for dialing
+func (ccfg *clientConfig) v4ClntOptions() []nclient4.ClientOpt {
+	clntModList := []nclient4.ClientOpt{
+		nclient4.WithRetry(int(ccfg.setup.Retry)),
+		nclient4.WithTimeout(ccfg.setup.Timeout),
+	}
+	if ccfg.setup.Debug {
+		clntModList = append(clntModList, nclient4.WithDebugLogger())
+	}
+	return append(clntModList, nclient4.WithHWAddr(ccfg.Mac))
+}
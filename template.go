@@ -0,0 +1,245 @@
+// template
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// templateVars holds the per client values of template variables
+type templateVars struct {
+	ID    int
+	MAC   net.HardwareAddr
+	VLANs []uint16 //outer most tag first
+}
+
+// following variables could be used in a template as "@NAME",
+// or "@{NAME[+offset][%modulo][:format]}":
+//   - ID: client index, decimal
+//   - IDHEX: client index, lowercase hex
+//   - MAC: client MAC, aa:bb:cc:dd:ee:ff; format could be colon|dash|dot|raw, upper case format means upper case output
+//   - MACDASH, MACDOT, MACRAW: client MAC in aa-bb-cc-dd-ee-ff, aabb.ccdd.eeff, aabbccddeeff format
+//   - SVLAN: outer VLAN ID, 0 if there is less than 2 tags
+//   - CVLAN: inner most VLAN ID, 0 if there is no tag
+//   - VLAN: all VLAN IDs, separated by "."
+//   - RAND, RANDHEX, RANDNUM: random string of alphanumeric, hex or digits, format is the length, default is 8
+//
+// offset and modulo only apply to ID, IDHEX, SVLAN and CVLAN, format for them is a fmt verb without "%", e.g. "04d"
+var templateVarNames = []string{
+	"ID", "IDHEX",
+	"MAC", "MACDASH", "MACDOT", "MACRAW",
+	"SVLAN", "CVLAN", "VLAN",
+	"RAND", "RANDHEX", "RANDNUM",
+}
+
+// longestVarName returns the longest variable name that is a prefix of s
+func longestVarName(s string) (r string) {
+	for _, name := range templateVarNames {
+		if strings.HasPrefix(s, name) && len(name) > len(r) {
+			r = name
+		}
+	}
+	return
+}
+
+func (vars templateVars) svlan() int {
+	if len(vars.VLANs) < 2 {
+		return 0
+	}
+	return int(vars.VLANs[0])
+}
+
+func (vars templateVars) cvlan() int {
+	if len(vars.VLANs) == 0 {
+		return 0
+	}
+	return int(vars.VLANs[len(vars.VLANs)-1])
+}
+
+func formatMAC(mac net.HardwareAddr, format string) (string, error) {
+	raw := strings.ReplaceAll(mac.String(), ":", "")
+	var r string
+	switch strings.ToLower(format) {
+	case "", "colon":
+		r = mac.String()
+	case "dash":
+		r = strings.ReplaceAll(mac.String(), ":", "-")
+	case "raw":
+		r = raw
+	case "dot":
+		for i := 0; i+4 <= len(raw); i += 4 {
+			if i > 0 {
+				r += "."
+			}
+			r += raw[i : i+4]
+		}
+	default:
+		return "", fmt.Errorf("unknown MAC format %v", format)
+	}
+	if format != "" && format == strings.ToUpper(format) {
+		r = strings.ToUpper(r)
+	}
+	return r, nil
+}
+
+func randStr(charset string, n int) string {
+	r := make([]byte, n)
+	for i := range r {
+		r[i] = charset[rand.Intn(len(charset))]
+	}
+	return string(r)
+}
+
+// evalExpr evaluates a variable expression, "NAME[+offset][%modulo][:format]"
+func (vars templateVars) evalExpr(expr string) (string, error) {
+	name := longestVarName(expr)
+	if name == "" {
+		return "", fmt.Errorf("unknown variable in %v", expr)
+	}
+	rest, format, _ := strings.Cut(expr[len(name):], ":")
+	var n int
+	switch name {
+	case "ID", "IDHEX":
+		n = vars.ID
+		if name == "IDHEX" && format == "" {
+			format = "x"
+		}
+	case "SVLAN":
+		n = vars.svlan()
+	case "CVLAN":
+		n = vars.cvlan()
+	default:
+		if rest != "" {
+			return "", fmt.Errorf("offset and modulo can't be used with %v", name)
+		}
+	}
+	switch name {
+	case "MAC":
+		return formatMAC(vars.MAC, format)
+	case "MACDASH":
+		return formatMAC(vars.MAC, "dash")
+	case "MACDOT":
+		return formatMAC(vars.MAC, "dot")
+	case "MACRAW":
+		return formatMAC(vars.MAC, "raw")
+	case "VLAN":
+		ids := []string{}
+		for _, v := range vars.VLANs {
+			ids = append(ids, strconv.Itoa(int(v)))
+		}
+		return strings.Join(ids, "."), nil
+	case "RAND", "RANDHEX", "RANDNUM":
+		l := 8
+		if format != "" {
+			var err error
+			if l, err = strconv.Atoi(format); err != nil || l <= 0 {
+				return "", fmt.Errorf("invalid random string length %v", format)
+			}
+		}
+		charset := map[string]string{
+			"RAND":    "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+			"RANDHEX": "0123456789abcdef",
+			"RANDNUM": "0123456789",
+		}[name]
+		return randStr(charset, l), nil
+	}
+	//numeric variables, apply offset and modulo
+	for rest != "" {
+		op := rest[0]
+		end := strings.IndexAny(rest[1:], "+-%")
+		if end < 0 {
+			end = len(rest)
+		} else {
+			end++
+		}
+		v, err := strconv.Atoi(rest[1:end])
+		if err != nil {
+			return "", fmt.Errorf("invalid number in %v", expr)
+		}
+		switch op {
+		case '+':
+			n += v
+		case '-':
+			n -= v
+		case '%':
+			if v == 0 {
+				return "", fmt.Errorf("modulo can't be zero in %v", expr)
+			}
+			n %= v
+		default:
+			return "", fmt.Errorf("unknown operator %c in %v", op, expr)
+		}
+		rest = rest[end:]
+	}
+	if format == "" {
+		format = "d"
+	}
+	r := fmt.Sprintf("%"+format, n)
+	if strings.Contains(r, "%!") {
+		return "", fmt.Errorf("invalid format %v in %v", format, expr)
+	}
+	return r, nil
+}
+
+// expandTemplate returns s with all variables replaced by values in vars
+func expandTemplate(s string, vars templateVars) (string, error) {
+	if !strings.Contains(s, "@") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '@' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		rest := s[i+1:]
+		if strings.HasPrefix(rest, "{") {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return "", fmt.Errorf("missing closing brace in %v", s)
+			}
+			v, err := vars.evalExpr(rest[1:end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i += end + 2
+			continue
+		}
+		if name := longestVarName(rest); name != "" {
+			v, err := vars.evalExpr(name)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i += len(name) + 1
+			continue
+		}
+		b.WriteByte('@')
+		i++
+	}
+	return b.String(), nil
+}
+
+// genStrFromTemplate is same as expandTemplate, except it returns s as it is if expansion failed,
+// templates should be checked by validateTemplate before use
+func genStrFromTemplate(s string, vars templateVars) string {
+	r, err := expandTemplate(s, vars)
+	if err != nil {
+		return s
+	}
+	return r
+}
+
+// validateTemplate returns error if s is not a valid template
+func validateTemplate(s string) error {
+	_, err := expandTemplate(s, templateVars{
+		MAC:   net.HardwareAddr{0, 0, 0, 0, 0, 0},
+		VLANs: []uint16{1, 1},
+	})
+	return err
+}
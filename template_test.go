@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestTemplate(t *testing.T) {
+	vars := templateVars{
+		ID:    10,
+		MAC:   net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x11, 0x22, 0x33},
+		VLANs: []uint16{100, 200},
+	}
+	testList := []struct {
+		tmpl       string
+		expected   string
+		shouldFail bool
+	}{
+		{tmpl: "Client-@ID", expected: "Client-10"},
+		{tmpl: "no variable", expected: "no variable"},
+		{tmpl: "eth 1/1/@SVLAN:@CVLAN", expected: "eth 1/1/100:200"},
+		{tmpl: "@IDHEX-@{ID:04d}", expected: "a-0010"},
+		{tmpl: "@{ID+5%4}", expected: "3"},
+		{tmpl: "@{ID-1:x}", expected: "9"},
+		{tmpl: "@MAC|@MACDASH|@MACDOT|@MACRAW", expected: "aa:bb:cc:11:22:33|aa-bb-cc-11-22-33|aabb.cc11.2233|aabbcc112233"},
+		{tmpl: "@{MAC:RAW}", expected: "AABBCC112233"},
+		{tmpl: "vlan@VLAN", expected: "vlan100.200"},
+		{tmpl: "user@example.com", expected: "user@example.com"},
+		{tmpl: "@{ID%0}", shouldFail: true},
+		{tmpl: "@{FOO}", shouldFail: true},
+		{tmpl: "@{MAC+1}", shouldFail: true},
+		{tmpl: "@{ID", shouldFail: true},
+	}
+	for i, c := range testList {
+		r, err := expandTemplate(c.tmpl, vars)
+		if err != nil {
+			if c.shouldFail {
+				t.Logf("case %d failed as expected, %v", i, err)
+				continue
+			}
+			t.Fatalf("case %d failed, %v", i, err)
+		}
+		if c.shouldFail {
+			t.Fatalf("case %d succeed but should fail", i)
+		}
+		if r != c.expected {
+			t.Fatalf("case %d expect %v but got %v", i, c.expected, r)
+		}
+	}
+	r, err := expandTemplate("@{RANDHEX:12}", vars)
+	if err != nil || len(r) != 12 {
+		t.Fatalf("failed to generate random string, %v, %v", r, err)
+	}
+}
+
+// TestGenOptionsRandom checks a random template is expanded once for all options derived from it
+func TestGenOptionsRandom(t *testing.T) {
+	setup := newDefaultConf()
+	setup.RID = "@{RAND:16}"
+	setup.CID = "@{RAND:16}"
+	setup.ClntID = "@{RANDHEX:16}"
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	ccfg := &clientConfig{Mac: mac, setup: setup}
+	if err := ccfg.genOptions(setup.clientIdentity(), templateVars{ID: 1, MAC: mac}); err != nil {
+		t.Fatal(err)
+	}
+	rid := ccfg.V6RelayOptions.GetOne(dhcpv6.OptionRemoteID).(*dhcpv6.OptRemoteID).RemoteID
+	cid := ccfg.V6RelayOptions.GetOne(dhcpv6.OptionInterfaceID).ToBytes()
+	expected := dhcpv4.OptRelayAgentInfo(
+		dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, rid),
+		dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, cid))
+	var clntID []byte
+	for _, op := range ccfg.V4Options {
+		switch op.Code {
+		case dhcpv4.OptionRelayAgentInformation:
+			if !bytes.Equal(op.Value.ToBytes(), expected.Value.ToBytes()) {
+				t.Fatalf("option 82 %x doesn't match remote-id %q and interface-id %q", op.Value.ToBytes(), rid, cid)
+			}
+		case dhcpv4.OptionClientIdentifier:
+			clntID = op.Value.ToBytes()
+		}
+	}
+	duid, ok := ccfg.DUID.(*dhcpv6.DUIDEN)
+	if !ok || !bytes.Equal(duid.EnterpriseIdentifier, clntID) {
+		t.Fatalf("DUID %v doesn't match client identifier %q", ccfg.DUID, clntID)
+	}
+}
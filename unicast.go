@@ -0,0 +1,101 @@
+// unicast
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+// v4SrcConn is the raw udp conn of d4, unicast messages are sent from src if it is set;
+// it lets d4 act as a client in BOUND or RENEWING state, instead of creating another client on the same EtherConn,
+// which would steal packets of d4
+type v4SrcConn struct {
+	*etherconn.RUDPConn
+	lock *sync.Mutex //serializes unicast exchanges
+	src  atomic.Pointer[net.UDPAddr]
+}
+
+func newV4SrcConn(conn *etherconn.RUDPConn) *v4SrcConn {
+	return &v4SrcConn{RUDPConn: conn, lock: new(sync.Mutex)}
+}
+
+func (c *v4SrcConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if src := c.src.Load(); src != nil {
+		if dst, ok := addr.(*net.UDPAddr); ok && !dst.IP.Equal(net.IPv4bcast) {
+			return c.WriteToFrom(p, src, addr)
+		}
+	}
+	return c.RUDPConn.WriteTo(p, addr)
+}
+
+// v4LocalPort returns the local port of DHCPv4 raw udp conn
+func (setup *testSetup) v4LocalPort() int {
+	localPort := dhcpv4.ClientPort
+	if !setup.GiAddr.IsUnspecified() {
+		localPort = dhcpv4.ServerPort
+	}
+	if setup.SourceV4Port != dhcpv4.ClientPort {
+		localPort = int(setup.SourceV4Port)
+	}
+	return localPort
+}
+
+// v4LeaseSrcAddr returns the source address of unicast messages for lease,
+// which is the leased address unless source address is specified
+func (dc *DClient) v4LeaseSrcAddr(lease *v4Lease) *net.UDPAddr {
+	r := &net.UDPAddr{IP: lease.Lease.ACK.YourIPAddr, Port: dc.cfg.setup.v4LocalPort()}
+	if !dc.cfg.setup.SourceV4Addr.IsUnspecified() {
+		r.IP = dc.cfg.setup.SourceV4Addr.AsSlice()
+	}
+	return r
+}
+
+// unicastV4 runs f with unicast messages of d4 sent from the source address of lease;
+// f runs as is if d4 is not on a raw udp conn, e.g. DHCPv4 over DHCPv6
+func (dc *DClient) unicastV4(lease *v4Lease, f func() error) error {
+	if dc.v4conn == nil {
+		return f()
+	}
+	dc.v4conn.lock.Lock()
+	defer dc.v4conn.lock.Unlock()
+	dc.v4conn.src.Store(dc.v4LeaseSrcAddr(lease))
+	defer dc.v4conn.src.Store(nil)
+	return f()
+}
+
+// renewV4 renews lease with a Request unicast to the server of lease, from the leased address;
+// d4OtherClnt is used if it is created, since it is already a unicast client of the lease
+func (dc *DClient) renewV4(ctx context.Context, lease *v4Lease, mods ...dhcpv4.Modifier) (*nclient4.Lease, error) {
+	dl := nclient4.Lease(*lease.Lease)
+	if dc.d4OtherClnt != nil {
+		return dc.d4OtherClnt.Renew(ctx, &dl, mods...)
+	}
+	req, err := dhcpv4.NewRenewFromAck(lease.Lease.ACK, dhcpv4.PrependModifiers(mods,
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(nclient4.MaxMessageSize)))...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a request: %w", err)
+	}
+	svrAddr := &net.UDPAddr{IP: lease.Lease.ACK.ServerIdentifier(), Port: dhcpv4.ServerPort}
+	var resp *dhcpv4.DHCPv4
+	err = dc.unicastV4(lease, func() (err error) {
+		resp, err = dc.d4.SendAndRead(ctx, svrAddr, req, nclient4.IsAll(
+			nclient4.IsCorrectServer(lease.Lease.ACK.ServerIdentifier()),
+			nclient4.IsMessageType(dhcpv4.MessageTypeAck, dhcpv4.MessageTypeNak)))
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("got an error while processing the request: %w", err)
+	}
+	if resp.MessageType() == dhcpv4.MessageTypeNak {
+		return nil, &nclient4.ErrNak{Offer: lease.Lease.Offer, Nak: resp}
+	}
+	return &nclient4.Lease{Offer: lease.Lease.Offer, ACK: resp, CreationTime: time.Now()}, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/hujun-open/etherconn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// fakeV4Relay is a PacketRelay of a single EtherConn, it ACKs DHCPv4 requests,
+// and reports IP header of every DHCPv4 message sent by the EtherConn
+type fakeV4Relay struct {
+	svrID net.IP
+	recv  chan *etherconn.RelayReceival
+	send  chan []byte
+	stop  chan struct{}
+	sent  chan *layers.IPv4
+}
+
+func newFakeV4Relay(svrID net.IP) *fakeV4Relay {
+	r := &fakeV4Relay{
+		svrID: svrID,
+		recv:  make(chan *etherconn.RelayReceival, 16),
+		send:  make(chan []byte, 16),
+		stop:  make(chan struct{}),
+		sent:  make(chan *layers.IPv4, 16),
+	}
+	go r.serve()
+	return r
+}
+
+func (r *fakeV4Relay) serve() {
+	for {
+		var frame []byte
+		select {
+		case <-r.stop:
+			return
+		case frame = <-r.send:
+		}
+		pkt := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+		ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		if !ok {
+			continue
+		}
+		udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if !ok {
+			continue
+		}
+		req, err := dhcpv4.FromBytes(udp.Payload)
+		if err != nil {
+			continue
+		}
+		r.sent <- ip
+		if req.MessageType() != dhcpv4.MessageTypeRequest {
+			continue
+		}
+		ack, _ := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+			dhcpv4.WithYourIP(req.ClientIPAddr),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(r.svrID)),
+			dhcpv4.WithLeaseTime(3600))
+		r.recv <- &etherconn.RelayReceival{
+			TransportPayloadBytes: ack.ToBytes(),
+			RemoteIP:              r.svrID,
+			RemotePort:            dhcpv4.ServerPort,
+			LocalIP:               ip.SrcIP,
+			LocalPort:             uint16(udp.SrcPort),
+			Protocol:              17,
+		}
+	}
+}
+
+func (r *fakeV4Relay) Register(ks []etherconn.L2EndpointKey, recvMulticast bool) (chan *etherconn.RelayReceival, chan []byte, chan struct{}) {
+	return r.recv, r.send, r.stop
+}
+
+func (r *fakeV4Relay) RegisterDefault() (chan *etherconn.RelayReceival, chan []byte, chan struct{}) {
+	return r.recv, r.send, r.stop
+}
+
+func (r *fakeV4Relay) Deregister(ks []etherconn.L2EndpointKey) {}
+
+func (r *fakeV4Relay) Stop() {
+	close(r.stop)
+}
+
+func (r *fakeV4Relay) IfName() string {
+	return "fake"
+}
+
+func (r *fakeV4Relay) GetStats() *etherconn.RelayPacketStats {
+	return new(etherconn.RelayPacketStats)
+}
+
+func (r *fakeV4Relay) Type() etherconn.RelayType {
+	return etherconn.RelayTypeAFP
+}
+
+// inject delivers a DHCPv4 message buf from the server to the EtherConn
+func (r *fakeV4Relay) inject(buf []byte) {
+	r.recv <- &etherconn.RelayReceival{
+		TransportPayloadBytes: buf,
+		RemoteIP:              r.svrID,
+		RemotePort:            dhcpv4.ServerPort,
+		LocalPort:             dhcpv4.ClientPort,
+		Protocol:              17,
+	}
+}
+
+// checkSent checks the source and destination of next message sent via r
+func (r *fakeV4Relay) checkSent(t *testing.T, src, dst net.IP) {
+	t.Helper()
+	select {
+	case ip := <-r.sent:
+		if !ip.SrcIP.Equal(src) || !ip.DstIP.Equal(dst) {
+			t.Fatalf("expect message from %v to %v, got from %v to %v", src, dst, ip.SrcIP, ip.DstIP)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message is sent")
+	}
+}
+
+// newFakeV4Client returns a client with a lease of 192.0.2.100 from svrID, over a fakeV4Relay
+func newFakeV4Client(t *testing.T, svrID net.IP, setup *testSetup) (*DClient, *fakeV4Relay) {
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	relay := newFakeV4Relay(svrID)
+	t.Cleanup(relay.Stop)
+	setup.Timeout = time.Second
+	dc, err := newDClient(clientConfig{
+		Mac:     mac,
+		setup:   setup,
+		v4econn: etherconn.NewEtherConn(mac, relay),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.d4Lease = genV4Lease(t, svrID, mac, nil)
+	dc.dialResultCh = make(chan *dialResult, 1)
+	return dc, relay
+}
+
+func TestFlapUnicast(t *testing.T) {
+	svrID := net.ParseIP("192.0.2.1")
+	leased := net.ParseIP("192.0.2.100")
+	dc, relay := newFakeV4Client(t, svrID, newDefaultConf())
+	f := newFlapper(nil)
+	dc.cfg.setup.Flapping.Renew = true
+	if err := f.cycle(context.Background(), dc, false); err != nil {
+		t.Fatal(err)
+	}
+	relay.checkSent(t, leased, svrID)
+	if r := <-dc.dialResultCh; r.action != actionRenew || r.ExecResult != resultSuccess {
+		t.Fatalf("wrong renew result %+v", r)
+	}
+	//release of flap
+	if err := dc.releasev4(nil); err != nil {
+		t.Fatal(err)
+	}
+	relay.checkSent(t, leased, svrID)
+	//broadcast after release is sent from unspecified address
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	dc.d4.DiscoverOffer(ctx)
+	relay.checkSent(t, net.IPv4zero, net.IPv4bcast)
+}
+
+func TestForceRenewUnicast(t *testing.T) {
+	nonce := bytes.Repeat([]byte{0xcd}, authDigestLen)
+	svrID := net.ParseIP("192.0.2.1")
+	setup := newDefaultConf()
+	setup.ForceRenew = true
+	setup.ForceRenewNonce = true
+	dc, relay := newFakeV4Client(t, svrID, setup)
+	defer dc.forceRenewConn.Close()
+	dc.d4Lease = genV4Lease(t, svrID, dc.cfg.Mac, nonce)
+	dc.forceRenew.update(dc.d4Lease.Lease.ACK)
+	dc.forceRenewStats = new(forceRenewStats)
+	go dc.listenForceRenew()
+	relay.inject(genForceRenew(t, svrID, dc.cfg.Mac, 2, nonce))
+	relay.checkSent(t, net.ParseIP("192.0.2.100"), svrID)
+	if r := <-dc.dialResultCh; r.action != actionRenew || r.ExecResult != resultSuccess {
+		t.Fatalf("wrong renew result %+v", r)
+	}
+	if dc.forceRenewStats.Renewed.Load() != 1 {
+		t.Fatalf("wrong stats\n%v", dc.forceRenewStats)
+	}
+}